@@ -0,0 +1,40 @@
+// flv2mp4 把FLV文件转换为moov在前的MP4文件。
+//
+//	flv2mp4 input.flv output.mp4
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/chenyj/rtmp/encoding/mp4"
+)
+
+func main() {
+	if len(os.Args) != 3 {
+		fmt.Fprintln(os.Stderr, "usage: flv2mp4 input.flv output.mp4")
+		os.Exit(2)
+	}
+	if err := remux(os.Args[1], os.Args[2]); err != nil {
+		fmt.Fprintln(os.Stderr, "flv2mp4:", err)
+		os.Exit(1)
+	}
+}
+
+func remux(input, output string) error {
+	src, err := os.Open(input)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if err = mp4.RemuxFLV(dst, src); err != nil {
+		dst.Close()
+		os.Remove(output)
+		return err
+	}
+	return dst.Close()
+}
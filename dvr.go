@@ -0,0 +1,322 @@
+package rtmp
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chenyj/rtmp/encoding/av"
+	"github.com/chenyj/rtmp/encoding/flv"
+)
+
+// 时移分段的默认时长
+const defaultSegmentDuration = 10 * time.Second
+
+// TimeShift 直播时移窗口的配置。
+// 窗口中的数据按关键帧分段保存，超出窗口的分段整段删除。
+type TimeShift struct {
+	Window  time.Duration // 保存最近多长时间的数据，0表示关闭时移
+	Segment time.Duration // 分段的最短时长，在关键帧处切分，默认10秒
+	Dir     string        // 不为空时分段写入该目录下的FLV文件，否则保存在内存中
+}
+
+// PlayStart 返回play请求在流s中的开始时间，毫秒。
+// ?t=参数为负数时表示相对直播点的秒数，如?t=-300从5分钟前开始；
+// start参数大于0时表示流中的秒数，很多播放器默认发送0，所以0也表示从直播点开始。
+// 都没有时ok为false。
+func (r *Request) PlayStart(s Streamer) (ms uint32, ok bool) {
+	oldest, latest := s.TimeRange()
+	var pos int64
+	if t, err := strconv.ParseFloat(r.Form.Get("t"), 64); err == nil && t < 0 {
+		pos = int64(latest) + int64(t*1000)
+	} else if r.Start > 0 {
+		pos = int64(r.Start * 1000)
+	} else {
+		return 0, false
+	}
+	if pos < int64(oldest) {
+		pos = int64(oldest)
+	}
+	if pos > int64(latest) {
+		pos = int64(latest)
+	}
+	return uint32(pos), true
+}
+
+// 分段中的一个数据包
+type dvrEntry struct {
+	sequence   uint64 // 在流队列中的编号
+	generation uint32
+	timestamp  uint32
+	seekable   bool       // 是否可以从这里开始播放
+	offset     int64      // 在分段文件中的偏移
+	packet     *av.Packet // 内存中的数据包，写入文件时为nil
+}
+
+// 时移分段，从可定位的数据包开始
+type dvrSegment struct {
+	duration   time.Duration // 按时间戳计算的时长
+	configs    []*av.Packet  // 分段开始时的配置帧
+	generation uint32
+	entries    []dvrEntry
+	next       *dvrSegment
+	removed    bool
+	f          *os.File
+	w          *flv.TagWriter
+}
+
+// 从文件中读取数据包
+func (seg *dvrSegment) load(e dvrEntry) (*av.Packet, error) {
+	r := flv.NewTagReader(io.NewSectionReader(seg.f, e.offset, 1<<62))
+	h, data, err := r.ReadTag()
+	if err != nil {
+		return nil, err
+	}
+	return tagPacket(h, data), nil
+}
+
+// 删除分段文件，调用者已在持有mu时设置removed
+func (seg *dvrSegment) remove() {
+	if seg.f != nil {
+		seg.f.Close()
+		os.Remove(seg.f.Name())
+	}
+}
+
+// 写入时移窗口的数据包
+type dvrPacket struct {
+	sequence   uint64
+	generation uint32
+	packet     *av.Packet
+	seekable   bool
+	configs    []*av.Packet // 可定位时的配置帧
+	hasAudio   bool
+	hasVideo   bool
+}
+
+// 时移窗口，由流的写者写入，订阅者通过dvrCursor读取。
+// 文件读写不持有mu，写者之间由wmu保证顺序
+type dvr struct {
+	opt      TimeShift
+	mu       sync.RWMutex
+	segments []*dvrSegment
+	closed   bool
+	wmu      sync.Mutex
+	last     uint32 // 最后写入的时间戳
+	started  bool
+}
+
+func newDVR(opt TimeShift) *dvr {
+	if opt.Segment <= 0 {
+		opt.Segment = defaultSegmentDuration
+	}
+	return &dvr{opt: opt}
+}
+
+// write 记录一个写入队列的数据包。
+// 分段只在可定位的数据包处切分，分段和窗口的时长按数据包的时间戳计算。
+func (d *dvr) write(pk dvrPacket) {
+	d.wmu.Lock()
+	defer d.wmu.Unlock()
+	d.mu.RLock()
+	closed := d.closed
+	var seg *dvrSegment
+	if n := len(d.segments); n > 0 {
+		seg = d.segments[n-1]
+	}
+	d.mu.RUnlock()
+	if closed {
+		return
+	}
+
+	// 时间戳回退时(如新的发布者)不计入时长
+	p := pk.packet
+	if seg != nil && d.started && p.Timestamp > d.last {
+		seg.duration += time.Duration(p.Timestamp-d.last) * time.Millisecond
+	}
+	d.last, d.started = p.Timestamp, true
+	if pk.seekable && (seg == nil || seg.duration >= d.opt.Segment) {
+		next, err := d.newSegment(pk)
+		if err != nil {
+			Log("time shift: %v", err)
+			return
+		}
+		d.mu.Lock()
+		if d.closed {
+			d.mu.Unlock()
+			next.remove()
+			return
+		}
+		if seg != nil {
+			seg.next = next
+		}
+		d.segments = append(d.segments, next)
+		d.mu.Unlock()
+		seg = next
+	}
+	if seg == nil {
+		// 还没有可定位的数据包
+		return
+	}
+
+	e := dvrEntry{sequence: pk.sequence, generation: pk.generation, timestamp: p.Timestamp, seekable: pk.seekable}
+	var err error
+	if seg.w == nil {
+		e.packet = p
+	} else {
+		e.offset = seg.w.Offset()
+		err = seg.w.WriteTag(p.Type, p.Timestamp, p.Payload)
+	}
+
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	if err != nil {
+		// 写入失败后不再记录，已有的分段仍可读取
+		Log("time shift: %v", err)
+		d.closed = true
+		d.mu.Unlock()
+		return
+	}
+	seg.entries = append(seg.entries, e)
+	removed := d.trim()
+	d.mu.Unlock()
+	for _, seg := range removed {
+		seg.remove()
+	}
+}
+
+func (d *dvr) newSegment(pk dvrPacket) (*dvrSegment, error) {
+	seg := &dvrSegment{configs: pk.configs, generation: pk.generation}
+	if d.opt.Dir == "" {
+		return seg, nil
+	}
+	f, err := os.CreateTemp(d.opt.Dir, "timeshift-*.flv")
+	if err != nil {
+		return nil, err
+	}
+	seg.f = f
+	seg.w = flv.NewTagWriter(f)
+	if err = seg.w.WriteFlvHeader(pk.hasAudio, pk.hasVideo); err != nil {
+		seg.remove()
+		return nil, err
+	}
+	return seg, nil
+}
+
+// 去掉整段超出窗口的分段，调用者持有mu，返回的分段在释放mu后删除文件
+func (d *dvr) trim() []*dvrSegment {
+	var window time.Duration
+	for _, seg := range d.segments[1:] {
+		window += seg.duration
+	}
+	n := 0
+	for n+1 < len(d.segments) && window >= d.opt.Window {
+		d.segments[n].removed = true
+		window -= d.segments[n+1].duration
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+	removed := append([]*dvrSegment(nil), d.segments[:n]...)
+	d.segments = append(d.segments[:0], d.segments[n:]...)
+	return removed
+}
+
+// close 删除所有分段
+func (d *dvr) close() {
+	d.mu.Lock()
+	segments := d.segments
+	for _, seg := range segments {
+		seg.removed = true
+	}
+	d.segments = nil
+	d.closed = true
+	d.mu.Unlock()
+	for _, seg := range segments {
+		seg.remove()
+	}
+}
+
+// oldest 返回窗口中最早的时间戳
+func (d *dvr) oldest() (uint32, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, seg := range d.segments {
+		if len(seg.entries) > 0 {
+			return seg.entries[0].timestamp, true
+		}
+	}
+	return 0, false
+}
+
+// seek 定位到ms之前最近的可定位数据包，ms早于窗口时定位到窗口的开始
+func (d *dvr) seek(ms uint32) (c *dvrCursor, e dvrEntry, ok bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, seg := range d.segments {
+		if len(seg.entries) == 0 || (ok && seg.entries[0].timestamp > ms) {
+			break
+		}
+		for n, se := range seg.entries {
+			if ok && se.timestamp > ms {
+				break
+			}
+			if se.seekable {
+				c, e, ok = &dvrCursor{d: d, seg: seg, index: n}, se, true
+			}
+		}
+	}
+	return
+}
+
+// 订阅者在时移窗口中的读取位置
+type dvrCursor struct {
+	d     *dvr
+	seg   *dvrSegment
+	index int
+}
+
+// next 返回下一个数据包，读到窗口末尾时返回nil，
+// 分段已被删除时返回errOverwritten。
+func (c *dvrCursor) next() (*slot, error) {
+	c.d.mu.RLock()
+	for c.index >= len(c.seg.entries) && c.seg.next != nil && !c.seg.removed {
+		c.seg, c.index = c.seg.next, 0
+	}
+	seg, removed := c.seg, c.seg.removed
+	var e dvrEntry
+	ok := c.index < len(seg.entries)
+	if ok {
+		e = seg.entries[c.index]
+	}
+	c.d.mu.RUnlock()
+
+	if removed {
+		return nil, errOverwritten
+	}
+	if !ok {
+		return nil, nil
+	}
+	p := e.packet
+	if p == nil {
+		var err error
+		if p, err = seg.load(e); err != nil {
+			c.d.mu.RLock()
+			removed = seg.removed
+			c.d.mu.RUnlock()
+			if removed {
+				// 读取时分段被删除
+				return nil, errOverwritten
+			}
+			return nil, err
+		}
+	}
+	c.index++
+	return &slot{sequence: e.sequence, generation: e.generation, packet: p}, nil
+}
@@ -0,0 +1,87 @@
+package rtmp
+
+import (
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTimeShift(t *testing.T) {
+	for _, dir := range []string{"", t.TempDir()} {
+		s := NewStream(50)
+		if err := s.SetTimeShift(TimeShift{Window: time.Hour, Segment: time.Nanosecond, Dir: dir}); err != nil {
+			t.Fatal(err)
+		}
+		writeConfig(s)
+		for i := 0; i < 200; i++ {
+			s.Write(testPacket(i))
+		}
+		if oldest, latest := s.TimeRange(); oldest != 0 || latest != 199 {
+			t.Fatalf("time range %d-%d, expect 0-199", oldest, latest)
+		}
+
+		it := s.Iterator()
+		if ts, err := it.Seek(30); err != nil || ts != 25 {
+			t.Fatalf("seek: %d %v", ts, err)
+		}
+		for n := 0; n < 3; n++ {
+			if p, _ := it.Next(); !p.IsConfig && !p.IsMeta() {
+				t.Fatal("expect config frames first")
+			}
+		}
+		// 从时移窗口读到缓存，中间没有丢帧
+		for i := 25; i < 200; i++ {
+			p, err := it.Next()
+			if err != nil || p.Timestamp != uint32(i) {
+				t.Fatalf("dir %q: read %d: %v %v", dir, i, p, err)
+			}
+		}
+		it.Release()
+		s.SetTimeShift(TimeShift{})
+	}
+}
+
+func TestTimeShiftWindow(t *testing.T) {
+	dir := t.TempDir()
+	s := NewStream(50)
+	s.SetTimeShift(TimeShift{Window: time.Nanosecond, Segment: time.Nanosecond, Dir: dir})
+	writeConfig(s)
+	for i := 0; i < 200; i++ {
+		s.Write(testPacket(i))
+	}
+	// 只保留最后一个分段
+	if oldest, _ := s.TimeRange(); oldest != 175 {
+		t.Fatalf("oldest %d, expect 175", oldest)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Fatalf("%d segment files, expect 1", len(files))
+	}
+
+	r := Request{Form: url.Values{"t": {"-0.05"}}, Start: -2}
+	if ms, ok := r.PlayStart(s); !ok || ms != 175 {
+		t.Fatalf("play start %d %v, expect 175", ms, ok)
+	}
+	s.SetTimeShift(TimeShift{})
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Fatalf("%d segment files left", len(files))
+	}
+}
+
+func TestTimeShiftClose(t *testing.T) {
+	dir := t.TempDir()
+	s := NewStream(50)
+	s.SetTimeShift(TimeShift{Window: 50 * time.Millisecond, Segment: time.Nanosecond, Dir: dir})
+	writeConfig(s)
+	for i := 0; i < 200; i++ {
+		s.Write(testPacket(i))
+	}
+	// 按时间戳保留至少50ms
+	if oldest, _ := s.TimeRange(); oldest != 125 {
+		t.Fatalf("oldest %d, expect 125", oldest)
+	}
+	s.Close("shutdown")
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Fatalf("%d segment files left after close", len(files))
+	}
+}
@@ -0,0 +1,90 @@
+package amf0
+
+import (
+	"reflect"
+	"strconv"
+
+	"github.com/chenyj/rtmp/encoding/amf3"
+)
+
+// AMF3Value 编码为AMF3切换标记(0x11)和之后的AMF3值
+type AMF3Value struct {
+	Value any
+}
+
+var amf3ValueType = reflect.TypeOf(AMF3Value{})
+
+func (e *encodeState) amf3(v AMF3Value) {
+	bs, err := amf3.Encode(v.Value)
+	if err != nil {
+		e.err = err
+		return
+	}
+	e.buf.WriteByte(AMF_AMF3)
+	e.buf.Write(bs)
+}
+
+// 切换标记之后是一个AMF3值，使用新的引用表
+func (d *decodeState) amf3() any {
+	start := d.pos()
+	v, rest, n, err := amf3.DecodeReader(d.data[d.offset:], d.r)
+	if err != nil {
+		d.err = &SyntaxError{start + int64(n), err}
+		return nil
+	}
+	d.data, d.offset, d.base = rest, 0, start+int64(n)
+	return fromAMF3(v)
+}
+
+// 将AMF3的值转换为AMF0解码的类型，整数转换为float64，对象转换为Amfkv或TypedObject，
+// 使Decoder和Unmarshal可以同样处理
+func fromAMF3(v any) any {
+	return amf3Converter{}.value(v)
+}
+
+// AMF3的对象可以引用自身，按原对象的map记录已转换的Amfkv，
+// 转换前先记录，转换后保持相同的引用关系。数组在元素解码后才加入引用表，不会引用自身
+type amf3Converter map[uintptr]Amfkv
+
+func (c amf3Converter) value(v any) any {
+	switch v := v.(type) {
+	case int32:
+		return float64(v)
+	case []any:
+		arr := make(Amfarr, len(v))
+		for i, item := range v {
+			arr[i] = c.value(item)
+		}
+		return arr
+	case map[string]any:
+		return c.kv(v)
+	case amf3.TypedObject:
+		return TypedObject{v.Class, c.kv(v.Members)}
+	case amf3.XMLDocument:
+		return XMLDocument(v)
+	case amf3.Array:
+		// 关联部分和密集部分合并为ECMA array，密集部分的键为"0".."n-1"
+		kv := make(Amfkv, len(v.Dense))
+		if v.Assoc != nil {
+			kv = c.kv(v.Assoc)
+		}
+		for i, item := range v.Dense {
+			kv[strconv.Itoa(i)] = c.value(item)
+		}
+		return kv
+	}
+	return v
+}
+
+func (c amf3Converter) kv(m map[string]any) Amfkv {
+	p := reflect.ValueOf(m).Pointer()
+	if kv, ok := c[p]; ok {
+		return kv
+	}
+	kv := make(Amfkv, len(m))
+	c[p] = kv
+	for k, item := range m {
+		kv[k] = c.value(item)
+	}
+	return kv
+}
@@ -3,9 +3,9 @@ package amf0
 import (
 	"encoding/binary"
 	"fmt"
+	"io"
 	"math"
 	"reflect"
-	"strconv"
 	"strings"
 )
 
@@ -71,8 +71,7 @@ func (a Amfarr) Get(i int) any {
 }
 
 func (a Amfarr) GetString(i int) (v string, ok bool) {
-	v, ok = a[i].(string)
-	return
+	return asString(a[i])
 }
 
 func (a Amfarr) GetUint8(i int) (v uint8, ok bool) {
@@ -174,8 +173,7 @@ func (a Amfarr) GetBool(i int) (v bool, ok bool) {
 }
 
 func (a Amfarr) GetKV(i int) (v Amfkv, ok bool) {
-	v, ok = a[i].(Amfkv)
-	return
+	return asKV(a[i])
 }
 
 func (a Amfarr) GetArr(i int) (v Amfarr, ok bool) {
@@ -279,7 +277,7 @@ func (a Amfkv) GetBool(key string) (v bool, ok bool) {
 func (a Amfkv) GetString(key string) (v string, ok bool) {
 	var r any
 	if r, ok = a[key]; ok {
-		v, ok = r.(string)
+		v, ok = asString(r)
 	}
 	return
 }
@@ -383,7 +381,7 @@ func (a Amfkv) GetFloat64(key string) (v float64, ok bool) {
 func (a Amfkv) GetKV(key string) (v Amfkv, ok bool) {
 	var r any
 	if r, ok = a[key]; ok {
-		v, ok = r.(Amfkv)
+		v, ok = asKV(r)
 	}
 	return
 }
@@ -400,6 +398,9 @@ func (a *Amfarr) deValue(i int, v reflect.Value) error {
 	if i >= len(*a) {
 		return nil
 	}
+	if ok, err := unmarshaler(a.Get(i), v); ok {
+		return err
+	}
 	switch v.Kind() {
 	case reflect.Bool:
 		if b, ok := a.GetBool(i); ok {
@@ -456,6 +457,9 @@ func (a *Amfarr) deValue(i int, v reflect.Value) error {
 			return &UnmarshalTypeError{i, v.Type()}
 		}
 	case reflect.Struct:
+		if special, err := setSpecial(a.Get(i), v); special {
+			return err
+		}
 		if kv, ok := a.GetKV(i); ok {
 			if err := kv.deStruct(v); err != nil {
 				return err
@@ -464,7 +468,14 @@ func (a *Amfarr) deValue(i int, v reflect.Value) error {
 			return &UnmarshalTypeError{i, v.Type()}
 		}
 	case reflect.Pointer:
-		fmt.Println("pointer")
+		if a.Get(i) == nil {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return a.deValue(i, v.Elem())
 	case reflect.Interface:
 		v.Set(reflect.ValueOf(a.Get(i)))
 	}
@@ -507,51 +518,53 @@ func (a *Amfarr) deStruct(v reflect.Value) (err error) {
 	if v.Kind() != reflect.Struct {
 		return &InvalidUnmarshalError{v.Type()}
 	}
-	sf := reflect.VisibleFields(v.Type())
-	for _, f := range sf {
-		var id int
-		tag := f.Tag.Get("amf")
-		if tag == "-" {
+	for _, f := range cachedFields(v.Type()) {
+		if f.pos >= len(*a) {
 			continue
 		}
-		if tag != "" {
-			if n, e := strconv.Atoi(tag); e == nil {
-				id = n
-			} else {
-				id = f.Index[0]
+		fv, ok := fieldByIndex(v, f.index, true)
+		if !ok {
+			continue
+		}
+		if f.asString {
+			if s, ok := a.GetString(f.pos); ok {
+				if _, err = parseString(s, fv); err == nil {
+					continue
+				}
 			}
-
-		} else {
-			id = f.Index[0]
 		}
-		if id >= len(*a) {
-			continue
+		if err = a.deValue(f.pos, fv); !ignorable(err) {
+			return err
 		}
-		a.deValue(id, v.FieldByIndex(f.Index))
 	}
-	return
+	return nil
 }
 
 func (a *Amfkv) deStruct(v reflect.Value) (err error) {
 	if v.Kind() != reflect.Struct {
 		return &InvalidUnmarshalError{v.Type()}
 	}
-	sf := reflect.VisibleFields(v.Type())
-	for _, f := range sf {
-		key := f.Tag.Get("amf")
-		if key == "-" {
+	for _, f := range cachedFields(v.Type()) {
+		key, exist := a.exist(f.name)
+		if !exist {
 			continue
 		}
-		if key != "" {
-			if _, ok := (*a)[key]; !ok {
-				continue
+		fv, ok := fieldByIndex(v, f.index, true)
+		if !ok {
+			continue
+		}
+		if f.asString {
+			if s, ok := a.GetString(key); ok {
+				if _, err = parseString(s, fv); err == nil {
+					continue
+				}
 			}
-		} else {
-			key = f.Name
 		}
-		a.deValue(key, v.FieldByIndex(f.Index))
+		if err = a.deValue(key, fv); !ignorable(err) {
+			return err
+		}
 	}
-	return
+	return nil
 }
 
 func (a *Amfkv) deMap(v reflect.Value) (err error) {
@@ -572,6 +585,9 @@ func (a *Amfkv) deValue(key string, v reflect.Value) (err error) {
 	if key, exist = a.exist(key); !exist {
 		return
 	}
+	if ok, err := unmarshaler(a.Get(key), v); ok {
+		return err
+	}
 	switch v.Kind() {
 	case reflect.Bool:
 		if b, ok := a.GetBool(key); ok {
@@ -628,6 +644,9 @@ func (a *Amfkv) deValue(key string, v reflect.Value) (err error) {
 			return &InvalidUnmarshalError{v.Type()}
 		}
 	case reflect.Struct:
+		if special, err := setSpecial(a.Get(key), v); special {
+			return err
+		}
 		if kv, ok := a.GetKV(key); ok {
 			if err := kv.deStruct(v); err != nil {
 				return err
@@ -636,7 +655,14 @@ func (a *Amfkv) deValue(key string, v reflect.Value) (err error) {
 			return &InvalidUnmarshalError{v.Type()}
 		}
 	case reflect.Pointer:
-		fmt.Println("pointer")
+		if a.Get(key) == nil {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return a.deValue(key, v.Elem())
 	case reflect.Interface:
 		v.Set(reflect.ValueOf(a.Get(key)))
 	}
@@ -671,6 +697,10 @@ type decodeState struct {
 	data    []byte
 	order   binary.ByteOrder
 	err     error
+	refs    []any // 可以被引用的对象
+	ordered bool  // object和ECMA数组解码为*Object
+	r       io.Reader
+	base    int64 // data[0]相对于输入开始的偏移
 }
 
 func (d *decodeState) init(data []byte) *decodeState {
@@ -701,6 +731,9 @@ func (d *decodeState) unmarshal(v ...any) error {
 		if v[0] == nil {
 			return nil
 		}
+		if u, ok := v[0].(Unmarshaler); ok {
+			return u.UnmarshalAMF(d.data)
+		}
 		rv := reflect.ValueOf(v[0])
 		if rv.Kind() != reflect.Pointer || rv.IsNil() {
 			return &InvalidUnmarshalError{rv.Type()}
@@ -735,7 +768,7 @@ func (d *decodeState) unmarshal(v ...any) error {
 
 func (d *decodeState) decode() (Amfarr, error) {
 	var ar Amfarr
-	for d.offset < len(d.data) {
+	for d.more() {
 		v := d.value()
 		if d.err != nil {
 			return nil, d.err
@@ -745,8 +778,90 @@ func (d *decodeState) decode() (Amfarr, error) {
 	return ar, d.err
 }
 
+// 是否还有数据，读取出错时也返回false
+func (d *decodeState) more() bool {
+	if d.err != nil {
+		return false
+	}
+	if d.offset < len(d.data) {
+		return true
+	}
+	if d.r == nil {
+		return false
+	}
+	d.fill(1)
+	if d.err == io.ErrUnexpectedEOF {
+		// 在两个值之间结束
+		d.err = nil
+		d.r = nil
+	}
+	return d.offset < len(d.data)
+}
+
+// 检查是否还有n字节，数据流中不足时继续读取
+func (d *decodeState) need(n int) bool {
+	if d.err != nil {
+		return false
+	}
+	if n <= len(d.data)-d.offset {
+		return true
+	}
+	if d.r != nil {
+		d.fill(n)
+		if d.err == nil {
+			return true
+		}
+	} else {
+		d.err = io.ErrUnexpectedEOF
+	}
+	d.err = &SyntaxError{d.pos(), d.err}
+	return false
+}
+
+// 从数据流中读取，直到至少有n字节未处理的数据。已处理的数据被丢弃
+func (d *decodeState) fill(n int) {
+	if d.offset > 0 {
+		d.base += int64(d.offset)
+		d.data = d.data[:copy(d.data, d.data[d.offset:])]
+		d.offset = 0
+	}
+	for len(d.data) < n {
+		if len(d.data) == cap(d.data) {
+			// 按实际读取的数据增长，不按声明的长度预先分配
+			d.data = append(d.data, make([]byte, readSize)...)[:len(d.data)]
+		}
+		m, err := d.r.Read(d.data[len(d.data):cap(d.data)])
+		d.data = d.data[:len(d.data)+m]
+		if err != nil {
+			if len(d.data) >= n {
+				return
+			}
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			d.err = err
+			return
+		}
+	}
+}
+
+// 当前位置相对于输入开始的偏移
+func (d *decodeState) pos() int64 {
+	return d.base + int64(d.offset)
+}
+
+// 读取n字节，返回的切片在下次读取前有效
+func (d *decodeState) bytes(n int) []byte {
+	if !d.need(n) {
+		return nil
+	}
+	start := d.offset
+	d.offset += n
+	return d.data[start:d.offset]
+}
+
 func (d *decodeState) scanAmfType() {
-	if d.err != nil || d.offset >= len(d.data) {
+	if !d.need(1) {
 		return
 	}
 	d.amftype = uint8(d.data[d.offset])
@@ -755,14 +870,15 @@ func (d *decodeState) scanAmfType() {
 
 func (d *decodeState) value() any {
 	d.scanAmfType()
+	if d.err != nil {
+		return nil
+	}
 	switch d.amftype {
 	default:
-		d.err = fmt.Errorf("Unsupport amf type: %d", d.amftype)
+		d.err = &SyntaxError{d.pos() - 1, fmt.Errorf("unsupported amf type: %d", d.amftype)}
 		return nil
-	case AMF_UNDEFINED, AMF_REFERENCE, AMF_DATE,
-		AMF_UNSUPPORTED, AMF_XML_DOCUMENT, AMF_TYPE_OBJECT,
-		AMF_AMF3, AMF_MOVIECLIP, AMF_RECORDSET:
-		d.err = fmt.Errorf("Unimplate amf type: %d", d.amftype)
+	case AMF_UNSUPPORTED, AMF_MOVIECLIP, AMF_RECORDSET:
+		d.err = &SyntaxError{d.pos() - 1, fmt.Errorf("unimplemented amf type: %d", d.amftype)}
 		return nil
 	case AMF_NUMBER:
 		return d.number()
@@ -771,8 +887,14 @@ func (d *decodeState) value() any {
 	case AMF_STRING:
 		return d.str()
 	case AMF_OBJECT:
+		if d.ordered {
+			return d.orderedObject(false)
+		}
 		return d.object()
 	case AMF_ECMA_ARRAY:
+		if d.ordered {
+			return d.orderedObject(true)
+		}
 		return d.ecmarr()
 	case AMF_STRICT_ARRAY:
 		return d.arr()
@@ -780,91 +902,106 @@ func (d *decodeState) value() any {
 		return d.longstr()
 	case AMF_NULL:
 		return nil
+	case AMF_UNDEFINED:
+		return Undefined{}
+	case AMF_REFERENCE:
+		return d.reference()
+	case AMF_DATE:
+		return d.date()
+	case AMF_XML_DOCUMENT:
+		return d.xml()
+	case AMF_TYPE_OBJECT:
+		return d.typedObject()
+	case AMF_AMF3:
+		return d.amf3()
 	}
 }
 
 func (d *decodeState) number() float64 {
-	start := d.offset
-	d.offset += 8
-	bs := d.order.Uint64(d.data[start:d.offset])
-	return math.Float64frombits(bs)
+	if bs := d.bytes(8); bs != nil {
+		return math.Float64frombits(d.order.Uint64(bs))
+	}
+	return 0
 }
 
 func (d *decodeState) tf() bool {
-	start := d.offset
-	d.offset += 1
-	return d.data[start] != 0
+	if bs := d.bytes(1); bs != nil {
+		return bs[0] != 0
+	}
+	return false
 }
 
 func (d *decodeState) str() string {
-	start := d.offset
-	d.offset += 2
-	size := d.order.Uint16(d.data[start:d.offset])
-	start = d.offset
-	d.offset += int(size)
-	return string(d.data[start:d.offset])
+	return string(d.bytes(int(d.u16())))
 }
 
 func (d *decodeState) longstr() string {
-	start := d.offset
-	d.offset += 4
-	size := d.order.Uint32(d.data[start:d.offset])
-	start = d.offset
-	d.offset += int(size)
-	return string(d.data[start:d.offset])
+	size := d.u32()
+	if uint64(size) > math.MaxInt32 {
+		d.err = &SyntaxError{d.pos() - 4, fmt.Errorf("long string len %d is too large", size)}
+		return ""
+	}
+	return string(d.bytes(int(size)))
 }
 
 func (d *decodeState) object() Amfkv {
 	m := make(map[string]any)
-	for d.u24() != AMF_OBJECT_END {
+	d.refs = append(d.refs, Amfkv(m))
+	d.members(m)
+	return Amfkv(m)
+}
+
+// 读取对象的键值对直到对象结束
+func (d *decodeState) members(m map[string]any) {
+	for d.err == nil && d.u24() != AMF_OBJECT_END {
 		d.unread(3)
 		key := d.str()
 		val := d.value()
 		m[key] = val
 	}
-	return Amfkv(m)
 }
 
+// ECMA数组的数量只是提示，有的编码器写入0，读取到对象结束为止
 func (d *decodeState) ecmarr() Amfkv {
-	size := d.u32()
-	m := make(map[string]any, size)
-	for i := uint32(0); i < size; i++ {
-		key := d.str()
-		val := d.value()
-		m[key] = val
-	}
-	if d.u24() != AMF_OBJECT_END {
-		d.err = fmt.Errorf("Expect AMF Object End(00 00 09), but(%x)", d.data[d.offset-3:d.offset])
-		return nil
-	}
+	d.u32()
+	m := make(map[string]any)
+	d.refs = append(d.refs, Amfkv(m))
+	d.members(m)
 	return Amfkv(m)
 }
 
 func (d *decodeState) arr() Amfarr {
 	size := d.u32()
-	arr := make([]any, size)
-	for i := uint32(0); i < size; i++ {
-		arr[i] = d.value()
+	// 每个值至少1字节，声明的长度只用于限制预先分配的大小
+	arr := make(Amfarr, 0, min(int(size), 1024))
+	ref := len(d.refs)
+	d.refs = append(d.refs, arr)
+	for i := uint32(0); i < size && d.err == nil; i++ {
+		arr = append(arr, d.value())
 	}
-	return Amfarr(arr)
+	d.refs[ref] = arr
+	return arr
 }
 
 func (d *decodeState) u16() uint16 {
-	start := d.offset
-	d.offset += 2
-	return d.order.Uint16(d.data[start:d.offset])
+	if bs := d.bytes(2); bs != nil {
+		return d.order.Uint16(bs)
+	}
+	return 0
 }
 
 func (d *decodeState) u24() uint32 {
-	start := d.offset
-	d.offset += 3
-	return uint32(d.data[start]) | uint32(d.data[start+1]) | uint32(d.data[start+2])
+	if bs := d.bytes(3); bs != nil {
+		return uint32(bs[0])<<16 | uint32(bs[1])<<8 | uint32(bs[2])
+	}
+	return 0
 }
 
 func (d *decodeState) u32() uint32 {
-	start := d.offset
-	d.offset += 4
-	return d.order.Uint32(d.data[start:d.offset])
+	if bs := d.bytes(4); bs != nil {
+		return d.order.Uint32(bs)
+	}
+	return 0
 }
 
 func (d *decodeState) unread(n int) {
@@ -874,16 +1011,6 @@ func (d *decodeState) unread(n int) {
 	}
 }
 
-// 检查数据完整性
-func (d *decodeState) checkIntegrity(n int) {
-	if d.err != nil {
-		return
-	}
-	if d.offset+n >= len(d.data) {
-		d.err = fmt.Errorf("")
-	}
-}
-
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -1,8 +1,15 @@
 package amf0
 
 import (
+	"bytes"
+	"errors"
+	"io"
 	"reflect"
 	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/chenyj/rtmp/encoding/amf3"
 )
 
 var (
@@ -168,3 +175,218 @@ func TestUnmarshalMap(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestDecodeAMF3Switch(t *testing.T) {
+	type Conn struct {
+		App            string `amf:"app"`
+		ObjectEncoding int    `amf:"objectEncoding"`
+	}
+	bs, err := Encode("connect", 1, AMF3Value{map[string]any{"app": "live", "objectEncoding": 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDecoder(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var name string
+	var tid uint32
+	var c Conn
+	if err = d.Decode(&name, &tid, &c); err != nil {
+		t.Fatal(err)
+	}
+	if name != "connect" || tid != 1 || c != (Conn{"live", 3}) {
+		t.Fatalf("%s %d %+v", name, tid, c)
+	}
+}
+
+// AMF3对象引用自身时转换后的Amfkv也引用自身
+func TestDecodeAMF3Cycle(t *testing.T) {
+	bs := []byte{0x02, 0x00, 0x04, 't', 'e', 's', 't', 0x00, 0x3F, 0xF0, 0, 0, 0, 0, 0, 0,
+		0x11, 0x0A, 0x0B, 0x01, 0x03, 'a', 0x0A, 0x00, 0x01}
+	ar, err := Decode(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv, ok := ar.GetKV(2)
+	if !ok {
+		t.Fatalf("%T", ar.Get(2))
+	}
+	a, ok := kv.GetKV("a")
+	if !ok || reflect.ValueOf(a).Pointer() != reflect.ValueOf(kv).Pointer() {
+		t.Fatal("expect self reference")
+	}
+	if _, err = NewDecoder(bs); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 同时有关联部分和密集部分的AMF3数组转换为包含所有元素的ECMA array
+func TestDecodeAMF3MixedArray(t *testing.T) {
+	bs, err := Encode(AMF3Value{amf3.Array{Assoc: map[string]any{"name": "a"}, Dense: []any{1, "b"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ar, err := Decode(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Amfkv{"name": "a", "0": 1.0, "1": "b"}
+	if kv, _ := ar.GetKV(0); !reflect.DeepEqual(kv, want) {
+		t.Fatalf("%v, expect %v", ar.Get(0), want)
+	}
+}
+
+func TestDecodeExtendedTypes(t *testing.T) {
+	date := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	shared := map[string]any{"a": 1.0}
+	bs, err := Encode(Undefined{}, date, XMLDocument("<a/>"),
+		TypedObject{"Foo", Amfkv{"name": "foo"}}, []any{shared, shared})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ar, err := Decode(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar[0] != (Undefined{}) || ar[1] != date || ar[2] != XMLDocument("<a/>") {
+		t.Fatalf("%#v", ar)
+	}
+	if o, ok := ar.GetTypedObject(3); !ok || o.Class != "Foo" || o.Members["name"] != "foo" {
+		t.Fatalf("typed object: %#v", ar[3])
+	}
+	// 默认重复的map完整写入，开启引用后第二个map编码为引用
+	arr, _ := ar.GetArr(4)
+	if bytes.Contains(bs, []byte{AMF_REFERENCE}) || !reflect.DeepEqual(arr[0], arr[1]) {
+		t.Fatalf("expect no reference: %x", bs)
+	}
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetReferences(true)
+	if err = enc.Encode(TypedObject{"Foo", Amfkv{}}, []any{shared, shared}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(buf.Bytes(), []byte{AMF_REFERENCE, 0, 2}) {
+		t.Fatalf("expect reference: %x", buf.Bytes())
+	}
+	if ar, err = Decode(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if arr, _ = ar.GetArr(1); !reflect.DeepEqual(arr[0], arr[1]) {
+		t.Fatalf("reference: %#v", arr)
+	}
+	shared["self"] = shared
+	if _, err = Encode(shared); err == nil {
+		t.Fatal("expect cyclic reference error")
+	}
+
+	// onMetaData中的creationdate
+	type Meta struct {
+		CreationDate time.Time `amf:"creationdate"`
+		Class        TypedObject
+	}
+	meta := map[string]any{"creationdate": date, "class": TypedObject{"Foo", Amfkv{}}}
+	if bs, err = Encode(meta); err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDecoder(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m Meta
+	if err = d.Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	if !m.CreationDate.Equal(date) || m.Class.Class != "Foo" {
+		t.Fatalf("%+v", m)
+	}
+}
+
+func TestDecodeOrdered(t *testing.T) {
+	// ECMA数组的数量为0，和实际的属性数不同
+	meta := &Object{ECMA: true, Props: []Property{
+		{"width", 1280.0}, {"height", 720.0}, {"encoder", "obs"},
+		{"keyframes", &Object{Props: []Property{{"times", Amfarr{0.0}}, {"filepositions", Amfarr{13.0}}}}},
+	}}
+	bs, err := Encode("onMetaData", meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ar, err := DecodeOrdered(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ar[1], meta) {
+		t.Fatalf("%#v, expect %#v", ar[1], meta)
+	}
+	out, err := Encode(ar...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, bs) {
+		t.Fatalf("re-encode %x, expect %x", out, bs)
+	}
+
+	// 普通的解码读取到对象结束，忽略数量
+	if ar, err = Decode(bs); err != nil {
+		t.Fatal(err)
+	}
+	kv, _ := ar.GetKV(1)
+	if w, _ := kv.GetFloat64("width"); w != 1280 || len(kv) != 4 {
+		t.Fatalf("%#v", kv)
+	}
+}
+
+func TestStream(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	big := make([]byte, 3*readSize)
+	for i := range big {
+		big[i] = 'a'
+	}
+	meta := &Object{Props: []Property{{"width", 1280.0}, {"encoder", string(big)}}}
+	in := []any{"onMetaData", meta, AMF3Value{"amf3"}}
+	if err := enc.Encode(in[:2]...); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Encode(in[2]); err != nil {
+		t.Fatal(err)
+	}
+	bs, _ := Encode(in...)
+	if !bytes.Equal(buf.Bytes(), bs) {
+		t.Fatal("stream encode differs from Encode")
+	}
+
+	// 每次只读一个字节
+	d := NewStreamDecoder(iotest.OneByteReader(bytes.NewReader(bs)))
+	var name string
+	if err := d.Decode(&name); err != nil || name != "onMetaData" {
+		t.Fatalf("%q %v", name, err)
+	}
+	v, err := d.Next()
+	if err != nil || !reflect.DeepEqual(v, meta.KV()) {
+		t.Fatalf("%v %v", v, err)
+	}
+	if v, err = d.Next(); err != nil || v != "amf3" {
+		t.Fatalf("%v %v", v, err)
+	}
+	if _, err = d.Next(); err != io.EOF {
+		t.Fatalf("expect EOF, got %v", err)
+	}
+	if d.Offset() != int64(len(bs)) {
+		t.Fatalf("offset %d, expect %d", d.Offset(), len(bs))
+	}
+
+	// 截断的数据返回出错的位置
+	d = NewStreamDecoder(bytes.NewReader(bs[:20]))
+	d.Next()
+	_, err = d.Next()
+	var se *SyntaxError
+	if !errors.As(err, &se) || se.Offset != 16 || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("%v", err)
+	}
+	bs[13] = 0x55
+	if _, err = Decode(bs); !errors.As(err, &se) || se.Offset != 13 {
+		t.Fatalf("%v", err)
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"math"
 	"reflect"
 	"sync"
+	"time"
 )
 
 var epool = sync.Pool{New: func() any { return new(encodeState).init() }}
@@ -42,17 +44,26 @@ type encodeState struct {
 	order binary.ByteOrder
 	cache []byte
 	err   error
+	refs  map[uintptr]int // 已写入的map和结构体指针的引用索引，不写入引用时是正在写入的对象
+	nref  int             // 已写入的可引用对象数
+	w     io.Writer       // 不为nil时缓冲超过readSize就写入w
+	ref   bool            // 重复的对象写入引用
 }
 
 func (e *encodeState) init() *encodeState {
 	e.order = binary.BigEndian
 	e.cache = make([]byte, 0, 8)
+	e.refs = make(map[uintptr]int)
 	return e
 }
 
 func (e *encodeState) reset() *encodeState {
 	e.err = nil
 	e.cache = e.cache[:0]
+	e.nref = 0
+	for k := range e.refs {
+		delete(e.refs, k)
+	}
 	e.buf.Reset()
 	return e
 }
@@ -70,6 +81,10 @@ func (e *encodeState) encode(v ...any) []byte {
 }
 
 func (e *encodeState) envalue(v reflect.Value) {
+	e.flush(readSize)
+	if e.marshaler(v) {
+		return
+	}
 	switch v.Kind() {
 	default:
 		return
@@ -82,6 +97,10 @@ func (e *encodeState) envalue(v reflect.Value) {
 	case reflect.Float32, reflect.Float64:
 		e.number(v.Float())
 	case reflect.String:
+		if v.Type() == xmlDocumentType {
+			e.xml(XMLDocument(v.String()))
+			return
+		}
 		e.str(v.String())
 	case reflect.Array, reflect.Slice:
 		e.arr(v)
@@ -95,9 +114,27 @@ func (e *encodeState) envalue(v reflect.Value) {
 			e.err = fmt.Errorf("amf0 encode map error: can not handle key type: %s", kt)
 			return
 		}
+		if e.reference(v.Pointer()) {
+			return
+		}
 		e.object(v)
+		e.leave(v.Pointer())
 	case reflect.Struct:
-		e.enstruct(v)
+		switch v.Type() {
+		case amf3ValueType:
+			e.amf3(v.Interface().(AMF3Value))
+		case timeType:
+			e.date(v.Interface().(time.Time))
+		case typedObjectType:
+			e.typedObject(v.Interface().(TypedObject))
+		case objectType:
+			o := v.Interface().(Object)
+			e.orderedObject(&o)
+		case undefinedType:
+			e.buf.WriteByte(AMF_UNDEFINED)
+		default:
+			e.enstruct(v)
+		}
 	case reflect.Interface:
 		if v.IsNil() {
 			e.buf.WriteByte(AMF_NULL)
@@ -107,13 +144,22 @@ func (e *encodeState) envalue(v reflect.Value) {
 	case reflect.Pointer:
 		if v.IsNil() {
 			e.buf.WriteByte(AMF_NULL)
-		} else {
+			return
+		}
+		if !referable(v.Elem().Type()) {
 			e.envalue(v.Elem())
+			return
+		}
+		if e.reference(v.Pointer()) {
+			return
 		}
+		e.envalue(v.Elem())
+		e.leave(v.Pointer())
 	}
 }
 
 func (e *encodeState) value(v any) {
+	e.flush(readSize)
 	if v == nil {
 		e.buf.WriteByte(AMF_NULL)
 		return
@@ -148,9 +194,39 @@ func (e *encodeState) value(v any) {
 	case string:
 		e.str(v)
 	case map[string]any:
+		if v == nil {
+			e.kv(v)
+			return
+		}
+		ptr := reflect.ValueOf(v).Pointer()
+		if e.reference(ptr) {
+			return
+		}
 		e.kv(v)
+		e.leave(ptr)
 	case []any:
 		e.slice(v)
+	case AMF3Value:
+		e.amf3(v)
+	case time.Time:
+		e.date(v)
+	case XMLDocument:
+		e.xml(v)
+	case TypedObject:
+		e.typedObject(v)
+	case Object:
+		e.orderedObject(&v)
+	case *Object:
+		if v == nil {
+			e.buf.WriteByte(AMF_NULL)
+		} else if ptr := reflect.ValueOf(v).Pointer(); !e.reference(ptr) {
+			e.orderedObject(v)
+			e.leave(ptr)
+		}
+	case Undefined:
+		e.buf.WriteByte(AMF_UNDEFINED)
+	case Marshaler:
+		e.marshaler(reflect.ValueOf(v))
 	default:
 		rv := reflect.ValueOf(v)
 		e.envalue(rv)
@@ -197,6 +273,7 @@ func (e *encodeState) longstr(s string) {
 
 //TODO: handle nil value
 func (e *encodeState) kv(m map[string]any) {
+	e.nref++
 	e.buf.WriteByte(AMF_OBJECT)
 	for k, v := range m {
 		size := len(k)
@@ -213,6 +290,7 @@ func (e *encodeState) kv(m map[string]any) {
 
 //TODO: handle nil value
 func (e *encodeState) ecmarr(m map[string]any) {
+	e.nref++
 	e.buf.WriteByte(AMF_ECMA_ARRAY)
 	size := len(m)
 	if size > math.MaxUint32 {
@@ -234,6 +312,7 @@ func (e *encodeState) ecmarr(m map[string]any) {
 }
 
 func (e *encodeState) slice(v []any) {
+	e.nref++
 	e.buf.WriteByte(AMF_STRICT_ARRAY)
 	size := len(v)
 	if size > math.MaxUint32 {
@@ -251,6 +330,7 @@ func (e *encodeState) arr(v reflect.Value) {
 	if v.Kind() != reflect.Array && v.Kind() != reflect.Slice {
 		return
 	}
+	e.nref++
 	e.buf.WriteByte(AMF_STRICT_ARRAY)
 	size := v.Len()
 	if size > math.MaxUint32 {
@@ -268,6 +348,7 @@ func (e *encodeState) object(v reflect.Value) {
 	if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
 		return
 	}
+	e.nref++
 	e.buf.WriteByte(AMF_OBJECT)
 	it := v.MapRange()
 	for it.Next() {
@@ -300,24 +381,27 @@ func (e *encodeState) enstruct(v reflect.Value) {
 	if v.Kind() != reflect.Struct {
 		return
 	}
+	e.nref++
 	e.buf.WriteByte(AMF_OBJECT)
-	fields := reflect.VisibleFields(v.Type())
-	for _, f := range fields {
-		var key string
-		if key = f.Tag.Get("amf"); key == "" {
-			key = f.Name
-		}
-		if key == "-" {
+	for _, f := range cachedFields(v.Type()) {
+		fv, ok := fieldByIndex(v, f.index, false)
+		if !ok || f.omitEmpty && isEmptyValue(fv) {
 			continue
 		}
-		size := len(key)
+		size := len(f.name)
 		if size > math.MaxUint16 {
 			e.err = fmt.Errorf("amf0 encode object error: key len %d is longer than %d.", size, math.MaxUint16)
 			return
 		}
 		e.u16(uint16(size))
-		e.buf.WriteString(key)
-		e.envalue(v.FieldByIndex(f.Index))
+		e.buf.WriteString(f.name)
+		if f.asString {
+			if s, ok := formatString(fv); ok {
+				e.str(s)
+				continue
+			}
+		}
+		e.envalue(fv)
 	}
 	e.buf.Write(objEnd)
 }
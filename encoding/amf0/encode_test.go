@@ -1,6 +1,10 @@
 package amf0
 
-import "testing"
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
 
 func TestMarshalString(t *testing.T) {
 	v := "a"
@@ -79,3 +83,62 @@ func TestEncode(t *testing.T) {
 	}
 	t.Logf("encode: %x", bs)
 }
+
+// 编码为"x,y"字符串的坐标
+type point struct{ x, y int }
+
+func (p point) MarshalAMF() ([]byte, error) {
+	return Encode(fmt.Sprintf("%d,%d", p.x, p.y))
+}
+
+func (p *point) UnmarshalAMF(data []byte) error {
+	var s string
+	if err := Unmarshal(data, &s); err != nil {
+		return err
+	}
+	_, err := fmt.Sscanf(s, "%d,%d", &p.x, &p.y)
+	return err
+}
+
+func TestMarshalFieldPlan(t *testing.T) {
+	type Base struct {
+		Level string `amf:"level"`
+		Code  string `amf:"code"`
+	}
+	type Info struct {
+		Base
+		Code    string `amf:"code"` // 覆盖Base.Code
+		Desc    string `amf:"description,omitempty"`
+		Count   int    `amf:"count,string"`
+		Ignored int    `amf:"-"`
+		Pos     point  `amf:"pos"`
+		Origin  *point `amf:"origin,omitempty"`
+	}
+	in := Info{Base{"status", "base"}, "NetStream.Play.Start", "", 3, 1, point{1, 2}, nil}
+	bs, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ar, err := Decode(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Amfkv{"level": "status", "code": "NetStream.Play.Start", "count": "3", "pos": "1,2"}
+	if !reflect.DeepEqual(ar[0], want) {
+		t.Fatalf("%#v, expect %#v", ar[0], want)
+	}
+
+	d, err := NewDecoder(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out Info
+	if err = d.Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	// 被覆盖的字段和忽略的字段不编码
+	in.Base.Code, in.Ignored = "", 0
+	if !reflect.DeepEqual(out, in) {
+		t.Fatalf("%+v, expect %+v", out, in)
+	}
+}
@@ -0,0 +1,305 @@
+package amf0
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Marshaler 自定义编码的类型，MarshalAMF返回一个完整的AMF0值
+type Marshaler interface {
+	MarshalAMF() ([]byte, error)
+}
+
+// Unmarshaler 自定义解码的类型，data是一个完整的AMF0值
+type Unmarshaler interface {
+	UnmarshalAMF(data []byte) error
+}
+
+var (
+	marshalerType   = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+)
+
+// 结构体字段的编解码方式，由amf标签决定：
+//
+//	amf:"name"            键名
+//	amf:"-"               忽略字段
+//	amf:"-,"              键名为"-"
+//	amf:"name,omitempty"  零值时不编码
+//	amf:",string"         数字和布尔值编码为字符串
+//
+// 没有标签的匿名结构体字段，其字段展开到外层
+type fieldInfo struct {
+	name      string
+	index     []int
+	pos       int // 解码到AMF数组时的位置，键名为数字时使用该数字
+	omitEmpty bool
+	asString  bool
+	tagged    bool
+}
+
+var fieldCache sync.Map // reflect.Type -> []fieldInfo
+
+// 结构体的字段，按类型缓存
+func cachedFields(t reflect.Type) []fieldInfo {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.([]fieldInfo)
+	}
+	f, _ := fieldCache.LoadOrStore(t, typeFields(t))
+	return f.([]fieldInfo)
+}
+
+func parseTag(tag string) (name string, omitEmpty, asString bool) {
+	name, opts, _ := strings.Cut(tag, ",")
+	for opts != "" {
+		var opt string
+		opt, opts, _ = strings.Cut(opts, ",")
+		switch opt {
+		case "omitempty":
+			omitEmpty = true
+		case "string":
+			asString = true
+		}
+	}
+	return
+}
+
+// 和encoding/json相同，按深度广度优先展开匿名结构体，
+// 同名的字段取最浅的，深度相同时取有标签的，仍有多个时都忽略
+func typeFields(t reflect.Type) []fieldInfo {
+	type embed struct {
+		typ   reflect.Type
+		index []int
+	}
+	var fields []fieldInfo
+	depth := map[string]int{}
+	current := []embed{{typ: t}}
+	visited := map[reflect.Type]bool{}
+	for level := 0; len(current) > 0; level++ {
+		var next []embed
+		for _, em := range current {
+			if visited[em.typ] {
+				continue
+			}
+			visited[em.typ] = true
+			for i := 0; i < em.typ.NumField(); i++ {
+				sf := em.typ.Field(i)
+				tag := sf.Tag.Get("amf")
+				if tag == "-" {
+					continue
+				}
+				name, omitEmpty, asString := parseTag(tag)
+				index := make([]int, len(em.index)+1)
+				copy(index, em.index)
+				index[len(em.index)] = i
+
+				ft := sf.Type
+				if ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+				if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+					next = append(next, embed{ft, index})
+					continue
+				}
+				if !sf.IsExported() {
+					continue
+				}
+				tagged := name != ""
+				if !tagged {
+					name = sf.Name
+				}
+				if d, ok := depth[name]; ok && d < level {
+					continue
+				}
+				depth[name] = level
+				pos := index[0]
+				if n, err := strconv.Atoi(name); err == nil {
+					pos = n
+				}
+				fields = append(fields, fieldInfo{name, index, pos, omitEmpty, asString, tagged})
+			}
+		}
+		current = next
+	}
+
+	// 处理同名字段
+	sort.SliceStable(fields, func(i, j int) bool { return fields[i].name < fields[j].name })
+	out := fields[:0]
+	for i := 0; i < len(fields); {
+		j := i + 1
+		for j < len(fields) && fields[j].name == fields[i].name {
+			j++
+		}
+		if f, ok := dominantField(fields[i:j]); ok {
+			out = append(out, f)
+		}
+		i = j
+	}
+	// 按字段定义的顺序
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i].index, out[j].index
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+	return out
+}
+
+// fields同名且深度相同
+func dominantField(fields []fieldInfo) (fieldInfo, bool) {
+	if len(fields) == 1 {
+		return fields[0], true
+	}
+	var found []fieldInfo
+	for _, f := range fields {
+		if f.tagged {
+			found = append(found, f)
+		}
+	}
+	if len(found) == 1 {
+		return found[0], true
+	}
+	return fieldInfo{}, false
+}
+
+// 取字段的值，alloc为true时为nil的匿名结构体指针分配内存，否则返回false
+func fieldByIndex(v reflect.Value, index []int, alloc bool) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				if !alloc || !v.CanSet() {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Pointer:
+		return v.IsNil()
+	}
+	return false
+}
+
+// 数字和布尔值格式化为字符串，其他类型返回false
+func formatString(v reflect.Value) (string, bool) {
+	switch v.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10), true
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64), true
+	}
+	return "", false
+}
+
+// 将字符串解析到数字或布尔值，v是其他类型时返回false
+func parseString(s string, v reflect.Value) (bool, error) {
+	switch v.Kind() {
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err == nil {
+			v.SetBool(b)
+		}
+		return true, err
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err == nil {
+			v.SetInt(n)
+		}
+		return true, err
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err == nil {
+			v.SetUint(n)
+		}
+		return true, err
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err == nil {
+			v.SetFloat(f)
+		}
+		return true, err
+	}
+	return false, nil
+}
+
+// 调用Marshaler，v没有实现时返回false
+func (e *encodeState) marshaler(v reflect.Value) bool {
+	if !v.IsValid() || !v.CanInterface() {
+		return false
+	}
+	if v.Kind() != reflect.Pointer && v.CanAddr() && reflect.PtrTo(v.Type()).Implements(marshalerType) {
+		v = v.Addr()
+	}
+	if !v.Type().Implements(marshalerType) {
+		return false
+	}
+	if v.Kind() == reflect.Pointer && v.IsNil() {
+		e.buf.WriteByte(AMF_NULL)
+		return true
+	}
+	bs, err := v.Interface().(Marshaler).MarshalAMF()
+	if err != nil {
+		e.err = err
+		return true
+	}
+	e.buf.Write(bs)
+	return true
+}
+
+// 调用Unmarshaler，v没有实现时返回false。
+// 已解码的值重新编码后交给UnmarshalAMF
+func unmarshaler(r any, v reflect.Value) (bool, error) {
+	if v.Kind() == reflect.Pointer && v.Type().Implements(unmarshalerType) {
+		if r == nil {
+			return false, nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+	} else if v.CanAddr() && reflect.PtrTo(v.Type()).Implements(unmarshalerType) {
+		v = v.Addr()
+	} else {
+		return false, nil
+	}
+	bs, err := Encode(r)
+	if err != nil {
+		return true, err
+	}
+	return true, v.Interface().(Unmarshaler).UnmarshalAMF(bs)
+}
+
+// 类型不匹配的错误在解码结构体时忽略，Unmarshaler的错误需要返回
+func ignorable(err error) bool {
+	switch err.(type) {
+	case nil, *InvalidUnmarshalError, *UnmarshalTypeError:
+		return true
+	}
+	return false
+}
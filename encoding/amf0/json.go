@@ -0,0 +1,381 @@
+package amf0
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// AMF0值序列和JSON数组的转换，JSON中保留AMF0的类型：
+//
+//	number                   数字，NaN和无穷为{"$number":"NaN"}、{"$number":"-Infinity"}
+//	string、boolean、null     对应的JSON值
+//	undefined                {"$undefined":true}
+//	object                   JSON对象，保持属性的顺序
+//	ecma array               {"$ecma":{...}}，数量和属性数不同时加上"$count":n
+//	strict array             JSON数组
+//	date                     {"$date":"2006-01-02T15:04:05.000Z"}
+//	xml document             {"$xml":"..."}
+//	typed object             {"$typed":"class","$members":{...}}
+//
+// 第一个键以$开头的JSON对象表示上面的特殊类型，这样的object写为{"$object":{...}}。
+// 引用会被展开，循环引用返回错误
+const jsonDateLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// ToJSON 将AMF0数据转换为JSON数组
+func ToJSON(data []byte) ([]byte, error) {
+	ar, err := DecodeOrdered(data)
+	if err != nil {
+		return nil, err
+	}
+	j := jsonState{path: make(map[uintptr]bool)}
+	j.enc = json.NewEncoder(&j.buf)
+	j.enc.SetEscapeHTML(false)
+	if err = j.value(ar); err != nil {
+		return nil, err
+	}
+	return j.buf.Bytes(), nil
+}
+
+// EncodeJSON 将v编码为AMF0后转换为JSON数组
+func EncodeJSON(v ...any) ([]byte, error) {
+	data, err := Encode(v...)
+	if err != nil {
+		return nil, err
+	}
+	return ToJSON(data)
+}
+
+// FromJSON 将ToJSON格式的JSON数组转换为AMF0数据
+func FromJSON(js []byte) ([]byte, error) {
+	ar, err := DecodeJSON(js)
+	if err != nil {
+		return nil, err
+	}
+	return Encode(ar...)
+}
+
+// DecodeJSON 解析ToJSON格式的JSON数组，object和ECMA数组为*Object
+func DecodeJSON(js []byte) (Amfarr, error) {
+	p := jsonParser{json.NewDecoder(bytes.NewReader(js))}
+	p.dec.UseNumber()
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	ar, ok := v.(Amfarr)
+	if !ok {
+		return nil, fmt.Errorf("amf0 json error: expect array, got %T", v)
+	}
+	if _, err = p.dec.Token(); err == nil {
+		return nil, fmt.Errorf("amf0 json error: extra data after array")
+	}
+	return ar, nil
+}
+
+type jsonState struct {
+	buf  bytes.Buffer
+	enc  *json.Encoder
+	path map[uintptr]bool // 正在写入的对象，用于检查循环引用
+}
+
+// 写入字符串、数字等简单的值
+func (j *jsonState) simple(v any) error {
+	if err := j.enc.Encode(v); err != nil {
+		return err
+	}
+	j.buf.Truncate(j.buf.Len() - 1) // Encode添加的换行
+	return nil
+}
+
+func (j *jsonState) key(k string) {
+	j.simple(k)
+	j.buf.WriteByte(':')
+}
+
+func (j *jsonState) enter(v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Slice && rv.Len() == 0 {
+		return nil
+	}
+	p := rv.Pointer()
+	if j.path[p] {
+		return fmt.Errorf("amf0 json error: cyclic reference")
+	}
+	j.path[p] = true
+	return nil
+}
+
+func (j *jsonState) leave(v any) {
+	delete(j.path, reflect.ValueOf(v).Pointer())
+}
+
+func (j *jsonState) value(v any) error {
+	switch v := v.(type) {
+	default:
+		return fmt.Errorf("amf0 json error: unsupported type %T", v)
+	case nil, bool, string:
+		return j.simple(v)
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return j.special("$number", "NaN")
+		case math.IsInf(v, 1):
+			return j.special("$number", "Infinity")
+		case math.IsInf(v, -1):
+			return j.special("$number", "-Infinity")
+		}
+		return j.simple(v)
+	case Undefined:
+		return j.special("$undefined", true)
+	case XMLDocument:
+		return j.special("$xml", string(v))
+	case time.Time:
+		return j.special("$date", v.UTC().Format(jsonDateLayout))
+	case Amfarr:
+		if err := j.enter(v); err != nil {
+			return err
+		}
+		defer j.leave(v)
+		j.buf.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				j.buf.WriteByte(',')
+			}
+			if err := j.value(e); err != nil {
+				return err
+			}
+		}
+		j.buf.WriteByte(']')
+	case *Object:
+		if err := j.enter(v); err != nil {
+			return err
+		}
+		defer j.leave(v)
+		switch {
+		case v.ECMA:
+			j.buf.WriteByte('{')
+			j.key("$ecma")
+			if err := j.props(v.Props); err != nil {
+				return err
+			}
+			if v.Count != uint32(len(v.Props)) {
+				j.buf.WriteByte(',')
+				j.key("$count")
+				j.simple(v.Count)
+			}
+			j.buf.WriteByte('}')
+		case len(v.Props) > 0 && strings.HasPrefix(v.Props[0].Key, "$"):
+			j.buf.WriteByte('{')
+			j.key("$object")
+			if err := j.props(v.Props); err != nil {
+				return err
+			}
+			j.buf.WriteByte('}')
+		default:
+			return j.props(v.Props)
+		}
+	case Amfkv:
+		if err := j.enter(v); err != nil {
+			return err
+		}
+		defer j.leave(v)
+		o := objectFromKV(v)
+		return j.value(&o)
+	case TypedObject:
+		if err := j.enter(v.Members); err != nil {
+			return err
+		}
+		defer j.leave(v.Members)
+		j.buf.WriteByte('{')
+		j.key("$typed")
+		j.simple(v.Class)
+		j.buf.WriteByte(',')
+		j.key("$members")
+		if err := j.props(objectFromKV(v.Members).Props); err != nil {
+			return err
+		}
+		j.buf.WriteByte('}')
+	}
+	return nil
+}
+
+// 写入{"tag":v}
+func (j *jsonState) special(tag string, v any) error {
+	j.buf.WriteByte('{')
+	j.key(tag)
+	if err := j.value(v); err != nil {
+		return err
+	}
+	j.buf.WriteByte('}')
+	return nil
+}
+
+func (j *jsonState) props(props []Property) error {
+	j.buf.WriteByte('{')
+	for i, p := range props {
+		if i > 0 {
+			j.buf.WriteByte(',')
+		}
+		j.key(p.Key)
+		if err := j.value(p.Value); err != nil {
+			return err
+		}
+	}
+	j.buf.WriteByte('}')
+	return nil
+}
+
+type jsonParser struct {
+	dec *json.Decoder
+}
+
+func (p *jsonParser) value() (any, error) {
+	tok, err := p.dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case json.Number:
+		return t.Float64()
+	case json.Delim:
+		switch t {
+		case '[':
+			ar := Amfarr{}
+			for p.dec.More() {
+				v, err := p.value()
+				if err != nil {
+					return nil, err
+				}
+				ar = append(ar, v)
+			}
+			_, err = p.dec.Token()
+			return ar, err
+		case '{':
+			return p.object(false)
+		}
+		return nil, fmt.Errorf("amf0 json error: unexpected %v", t)
+	}
+	return tok, nil // string、bool、nil
+}
+
+// 解析'{'之后的部分，plain为false时第一个键以$开头的是特殊类型
+func (p *jsonParser) object(plain bool) (any, error) {
+	o := &Object{}
+	for p.dec.More() {
+		tok, err := p.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key := tok.(string)
+		if !plain && len(o.Props) == 0 && strings.HasPrefix(key, "$") {
+			return p.tagged(key)
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		o.Props = append(o.Props, Property{key, v})
+	}
+	_, err := p.dec.Token()
+	return o, err
+}
+
+// 解析一个普通的JSON对象
+func (p *jsonParser) props() (*Object, error) {
+	tok, err := p.dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if tok != json.Delim('{') {
+		return nil, fmt.Errorf("amf0 json error: expect object, got %v", tok)
+	}
+	v, err := p.object(true)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Object), nil
+}
+
+func (p *jsonParser) tagged(key string) (any, error) {
+	tags := make(map[string]any)
+	for {
+		var v any
+		var err error
+		switch key {
+		case "$object", "$ecma", "$members":
+			v, err = p.props()
+		default:
+			v, err = p.value()
+		}
+		if err != nil {
+			return nil, err
+		}
+		tags[key] = v
+		if !p.dec.More() {
+			break
+		}
+		tok, err := p.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key = tok.(string)
+	}
+	if _, err := p.dec.Token(); err != nil {
+		return nil, err
+	}
+
+	has := func(k string) bool {
+		_, ok := tags[k]
+		return ok
+	}
+	switch {
+	case has("$object"):
+		return tags["$object"], nil
+	case has("$ecma"):
+		o := tags["$ecma"].(*Object)
+		o.ECMA, o.Count = true, uint32(len(o.Props))
+		if n, ok := tags["$count"].(float64); ok {
+			o.Count = uint32(n)
+		}
+		return o, nil
+	case has("$undefined"):
+		return Undefined{}, nil
+	case has("$number"):
+		switch tags["$number"] {
+		case "NaN":
+			return math.NaN(), nil
+		case "Infinity":
+			return math.Inf(1), nil
+		case "-Infinity":
+			return math.Inf(-1), nil
+		}
+	case has("$date"):
+		if s, ok := tags["$date"].(string); ok {
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return nil, err
+			}
+			return t.UTC(), nil
+		}
+	case has("$xml"):
+		if s, ok := tags["$xml"].(string); ok {
+			return XMLDocument(s), nil
+		}
+	case has("$typed"):
+		class, ok := tags["$typed"].(string)
+		if !ok {
+			break
+		}
+		members := Amfkv{}
+		if o, ok := tags["$members"].(*Object); ok {
+			members = o.KV()
+		}
+		return TypedObject{class, members}, nil
+	}
+	return nil, fmt.Errorf("amf0 json error: invalid special value %v", tags)
+}
@@ -0,0 +1,58 @@
+package amf0
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestJSON(t *testing.T) {
+	date := time.Date(2024, 5, 1, 12, 0, 0, 123e6, time.UTC)
+	in := []any{
+		"onStatus", 0, nil, Undefined{}, "1", math.Inf(-1), date, XMLDocument("<a/>"),
+		&Object{Props: []Property{{"level", "status"}, {"code", "NetStream.Play.Start"}, {"$id", Amfarr{1.0, true}}}},
+		&Object{ECMA: true, Props: []Property{{"duration", 0.0}}},
+		TypedObject{"com.example.Foo", Amfkv{"name": "foo"}},
+	}
+	bs, err := Encode(in...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	js, err := ToJSON(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `["onStatus",0,null,{"$undefined":true},"1",{"$number":"-Infinity"},{"$date":"2024-05-01T12:00:00.123Z"},{"$xml":"<a/>"},` +
+		`{"level":"status","code":"NetStream.Play.Start","$id":[1,true]},{"$ecma":{"duration":0},"$count":0},` +
+		`{"$typed":"com.example.Foo","$members":{"name":"foo"}}]`
+	if string(js) != expect {
+		t.Fatalf("%s\nexpect %s", js, expect)
+	}
+	out, err := FromJSON(js)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, bs) {
+		t.Fatalf("%x\nexpect %x", out, bs)
+	}
+
+	// 第一个键以$开头的object
+	ar, err := DecodeJSON([]byte(`[{"$id":1}]`))
+	if err == nil {
+		t.Fatalf("expect error, got %v", ar)
+	}
+	if ar, err = DecodeJSON([]byte(`[{"$object":{"$id":1}}]`)); err != nil {
+		t.Fatal(err)
+	}
+	if o := ar[0].(*Object); o.ECMA || len(o.Props) != 1 || o.Props[0].Key != "$id" {
+		t.Fatalf("%#v", o)
+	}
+
+	// 循环引用
+	kv := Amfkv{}
+	kv["self"] = kv
+	if _, err = EncodeJSON(kv); err == nil {
+		t.Fatal("expect cyclic reference error")
+	}
+}
@@ -0,0 +1,129 @@
+package amf0
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// Property 对象的一个属性
+type Property struct {
+	Key   string
+	Value any
+}
+
+// Object 保持属性顺序的object或ECMA数组。
+// DecodeOrdered将object和ECMA数组解码为*Object，Encode按Props的顺序编码
+type Object struct {
+	Props []Property
+	ECMA  bool   // 编码为ECMA数组
+	Count uint32 // ECMA数组头部的数量，只是提示，可能和属性的数量不同
+}
+
+var objectType = reflect.TypeOf(Object{})
+
+// NewECMAArray 创建ECMA数组，数量为属性的数量
+func NewECMAArray(props ...Property) *Object {
+	return &Object{Props: props, ECMA: true, Count: uint32(len(props))}
+}
+
+// Get 返回key对应的值
+func (o *Object) Get(key string) (any, bool) {
+	for _, p := range o.Props {
+		if p.Key == key {
+			return p.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Set 修改key对应的值，不存在时添加到最后
+func (o *Object) Set(key string, value any) {
+	for i := range o.Props {
+		if o.Props[i].Key == key {
+			o.Props[i].Value = value
+			return
+		}
+	}
+	o.Props = append(o.Props, Property{key, value})
+	if o.ECMA && o.Count < uint32(len(o.Props)) {
+		o.Count = uint32(len(o.Props))
+	}
+}
+
+// Delete 删除key
+func (o *Object) Delete(key string) {
+	for i := range o.Props {
+		if o.Props[i].Key == key {
+			o.Props = append(o.Props[:i], o.Props[i+1:]...)
+			return
+		}
+	}
+}
+
+// KV 转换为Amfkv，重复的key取最后一个
+func (o *Object) KV() Amfkv {
+	kv := make(Amfkv, len(o.Props))
+	for _, p := range o.Props {
+		kv[p.Key] = p.Value
+	}
+	return kv
+}
+
+// 按key排序转换为Object
+func objectFromKV(kv Amfkv) Object {
+	keys := make([]string, 0, len(kv))
+	for k := range kv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	o := Object{Props: make([]Property, len(keys))}
+	for i, k := range keys {
+		o.Props[i] = Property{k, kv[k]}
+	}
+	return o
+}
+
+// DecodeOrdered 和Decode相同，但object和ECMA数组解码为*Object，保持属性的顺序
+func DecodeOrdered(data []byte) (ar Amfarr, err error) {
+	var d decodeState
+	d.init(data).ordered = true
+	return d.decode()
+}
+
+func (d *decodeState) orderedObject(ecma bool) *Object {
+	o := &Object{ECMA: ecma}
+	if ecma {
+		o.Count = d.u32()
+	}
+	d.refs = append(d.refs, o)
+	for d.err == nil && d.u24() != AMF_OBJECT_END {
+		d.unread(3)
+		key := d.str()
+		val := d.value()
+		o.Props = append(o.Props, Property{key, val})
+	}
+	return o
+}
+
+func (e *encodeState) orderedObject(o *Object) {
+	e.nref++
+	if o.ECMA {
+		e.buf.WriteByte(AMF_ECMA_ARRAY)
+		e.u32(o.Count)
+	} else {
+		e.buf.WriteByte(AMF_OBJECT)
+	}
+	for _, p := range o.Props {
+		size := len(p.Key)
+		if size > math.MaxUint16 {
+			e.err = fmt.Errorf("amf0 encode object error: key len %d is longer than %d.", size, math.MaxUint16)
+			return
+		}
+		e.u16(uint16(size))
+		e.buf.WriteString(p.Key)
+		e.value(p.Value)
+	}
+	e.buf.Write(objEnd)
+}
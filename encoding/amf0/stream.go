@@ -0,0 +1,125 @@
+package amf0
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// 数据流每次读取的大小，也是编码时缓冲的大小
+const readSize = 4096
+
+// SyntaxError 数据格式错误，Offset是出错的位置相对于输入开始的字节偏移
+type SyntaxError struct {
+	Offset int64
+	Err    error
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("amf0: %v at offset %d", e.Err, e.Offset)
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
+
+// StreamDecoder 从数据流中逐个解码AMF0值，只缓冲未处理的数据
+type StreamDecoder struct {
+	d decodeState
+}
+
+func NewStreamDecoder(r io.Reader) *StreamDecoder {
+	s := &StreamDecoder{}
+	s.d.init(nil).r = r
+	return s
+}
+
+// Ordered object和ECMA数组解码为*Object
+func (s *StreamDecoder) Ordered() *StreamDecoder {
+	s.d.ordered = true
+	return s
+}
+
+// Next 解码下一个值，数据在两个值之间结束时返回io.EOF
+func (s *StreamDecoder) Next() (any, error) {
+	if !s.d.more() {
+		if s.d.err != nil {
+			return nil, s.d.err
+		}
+		return nil, io.EOF
+	}
+	v := s.d.value()
+	if s.d.err != nil {
+		return nil, s.d.err
+	}
+	return v, nil
+}
+
+// Decode 解码下一个值到v，v为nil时跳过这个值
+func (s *StreamDecoder) Decode(v any) error {
+	r, err := s.Next()
+	if err != nil || v == nil {
+		return err
+	}
+	if u, ok := v.(Unmarshaler); ok {
+		bs, err := Encode(r)
+		if err != nil {
+			return err
+		}
+		return u.UnmarshalAMF(bs)
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return &InvalidUnmarshalError{rv.Type()}
+	}
+	ar := Amfarr{r}
+	return ar.deValue(0, indirect(rv, true))
+}
+
+// Offset 已解码的字节数
+func (s *StreamDecoder) Offset() int64 {
+	return s.d.pos()
+}
+
+// Encoder 将AMF0值编码到w，缓冲超过readSize时写入w
+type Encoder struct {
+	e encodeState
+	w io.Writer
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	enc := &Encoder{w: w}
+	enc.e.init().w = w
+	return enc
+}
+
+// SetReferences 设置同一次Encode中重复的对象是否写入引用(0x07)。
+// 默认不写入，librtmp等客户端不支持引用
+func (enc *Encoder) SetReferences(on bool) {
+	enc.e.ref = on
+}
+
+// Encode 依次编码v，开启引用时同一次调用中的对象可以相互引用
+func (enc *Encoder) Encode(v ...any) error {
+	e := &enc.e
+	for _, m := range v {
+		e.value(m)
+	}
+	if e.err == nil {
+		e.flush(0)
+	}
+	err := e.err
+	e.reset()
+	return err
+}
+
+// 缓冲的数据不少于n字节时写入w
+func (e *encodeState) flush(n int) {
+	if e.w == nil || e.err != nil || e.buf.Len() < n || e.buf.Len() == 0 {
+		return
+	}
+	if _, err := e.w.Write(e.buf.Bytes()); err != nil {
+		e.err = err
+	}
+	e.buf.Reset()
+}
@@ -0,0 +1,228 @@
+package amf0
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+// Undefined AMF0的undefined，和null区分
+type Undefined struct{}
+
+// XMLDocument XML文档，编码方式和长字符串相同
+type XMLDocument string
+
+// TypedObject 有类名的对象
+type TypedObject struct {
+	Class   string
+	Members Amfkv
+}
+
+var (
+	timeType        = reflect.TypeOf(time.Time{})
+	typedObjectType = reflect.TypeOf(TypedObject{})
+	undefinedType   = reflect.TypeOf(Undefined{})
+	xmlDocumentType = reflect.TypeOf(XMLDocument(""))
+)
+
+// Date是UTC的毫秒时间戳和2字节的时区，时区保留不用，应为0
+func (d *decodeState) date() time.Time {
+	ms := d.number()
+	d.u16() // time-zone
+	return time.UnixMilli(int64(ms)).UTC()
+}
+
+func (d *decodeState) xml() XMLDocument {
+	return XMLDocument(d.longstr())
+}
+
+// 引用之前解码的对象，索引按object、typed object、ecma array和strict array出现的顺序
+func (d *decodeState) reference() any {
+	i := int(d.u16())
+	if d.err != nil {
+		return nil
+	}
+	if i >= len(d.refs) {
+		d.err = &SyntaxError{d.pos() - 2, fmt.Errorf("reference %d out of range %d", i, len(d.refs))}
+		return nil
+	}
+	return d.refs[i]
+}
+
+func (d *decodeState) typedObject() TypedObject {
+	class := d.str()
+	m := make(map[string]any)
+	d.refs = append(d.refs, TypedObject{class, m})
+	d.members(m)
+	return TypedObject{class, m}
+}
+
+func (e *encodeState) date(t time.Time) {
+	e.buf.WriteByte(AMF_DATE)
+	e.cache = e.cache[:8]
+	e.order.PutUint64(e.cache, math.Float64bits(float64(t.UnixMilli())))
+	e.buf.Write(e.cache)
+	e.u16(0)
+}
+
+func (e *encodeState) xml(s XMLDocument) {
+	if len(s) > math.MaxUint32 {
+		e.err = fmt.Errorf("amf0 encode xml error: len %d is longer than %d.", len(s), math.MaxUint32)
+		return
+	}
+	e.buf.WriteByte(AMF_XML_DOCUMENT)
+	e.u32(uint32(len(s)))
+	e.buf.WriteString(string(s))
+}
+
+func (e *encodeState) typedObject(o TypedObject) {
+	size := len(o.Class)
+	if size > math.MaxUint16 {
+		e.err = fmt.Errorf("amf0 encode typed object error: class name len %d is longer than %d.", size, math.MaxUint16)
+		return
+	}
+	e.nref++
+	e.buf.WriteByte(AMF_TYPE_OBJECT)
+	e.u16(uint16(size))
+	e.buf.WriteString(o.Class)
+	for k, v := range o.Members {
+		size = len(k)
+		if size > math.MaxUint16 {
+			e.err = fmt.Errorf("amf0 encode typed object error: key len %d is longer than %d.", size, math.MaxUint16)
+			return
+		}
+		e.u16(uint16(size))
+		e.buf.WriteString(k)
+		e.value(v)
+	}
+	e.buf.Write(objEnd)
+}
+
+// 写入引用时，同一个map或结构体指针再次出现时写入引用，返回true，
+// 否则记录之后写入的对象的引用索引。
+// 很多客户端不支持引用，默认重复的对象再次完整写入，对象引用自身时返回错误
+func (e *encodeState) reference(ptr uintptr) bool {
+	i, ok := e.refs[ptr]
+	if !e.ref {
+		if ok {
+			e.err = fmt.Errorf("amf0 encode error: cyclic reference of %#x, use Encoder.SetReferences", ptr)
+			return true
+		}
+		e.refs[ptr] = 0
+		return false
+	}
+	if ok {
+		e.buf.WriteByte(AMF_REFERENCE)
+		e.u16(uint16(i))
+		return true
+	}
+	if e.nref <= math.MaxUint16 {
+		e.refs[ptr] = e.nref
+	}
+	return false
+}
+
+// 对象写入结束，不写入引用时之后可以再次写入
+func (e *encodeState) leave(ptr uintptr) {
+	if !e.ref {
+		delete(e.refs, ptr)
+	}
+}
+
+func (a Amfarr) GetTime(i int) (v time.Time, ok bool) {
+	v, ok = a[i].(time.Time)
+	return
+}
+
+func (a Amfkv) GetTime(key string) (v time.Time, ok bool) {
+	var r any
+	if r, ok = a[key]; ok {
+		v, ok = r.(time.Time)
+	}
+	return
+}
+
+func (a Amfarr) GetTypedObject(i int) (v TypedObject, ok bool) {
+	v, ok = a[i].(TypedObject)
+	return
+}
+
+func (a Amfkv) GetTypedObject(key string) (v TypedObject, ok bool) {
+	var r any
+	if r, ok = a[key]; ok {
+		v, ok = r.(TypedObject)
+	}
+	return
+}
+
+// 编码为object的结构体可以被引用
+func referable(t reflect.Type) bool {
+	switch t {
+	case timeType, typedObjectType, undefinedType, amf3ValueType:
+		return false
+	}
+	return t.Kind() == reflect.Struct
+}
+
+// 字符串和XML文档都可以作为字符串读取
+func asString(r any) (string, bool) {
+	switch r := r.(type) {
+	case string:
+		return r, true
+	case XMLDocument:
+		return string(r), true
+	}
+	return "", false
+}
+
+// TypedObject的成员可以作为Amfkv读取
+func asKV(r any) (Amfkv, bool) {
+	switch r := r.(type) {
+	case Amfkv:
+		return r, true
+	case TypedObject:
+		return r.Members, true
+	case *Object:
+		return r.KV(), true
+	case Object:
+		return r.KV(), true
+	}
+	return nil, false
+}
+
+// 解码到time.Time、TypedObject和Object，v不是这些类型时special为false
+func setSpecial(r any, v reflect.Value) (special bool, err error) {
+	switch v.Type() {
+	case timeType:
+		t, ok := r.(time.Time)
+		if !ok {
+			return true, &InvalidUnmarshalError{v.Type()}
+		}
+		v.Set(reflect.ValueOf(t))
+		return true, nil
+	case typedObjectType:
+		switch r := r.(type) {
+		case TypedObject:
+			v.Set(reflect.ValueOf(r))
+		case Amfkv:
+			v.Set(reflect.ValueOf(TypedObject{Members: r}))
+		default:
+			return true, &InvalidUnmarshalError{v.Type()}
+		}
+		return true, nil
+	case objectType:
+		switch r := r.(type) {
+		case *Object:
+			v.Set(reflect.ValueOf(*r))
+		case Object:
+			v.Set(reflect.ValueOf(r))
+		case Amfkv:
+			v.Set(reflect.ValueOf(objectFromKV(r)))
+		default:
+			return true, &InvalidUnmarshalError{v.Type()}
+		}
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,111 @@
+package amf3
+
+import (
+	"errors"
+	"time"
+)
+
+// AMF3类型
+const (
+	AMF3_UNDEFINED     = 0x00
+	AMF3_NULL          = 0x01
+	AMF3_FALSE         = 0x02
+	AMF3_TRUE          = 0x03
+	AMF3_INTEGER       = 0x04 //29位有符号整数
+	AMF3_DOUBLE        = 0x05 //浮点数
+	AMF3_STRING        = 0x06 //字符串
+	AMF3_XML_DOCUMENT  = 0x07 //flash.xml.XMLDocument
+	AMF3_DATE          = 0x08 //日期
+	AMF3_ARRAY         = 0x09 //数组，包含关联部分和稠密部分
+	AMF3_OBJECT        = 0x0A //对象
+	AMF3_XML           = 0x0B //E4X XML
+	AMF3_BYTE_ARRAY    = 0x0C //字节数组
+	AMF3_VECTOR_INT    = 0x0D
+	AMF3_VECTOR_UINT   = 0x0E
+	AMF3_VECTOR_DOUBLE = 0x0F
+	AMF3_VECTOR_OBJECT = 0x10
+	AMF3_DICTIONARY    = 0x11
+)
+
+// AMF3整数的范围，超出时编码为double
+const (
+	INT29_MIN = -1 << 28
+	INT29_MAX = 1<<28 - 1
+)
+
+var (
+	ErrDataMissing    = errors.New("amf3: data missing")
+	ErrU29Overflow    = errors.New("amf3: u29 overflow")
+	ErrInvalidRef     = errors.New("amf3: invalid reference")
+	ErrExternalizable = errors.New("amf3: unsupported externalizable class")
+)
+
+// 编码时不能被AMF3表示的类型
+type UnsupportedTypeError struct {
+	Type string
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return "amf3: unsupported type: " + e.Type
+}
+
+// XML E4X的XML
+type XML string
+
+// XMLDocument 旧的flash.xml.XMLDocument
+type XMLDocument string
+
+// ByteArray flash.utils.ByteArray
+type ByteArray []byte
+
+type VectorInt []int32
+
+type VectorUint []uint32
+
+type VectorDouble []float64
+
+// VectorObject Vector.<T>，TypeName为T的类名，"*"表示任意类型
+type VectorObject struct {
+	TypeName string
+	Items    []any
+}
+
+type DictionaryEntry struct {
+	Key   any
+	Value any
+}
+
+// Dictionary flash.utils.Dictionary，key可以是任意类型，按顺序保存
+type Dictionary struct {
+	WeakKeys bool
+	Entries  []DictionaryEntry
+}
+
+// Array 有关联部分的数组，只有稠密部分时解码为[]any
+type Array struct {
+	Assoc map[string]any
+	Dense []any
+}
+
+// TypedObject 有类名的对象，匿名对象解码为map[string]any
+type TypedObject struct {
+	Class   string
+	Members map[string]any
+}
+
+// 对象的traits
+type traits struct {
+	class          string
+	externalizable bool
+	dynamic        bool
+	members        []string
+}
+
+// Date在AMF3中是毫秒时间戳，没有时区
+func msToTime(ms float64) time.Time {
+	return time.UnixMilli(int64(ms)).UTC()
+}
+
+func timeToMs(t time.Time) float64 {
+	return float64(t.UnixMilli())
+}
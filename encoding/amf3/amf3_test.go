@@ -0,0 +1,103 @@
+package amf3
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestU29(t *testing.T) {
+	for _, n := range []int64{0, 1, 0x7F, 0x80, 0x3FFF, 0x4000, 0x1FFFFF, 0x200000, INT29_MAX, -1, INT29_MIN} {
+		bs, err := Encode(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		arr, err := Decode(bs)
+		if err != nil {
+			t.Fatalf("%d: %v", n, err)
+		}
+		if arr[0] != int32(n) {
+			t.Fatalf("%x: decode %v", n, arr[0])
+		}
+	}
+	// 超出29位的整数编码为double
+	bs, _ := Encode(INT29_MAX + 1)
+	if bs[0] != AMF3_DOUBLE {
+		t.Fatalf("expect double, got %x", bs)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	date := time.UnixMilli(1700000000123).UTC()
+	in := []any{
+		nil, true, false, 1.5, "hello", "", "hello",
+		XML("<a/>"), XMLDocument("<b/>"), date,
+		ByteArray{1, 2, 3},
+		VectorInt{-1, 2}, VectorUint{3, 4}, VectorDouble{0.5},
+		VectorObject{"String", []any{"x", "y"}},
+		Dictionary{true, []DictionaryEntry{{int32(1), "one"}, {"k", 2.5}}},
+		Array{map[string]any{"a": int32(1)}, []any{"b"}},
+		TypedObject{"com.example.Foo", map[string]any{"name": "foo"}},
+	}
+	bs, err := Encode(in...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := Decode(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != len(in) {
+		t.Fatalf("decode %d values, expect %d", len(out), len(in))
+	}
+	for i := range in {
+		if !reflect.DeepEqual(in[i], out[i]) {
+			t.Fatalf("value %d: %#v, expect %#v", i, out[i], in[i])
+		}
+	}
+}
+
+func TestReference(t *testing.T) {
+	type point struct {
+		X int `amf:"x"`
+		Y int `amf:"y"`
+		z int
+	}
+	m := map[string]any{"key": "value"}
+	bs, err := Encode([]any{m, m}, point{1, 2, 3}, &point{3, 4, 5}, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 第二个point使用traits引用，最后的字符串使用字符串引用
+	if !bytes.HasSuffix(bs, []byte{AMF3_STRING, 0x00}) {
+		t.Fatalf("expect string reference: %x", bs)
+	}
+	out, err := Decode(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	arr := out[0].([]any)
+	m0, m1 := arr[0].(map[string]any), arr[1].(map[string]any)
+	m0["key"] = "changed"
+	if m1["key"] != "changed" {
+		t.Fatal("expect object reference")
+	}
+	p := out[2].(map[string]any)
+	if p["x"] != int32(3) || p["y"] != int32(4) || len(p) != 2 {
+		t.Fatalf("%v", p)
+	}
+}
+
+func TestDecodeValue(t *testing.T) {
+	bs, _ := Encode("a", "b")
+	v, n, err := DecodeValue(bs)
+	if err != nil || v != "a" || n != 3 {
+		t.Fatalf("%v %d %v", v, n, err)
+	}
+	for i := 1; i < len(bs); i++ {
+		if _, err := Decode(bs[:i]); err == nil && i != 3 {
+			t.Fatalf("expect error on truncated data: %x", bs[:i])
+		}
+	}
+}
@@ -0,0 +1,457 @@
+package amf3
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Decode 解码data中的全部AMF3值，所有值共用引用表
+func Decode(data []byte) ([]any, error) {
+	var d decodeState
+	d.data = data
+	var arr []any
+	for d.offset < len(d.data) {
+		v := d.value()
+		if d.err != nil {
+			return nil, d.err
+		}
+		arr = append(arr, v)
+	}
+	return arr, nil
+}
+
+// DecodeValue 解码data开头的一个AMF3值，返回读取的字节数。
+// 用于AMF0中的AMF3切换标记，每个值使用新的引用表。
+func DecodeValue(data []byte) (v any, n int, err error) {
+	var d decodeState
+	d.data = data
+	v = d.value()
+	if d.err != nil {
+		return nil, d.offset, d.err
+	}
+	return v, d.offset, nil
+}
+
+// DecodeReader 解码一个AMF3值，先读取buf中的数据，不足时从r中读取。
+// rest是读取了但没有使用的数据，n是这个值的字节数
+func DecodeReader(buf []byte, r io.Reader) (v any, rest []byte, n int, err error) {
+	var d decodeState
+	d.data, d.r = buf, r
+	v = d.value()
+	if d.err != nil {
+		return nil, nil, d.offset, d.err
+	}
+	return v, d.data[d.offset:], d.offset, nil
+}
+
+type decodeState struct {
+	data    []byte
+	offset  int
+	r       io.Reader
+	err     error
+	strings []string // 字符串引用表
+	objects []any    // 对象引用表
+	traits  []traits // traits引用表
+}
+
+// 检查是否还有n字节
+func (d *decodeState) need(n int) bool {
+	if d.err != nil {
+		return false
+	}
+	if n < 0 || len(d.data)-d.offset < n && !d.fill(n) {
+		d.err = ErrDataMissing
+		return false
+	}
+	return true
+}
+
+// 从r中读取，直到至少有n字节未处理的数据
+func (d *decodeState) fill(n int) bool {
+	if d.r == nil {
+		return false
+	}
+	for len(d.data)-d.offset < n {
+		if len(d.data) == cap(d.data) {
+			d.data = append(d.data, make([]byte, 4096)...)[:len(d.data)]
+		}
+		m, err := d.r.Read(d.data[len(d.data):cap(d.data)])
+		d.data = d.data[:len(d.data)+m]
+		if err != nil && len(d.data)-d.offset < n {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *decodeState) value() any {
+	if !d.need(1) {
+		return nil
+	}
+	marker := d.data[d.offset]
+	d.offset++
+	switch marker {
+	default:
+		d.err = fmt.Errorf("amf3: unsupported type: %d", marker)
+		return nil
+	case AMF3_UNDEFINED, AMF3_NULL:
+		return nil
+	case AMF3_FALSE:
+		return false
+	case AMF3_TRUE:
+		return true
+	case AMF3_INTEGER:
+		return d.integer()
+	case AMF3_DOUBLE:
+		return d.double()
+	case AMF3_STRING:
+		return d.str()
+	case AMF3_XML_DOCUMENT:
+		return XMLDocument(d.text())
+	case AMF3_XML:
+		return XML(d.text())
+	case AMF3_DATE:
+		return d.date()
+	case AMF3_ARRAY:
+		return d.array()
+	case AMF3_OBJECT:
+		return d.object()
+	case AMF3_BYTE_ARRAY:
+		return d.byteArray()
+	case AMF3_VECTOR_INT, AMF3_VECTOR_UINT, AMF3_VECTOR_DOUBLE, AMF3_VECTOR_OBJECT:
+		return d.vector(marker)
+	case AMF3_DICTIONARY:
+		return d.dictionary()
+	}
+}
+
+// 可变长的29位无符号整数，前3个字节使用低7位，第4个字节使用全部8位
+func (d *decodeState) u29() uint32 {
+	var v uint32
+	for i := 0; i < 4; i++ {
+		if !d.need(1) {
+			return 0
+		}
+		b := d.data[d.offset]
+		d.offset++
+		if i == 3 {
+			return v<<8 | uint32(b)
+		}
+		v = v<<7 | uint32(b&0x7F)
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	return v
+}
+
+func (d *decodeState) integer() int32 {
+	v := d.u29()
+	if v&0x10000000 != 0 {
+		return int32(v) - 0x20000000
+	}
+	return int32(v)
+}
+
+func (d *decodeState) double() float64 {
+	if !d.need(8) {
+		return 0
+	}
+	v := binary.BigEndian.Uint64(d.data[d.offset:])
+	d.offset += 8
+	return math.Float64frombits(v)
+}
+
+// 读取指定长度的数据
+func (d *decodeState) bytes(n int) []byte {
+	if !d.need(n) {
+		return nil
+	}
+	bs := d.data[d.offset : d.offset+n]
+	d.offset += n
+	return bs
+}
+
+// U29S-ref或U29S-value，空字符串不加入引用表
+func (d *decodeState) str() string {
+	u := d.u29()
+	if d.err != nil {
+		return ""
+	}
+	if u&0x01 == 0 {
+		i := int(u >> 1)
+		if i >= len(d.strings) {
+			d.err = ErrInvalidRef
+			return ""
+		}
+		return d.strings[i]
+	}
+	s := string(d.bytes(int(u >> 1)))
+	if s != "" {
+		d.strings = append(d.strings, s)
+	}
+	return s
+}
+
+// 读取对象引用，ok为true时返回引用的对象，否则返回U29的剩余部分
+func (d *decodeState) ref() (obj any, u uint32, ok bool) {
+	u = d.u29()
+	if d.err != nil {
+		return nil, 0, true
+	}
+	if u&0x01 == 0 {
+		i := int(u >> 1)
+		if i >= len(d.objects) {
+			d.err = ErrInvalidRef
+			return nil, 0, true
+		}
+		return d.objects[i], 0, true
+	}
+	return nil, u >> 1, false
+}
+
+// 引用表中占一个位置，返回索引，之后用set替换
+func (d *decodeState) reserve() int {
+	d.objects = append(d.objects, nil)
+	return len(d.objects) - 1
+}
+
+func (d *decodeState) text() string {
+	obj, n, ok := d.ref()
+	if ok {
+		switch v := obj.(type) {
+		case XML:
+			return string(v)
+		case XMLDocument:
+			return string(v)
+		}
+		return ""
+	}
+	s := string(d.bytes(int(n)))
+	d.objects = append(d.objects, XML(s))
+	return s
+}
+
+func (d *decodeState) date() any {
+	obj, _, ok := d.ref()
+	if ok {
+		return obj
+	}
+	t := msToTime(d.double())
+	d.objects = append(d.objects, t)
+	return t
+}
+
+func (d *decodeState) byteArray() any {
+	obj, n, ok := d.ref()
+	if ok {
+		return obj
+	}
+	bs := d.bytes(int(n))
+	if d.err != nil {
+		return nil
+	}
+	v := make(ByteArray, len(bs))
+	copy(v, bs)
+	d.objects = append(d.objects, v)
+	return v
+}
+
+func (d *decodeState) array() any {
+	obj, n, ok := d.ref()
+	if ok {
+		return obj
+	}
+	idx := d.reserve()
+	var assoc map[string]any
+	for d.err == nil {
+		key := d.str()
+		if key == "" {
+			break
+		}
+		if assoc == nil {
+			assoc = make(map[string]any)
+		}
+		assoc[key] = d.value()
+	}
+	if !d.need(int(n)) {
+		return nil
+	}
+	dense := make([]any, n)
+	for i := range dense {
+		dense[i] = d.value()
+	}
+	if d.err != nil {
+		return nil
+	}
+	var v any = dense
+	if assoc != nil {
+		v = Array{assoc, dense}
+	}
+	d.objects[idx] = v
+	return v
+}
+
+func (d *decodeState) readTraits(u uint32) (t traits) {
+	// u的最低位已去掉，下一位为0时是traits引用
+	if u&0x01 == 0 {
+		i := int(u >> 1)
+		if i >= len(d.traits) {
+			d.err = ErrInvalidRef
+			return
+		}
+		return d.traits[i]
+	}
+	t.externalizable = u&0x02 != 0
+	t.dynamic = u&0x04 != 0
+	count := int(u >> 3)
+	t.class = d.str()
+	if !t.externalizable {
+		if !d.need(count) {
+			return
+		}
+		t.members = make([]string, count)
+		for i := range t.members {
+			t.members[i] = d.str()
+		}
+	}
+	d.traits = append(d.traits, t)
+	return
+}
+
+func (d *decodeState) object() any {
+	obj, u, ok := d.ref()
+	if ok {
+		return obj
+	}
+	t := d.readTraits(u)
+	if d.err != nil {
+		return nil
+	}
+	if t.externalizable {
+		return d.externalizable(t.class)
+	}
+	members := make(map[string]any, len(t.members))
+	var v any = members
+	if t.class != "" {
+		v = TypedObject{t.class, members}
+	}
+	d.objects = append(d.objects, v)
+	for _, key := range t.members {
+		members[key] = d.value()
+	}
+	for t.dynamic && d.err == nil {
+		key := d.str()
+		if key == "" {
+			break
+		}
+		members[key] = d.value()
+	}
+	if d.err != nil {
+		return nil
+	}
+	return v
+}
+
+// Flex中常见的外部化类只包含一个值，其他的类无法解析
+func (d *decodeState) externalizable(class string) any {
+	switch class {
+	default:
+		d.err = ErrExternalizable
+		return nil
+	case "flex.messaging.io.ArrayCollection", "flex.messaging.io.ObjectProxy":
+		idx := d.reserve()
+		v := d.value()
+		d.objects[idx] = v
+		return v
+	}
+}
+
+func (d *decodeState) vector(marker byte) any {
+	obj, n, ok := d.ref()
+	if ok {
+		return obj
+	}
+	if !d.need(1) {
+		return nil
+	}
+	d.offset++ // fixed-vector
+	count := int(n)
+	var v any
+	switch marker {
+	case AMF3_VECTOR_INT:
+		if !d.need(4 * count) {
+			return nil
+		}
+		vec := make(VectorInt, count)
+		for i := range vec {
+			vec[i] = int32(binary.BigEndian.Uint32(d.bytes(4)))
+		}
+		v = vec
+	case AMF3_VECTOR_UINT:
+		if !d.need(4 * count) {
+			return nil
+		}
+		vec := make(VectorUint, count)
+		for i := range vec {
+			vec[i] = binary.BigEndian.Uint32(d.bytes(4))
+		}
+		v = vec
+	case AMF3_VECTOR_DOUBLE:
+		if !d.need(8 * count) {
+			return nil
+		}
+		vec := make(VectorDouble, count)
+		for i := range vec {
+			vec[i] = d.double()
+		}
+		v = vec
+	case AMF3_VECTOR_OBJECT:
+		idx := d.reserve()
+		typeName := d.str()
+		if !d.need(count) {
+			return nil
+		}
+		items := make([]any, count)
+		for i := range items {
+			items[i] = d.value()
+		}
+		if d.err != nil {
+			return nil
+		}
+		v = VectorObject{typeName, items}
+		d.objects[idx] = v
+		return v
+	}
+	d.objects = append(d.objects, v)
+	return v
+}
+
+func (d *decodeState) dictionary() any {
+	obj, n, ok := d.ref()
+	if ok {
+		return obj
+	}
+	if !d.need(1) {
+		return nil
+	}
+	weak := d.data[d.offset] != 0
+	d.offset++
+	idx := d.reserve()
+	// 每个键值对至少2字节
+	if !d.need(2 * int(n)) {
+		return nil
+	}
+	dict := Dictionary{weak, make([]DictionaryEntry, n)}
+	for i := range dict.Entries {
+		dict.Entries[i].Key = d.value()
+		dict.Entries[i].Value = d.value()
+	}
+	if d.err != nil {
+		return nil
+	}
+	d.objects[idx] = dict
+	return dict
+}
@@ -0,0 +1,396 @@
+package amf3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Encode 编码为AMF3，所有值共用引用表
+func Encode(v ...any) ([]byte, error) {
+	e := newEncodeState()
+	for _, m := range v {
+		e.value(reflect.ValueOf(m))
+		if e.err != nil {
+			return nil, e.err
+		}
+	}
+	return e.buf.Bytes(), nil
+}
+
+type encodeState struct {
+	buf     bytes.Buffer
+	cache   [8]byte
+	err     error
+	strings map[string]int
+	objects map[uintptr]int // map和指针的引用
+	nobject int             // 对象引用表的大小
+	traits  map[any]int
+}
+
+func newEncodeState() *encodeState {
+	return &encodeState{
+		strings: make(map[string]int),
+		objects: make(map[uintptr]int),
+		traits:  make(map[any]int),
+	}
+}
+
+func (e *encodeState) value(v reflect.Value) {
+	if e.err != nil {
+		return
+	}
+	if !v.IsValid() {
+		e.buf.WriteByte(AMF3_NULL)
+		return
+	}
+	switch x := v.Interface().(type) {
+	case XML:
+		e.text(AMF3_XML, string(x))
+		return
+	case XMLDocument:
+		e.text(AMF3_XML_DOCUMENT, string(x))
+		return
+	case time.Time:
+		e.date(x)
+		return
+	case ByteArray:
+		e.byteArray(x)
+		return
+	case []byte:
+		e.byteArray(x)
+		return
+	case VectorInt:
+		e.vectorInt(x)
+		return
+	case VectorUint:
+		e.vectorUint(x)
+		return
+	case VectorDouble:
+		e.vectorDouble(x)
+		return
+	case VectorObject:
+		e.vectorObject(x)
+		return
+	case Dictionary:
+		e.dictionary(x)
+		return
+	case Array:
+		e.array(x.Assoc, x.Dense)
+		return
+	case TypedObject:
+		e.dynamicObject(x.Class, reflect.ValueOf(x.Members))
+		return
+	}
+
+	switch v.Kind() {
+	default:
+		e.err = &UnsupportedTypeError{v.Type().String()}
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf.WriteByte(AMF3_TRUE)
+		} else {
+			e.buf.WriteByte(AMF3_FALSE)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.integer(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n := v.Uint(); n <= INT29_MAX {
+			e.integer(int64(n))
+		} else {
+			e.double(float64(n))
+		}
+	case reflect.Float32, reflect.Float64:
+		e.double(v.Float())
+	case reflect.String:
+		e.buf.WriteByte(AMF3_STRING)
+		e.str(v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			e.buf.WriteByte(AMF3_NULL)
+			return
+		}
+		dense := make([]any, v.Len())
+		for i := range dense {
+			dense[i] = v.Index(i).Interface()
+		}
+		e.array(nil, dense)
+	case reflect.Map:
+		if v.IsNil() {
+			e.buf.WriteByte(AMF3_NULL)
+			return
+		}
+		if v.Type().Key().Kind() != reflect.String {
+			e.err = &UnsupportedTypeError{v.Type().String()}
+			return
+		}
+		e.dynamicObject("", v)
+	case reflect.Struct:
+		e.structObject(v, 0)
+	case reflect.Interface:
+		if v.IsNil() {
+			e.buf.WriteByte(AMF3_NULL)
+			return
+		}
+		e.value(v.Elem())
+	case reflect.Pointer:
+		if v.IsNil() {
+			e.buf.WriteByte(AMF3_NULL)
+			return
+		}
+		if v.Elem().Kind() == reflect.Struct {
+			e.structObject(v.Elem(), v.Pointer())
+			return
+		}
+		e.value(v.Elem())
+	}
+}
+
+// 写入U29，超过29位时记录错误
+func (e *encodeState) u29(v uint32) {
+	switch {
+	case v < 0x80:
+		e.buf.WriteByte(byte(v))
+	case v < 0x4000:
+		e.buf.Write([]byte{byte(v>>7) | 0x80, byte(v & 0x7F)})
+	case v < 0x200000:
+		e.buf.Write([]byte{byte(v>>14) | 0x80, byte(v>>7) | 0x80, byte(v & 0x7F)})
+	case v < 0x20000000:
+		e.buf.Write([]byte{byte(v>>22) | 0x80, byte(v>>15) | 0x80, byte(v>>8) | 0x80, byte(v)})
+	default:
+		e.err = ErrU29Overflow
+	}
+}
+
+func (e *encodeState) integer(n int64) {
+	if n < INT29_MIN || n > INT29_MAX {
+		e.double(float64(n))
+		return
+	}
+	e.buf.WriteByte(AMF3_INTEGER)
+	e.u29(uint32(n) & 0x1FFFFFFF)
+}
+
+func (e *encodeState) double(f float64) {
+	e.buf.WriteByte(AMF3_DOUBLE)
+	e.f64(f)
+}
+
+func (e *encodeState) f64(f float64) {
+	binary.BigEndian.PutUint64(e.cache[:], math.Float64bits(f))
+	e.buf.Write(e.cache[:8])
+}
+
+func (e *encodeState) u32(v uint32) {
+	binary.BigEndian.PutUint32(e.cache[:], v)
+	e.buf.Write(e.cache[:4])
+}
+
+// 字符串，不含类型标记
+func (e *encodeState) str(s string) {
+	if i, ok := e.strings[s]; ok {
+		e.u29(uint32(i) << 1)
+		return
+	}
+	if s != "" {
+		e.strings[s] = len(e.strings)
+	}
+	e.u29(uint32(len(s))<<1 | 0x01)
+	e.buf.WriteString(s)
+}
+
+// 已经写过的map或指针写入引用，否则加入引用表
+func (e *encodeState) writeRef(ptr uintptr) bool {
+	if ptr != 0 {
+		if i, ok := e.objects[ptr]; ok {
+			e.u29(uint32(i) << 1)
+			return true
+		}
+		e.objects[ptr] = e.nobject
+	}
+	e.nobject++
+	return false
+}
+
+func (e *encodeState) text(marker byte, s string) {
+	e.buf.WriteByte(marker)
+	e.nobject++
+	e.u29(uint32(len(s))<<1 | 0x01)
+	e.buf.WriteString(s)
+}
+
+func (e *encodeState) date(t time.Time) {
+	e.buf.WriteByte(AMF3_DATE)
+	e.nobject++
+	e.u29(0x01)
+	e.f64(timeToMs(t))
+}
+
+func (e *encodeState) byteArray(bs []byte) {
+	e.buf.WriteByte(AMF3_BYTE_ARRAY)
+	e.nobject++
+	e.u29(uint32(len(bs))<<1 | 0x01)
+	e.buf.Write(bs)
+}
+
+func (e *encodeState) array(assoc map[string]any, dense []any) {
+	e.buf.WriteByte(AMF3_ARRAY)
+	e.nobject++
+	e.u29(uint32(len(dense))<<1 | 0x01)
+	for _, k := range sortedKeys(assoc) {
+		if k == "" {
+			continue
+		}
+		e.str(k)
+		e.value(reflect.ValueOf(assoc[k]))
+	}
+	e.str("")
+	for _, v := range dense {
+		e.value(reflect.ValueOf(v))
+	}
+}
+
+// 写入traits，已写过的写入引用
+func (e *encodeState) writeTraits(key any, dynamic bool, class string, members []string) {
+	if i, ok := e.traits[key]; ok {
+		e.u29(uint32(i)<<2 | 0x01)
+		return
+	}
+	e.traits[key] = len(e.traits)
+	u := uint32(len(members))<<4 | 0x03
+	if dynamic {
+		u |= 0x08
+	}
+	e.u29(u)
+	e.str(class)
+	for _, m := range members {
+		e.str(m)
+	}
+}
+
+// 没有sealed成员的动态对象，map的key作为动态成员
+func (e *encodeState) dynamicObject(class string, m reflect.Value) {
+	e.buf.WriteByte(AMF3_OBJECT)
+	var ptr uintptr
+	if m.Kind() == reflect.Map && !m.IsNil() {
+		ptr = m.Pointer()
+	}
+	if e.writeRef(ptr) {
+		return
+	}
+	e.writeTraits("dynamic:"+class, true, class, nil)
+	if m.Kind() == reflect.Map {
+		keys := m.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, k := range keys {
+			if k.String() == "" {
+				continue
+			}
+			e.str(k.String())
+			e.value(m.MapIndex(k))
+		}
+	}
+	e.str("")
+}
+
+// 结构体编码为匿名的sealed对象，字段名使用amf标签
+func (e *encodeState) structObject(v reflect.Value, ptr uintptr) {
+	e.buf.WriteByte(AMF3_OBJECT)
+	if e.writeRef(ptr) {
+		return
+	}
+	t := v.Type()
+	var members []string
+	var index [][]int
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+		key := f.Tag.Get("amf")
+		if key == "-" {
+			continue
+		}
+		if key == "" {
+			key = f.Name
+		}
+		members = append(members, key)
+		index = append(index, f.Index)
+	}
+	e.writeTraits(t, false, "", members)
+	for _, i := range index {
+		// 嵌入的结构体指针为nil时写入null
+		fv, err := v.FieldByIndexErr(i)
+		if err != nil {
+			e.buf.WriteByte(AMF3_NULL)
+			continue
+		}
+		e.value(fv)
+	}
+}
+
+func (e *encodeState) vectorHeader(marker byte, n int) {
+	e.buf.WriteByte(marker)
+	e.nobject++
+	e.u29(uint32(n)<<1 | 0x01)
+	e.buf.WriteByte(0) // fixed-vector
+}
+
+func (e *encodeState) vectorInt(v VectorInt) {
+	e.vectorHeader(AMF3_VECTOR_INT, len(v))
+	for _, n := range v {
+		e.u32(uint32(n))
+	}
+}
+
+func (e *encodeState) vectorUint(v VectorUint) {
+	e.vectorHeader(AMF3_VECTOR_UINT, len(v))
+	for _, n := range v {
+		e.u32(n)
+	}
+}
+
+func (e *encodeState) vectorDouble(v VectorDouble) {
+	e.vectorHeader(AMF3_VECTOR_DOUBLE, len(v))
+	for _, f := range v {
+		e.f64(f)
+	}
+}
+
+func (e *encodeState) vectorObject(v VectorObject) {
+	e.vectorHeader(AMF3_VECTOR_OBJECT, len(v.Items))
+	typeName := v.TypeName
+	if typeName == "" {
+		typeName = "*"
+	}
+	e.str(typeName)
+	for _, item := range v.Items {
+		e.value(reflect.ValueOf(item))
+	}
+}
+
+func (e *encodeState) dictionary(v Dictionary) {
+	e.buf.WriteByte(AMF3_DICTIONARY)
+	e.nobject++
+	e.u29(uint32(len(v.Entries))<<1 | 0x01)
+	if v.WeakKeys {
+		e.buf.WriteByte(1)
+	} else {
+		e.buf.WriteByte(0)
+	}
+	for _, entry := range v.Entries {
+		e.value(reflect.ValueOf(entry.Key))
+		e.value(reflect.ValueOf(entry.Value))
+	}
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -9,6 +9,7 @@ const (
 type Packet struct {
 	IsConfig   bool   // 是否是解码配置，如sps，pps
 	IsKeyFrame bool   // 是否关键帧
+	Disposable bool   // 是否非参考帧，丢弃后不影响后续解码，AVC由Stream按配置帧设置
 	Type       uint8  // 包类型，8-audio，9-video，18-meta
 	Timestamp  uint32 // 时间戳
 	Payload    []byte // 负载
@@ -24,18 +25,124 @@ func (p *Packet) parseAudio() {
 	}
 }
 
+// 视频编码
+const (
+	CODEC_AVC  = 7
+	CODEC_HEVC = 12 // 国内常用的扩展
+)
+
+// enhanced rtmp的packet type
+const (
+	PACKET_SEQUENCE_START = iota
+	PACKET_CODED_FRAMES
+	PACKET_SEQUENCE_END
+	PACKET_CODED_FRAMES_X // 没有composition time
+)
+
 func (p *Packet) parseVideo() {
 	if len(p.Payload) < 2 {
 		return
 	}
+	if p.IsEnhanced() {
+		// enhanced rtmp: isExHeader(1) frameType(3) packetType(4) fourCC(4)
+		p.IsKeyFrame = (p.Payload[0]>>4)&0x07 == 1
+		p.IsConfig = p.Payload[0]&0x0F == PACKET_SEQUENCE_START
+		return
+	}
 	frameType := p.Payload[0] >> 4
 	format := p.Payload[0] & 0x0F
 	p.IsKeyFrame = frameType == 1
-	if format == 7 { // AVC
+	// disposable inter frame (H.263 only)
+	p.Disposable = frameType == 3
+	switch format {
+	case CODEC_AVC, CODEC_HEVC:
 		p.IsConfig = p.Payload[1] == 0
 	}
 }
 
+// IsEnhanced 是否是enhanced rtmp格式的视频
+func (p *Packet) IsEnhanced() bool {
+	return p.IsVideo() && len(p.Payload) > 0 && p.Payload[0]&0x80 != 0
+}
+
+// VideoCodec 返回视频的FourCC，如avc1、hvc1，不认识的编码返回空
+func (p *Packet) VideoCodec() string {
+	if !p.IsVideo() || len(p.Payload) == 0 {
+		return ""
+	}
+	if p.IsEnhanced() {
+		if len(p.Payload) < 5 {
+			return ""
+		}
+		return string(p.Payload[1:5])
+	}
+	switch p.Payload[0] & 0x0F {
+	case CODEC_AVC:
+		return "avc1"
+	case CODEC_HEVC:
+		return "hvc1"
+	}
+	return ""
+}
+
+// VideoData 返回视频的composition time(毫秒)和去掉FLV头部的数据，
+// 配置帧的数据是AVCDecoderConfigurationRecord或HEVCDecoderConfigurationRecord
+func (p *Packet) VideoData() (cts int32, data []byte) {
+	bs := p.Payload
+	if p.IsEnhanced() {
+		if len(bs) < 5 {
+			return 0, nil
+		}
+		if bs[0]&0x0F != PACKET_CODED_FRAMES {
+			return 0, bs[5:]
+		}
+		// 对齐到普通格式，composition time在fourCC之后
+		bs = bs[3:]
+	}
+	if len(bs) < 5 {
+		return 0, nil
+	}
+	// 24位有符号数
+	cts = int32(uint32(bs[2])<<16|uint32(bs[3])<<8|uint32(bs[4])) << 8 >> 8
+	return cts, bs[5:]
+}
+
+// NALULengthSize 返回AVC配置帧中NALU长度的字节数，不是AVC配置帧时返回0
+func (p *Packet) NALULengthSize() int {
+	if !p.IsVideo() || !p.IsConfig || p.IsEnhanced() || len(p.Payload) < 10 || p.Payload[0]&0x0F != CODEC_AVC {
+		return 0
+	}
+	// AVCDecoderConfigurationRecord: version, profile, compatibility, level, lengthSizeMinusOne
+	return int(p.Payload[9]&0x03) + 1
+}
+
+// NonReference AVC帧中所有NALU的nal_ref_idc为0时返回true，该帧不被其它帧参考。
+// lengthSize是配置帧中NALU长度的字节数
+func (p *Packet) NonReference(lengthSize int) bool {
+	if !p.IsVideo() || p.IsConfig || p.IsKeyFrame || p.IsEnhanced() || lengthSize <= 0 ||
+		len(p.Payload) < 5 || p.Payload[0]&0x0F != CODEC_AVC {
+		return false
+	}
+	bs := p.Payload[5:] // skip composition time
+	found := false
+	for len(bs) > lengthSize {
+		size := 0
+		for _, b := range bs[:lengthSize] {
+			size = size<<8 | int(b)
+		}
+		bs = bs[lengthSize:]
+		if size <= 0 || size > len(bs) {
+			return false
+		}
+		if bs[0]&0x60 != 0 {
+			return false
+		}
+		found = true
+		bs = bs[size:]
+	}
+	return found
+}
+
 func (p *Packet) IsAudio() bool {
 	return p.Type == AUDIO
 }
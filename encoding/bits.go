@@ -0,0 +1,129 @@
+package encoding
+
+import "io"
+
+// BitReader 按位读取，高位在前，用于解析SPS等使用Exp-Golomb编码的数据。
+// 读取越界后返回0并记录io.ErrUnexpectedEOF，由Error返回。
+type BitReader struct {
+	raw []byte
+	pos int   // 已读取的位数
+	err error // 第一个错误，之后的读取都返回0
+}
+
+func NewBitReader(bs []byte) *BitReader {
+	return &BitReader{raw: bs}
+}
+
+// 读取1位
+func (r *BitReader) ReadBit() uint32 {
+	return r.ReadBits(1)
+}
+
+// 读取1位，1为true
+func (r *BitReader) ReadFlag() bool {
+	return r.ReadBits(1) == 1
+}
+
+// 读取n位，n不超过32
+func (r *BitReader) ReadBits(n int) uint32 {
+	if !r.checkBeforeRead(n) {
+		return 0
+	}
+	var v uint32
+	for i := 0; i < n; i++ {
+		b := r.raw[r.pos>>3] >> (7 - uint(r.pos&0x07)) & 0x01
+		v = v<<1 | uint32(b)
+		r.pos++
+	}
+	return v
+}
+
+// 跳过n位
+func (r *BitReader) Skip(n int) {
+	if r.checkBeforeRead(n) {
+		r.pos += n
+	}
+}
+
+// 读取无符号Exp-Golomb编码ue(v)
+func (r *BitReader) ReadUE() uint32 {
+	zeros := 0
+	for r.err == nil && r.ReadBit() == 0 {
+		zeros++
+		if zeros > 31 {
+			r.err = io.ErrUnexpectedEOF
+			return 0
+		}
+	}
+	if r.err != nil {
+		return 0
+	}
+	return 1<<uint(zeros) - 1 + r.ReadBits(zeros)
+}
+
+// 读取有符号Exp-Golomb编码se(v)
+func (r *BitReader) ReadSE() int32 {
+	v := r.ReadUE()
+	if v&0x01 == 1 {
+		return int32(v/2) + 1
+	}
+	return -int32(v / 2)
+}
+
+// 剩余位数
+func (r *BitReader) Remain() int {
+	return len(r.raw)*8 - r.pos
+}
+
+func (r *BitReader) Error() error {
+	return r.err
+}
+
+// SetError 记录解析数据时发现的错误，已有错误时忽略
+func (r *BitReader) SetError(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// 检查是否可以读取n位
+func (r *BitReader) checkBeforeRead(n int) bool {
+	if r.err != nil {
+		return false
+	}
+	if n < 0 || r.Remain() < n {
+		r.err = io.ErrUnexpectedEOF
+		return false
+	}
+	return true
+}
+
+// BitWriter 按位写入，高位在前
+type BitWriter struct {
+	raw []byte
+	n   int // 已写入的位数
+}
+
+// 写入v的低n位，n不超过32
+func (w *BitWriter) WriteBits(n int, v uint32) {
+	for i := n - 1; i >= 0; i-- {
+		if w.n&0x07 == 0 {
+			w.raw = append(w.raw, 0)
+		}
+		w.raw[len(w.raw)-1] |= byte(v>>uint(i)&0x01) << (7 - uint(w.n&0x07))
+		w.n++
+	}
+}
+
+func (w *BitWriter) WriteFlag(b bool) {
+	if b {
+		w.WriteBits(1, 1)
+	} else {
+		w.WriteBits(1, 0)
+	}
+}
+
+// 已写入的数据，最后一个字节不足8位时低位为0
+func (w *BitWriter) Bytes() []byte {
+	return w.raw
+}
@@ -0,0 +1,243 @@
+package codec
+
+import (
+	"errors"
+	"time"
+
+	"github.com/chenyj/rtmp/encoding"
+)
+
+var (
+	ASC_FMT_ERROR  = errors.New("codec: invalid audio specific config")
+	ADTS_FMT_ERROR = errors.New("codec: invalid adts frame")
+)
+
+// audioObjectType
+const (
+	AAC_MAIN = 1
+	AAC_LC   = 2
+	AAC_SSR  = 3
+	AAC_LTP  = 4
+	AAC_SBR  = 5  // HE-AAC
+	AAC_PS   = 29 // HE-AACv2
+)
+
+const ADTS_HEADER_SIZE = 7
+
+var aacSampleRates = [...]int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// channelConfiguration对应的声道数
+var aacChannels = [...]int{0, 1, 2, 3, 4, 5, 6, 8, 0, 0, 0, 7, 8, 24, 8}
+
+// AudioSpecificConfig AAC的解码配置。
+// HE-AAC的ObjectType和SampleRate是核心AAC LC的，SBR输出的采样率为ExtensionSampleRate。
+type AudioSpecificConfig struct {
+	ObjectType          uint8
+	SampleRate          int
+	ChannelConfig       uint8
+	Channels            int  // channelConfiguration为0时由PCE指定，为0
+	FrameLength         int  // 每帧的采样数，1024或960
+	SBR                 bool // 显式声明了SBR
+	PS                  bool // 显式声明了PS
+	ExtensionSampleRate int  // SBR的采样率，没有SBR时为0
+}
+
+func readObjectType(r *encoding.BitReader) uint8 {
+	t := r.ReadBits(5)
+	if t == 31 {
+		t = 32 + r.ReadBits(6)
+	}
+	return uint8(t)
+}
+
+func readSampleRate(r *encoding.BitReader) int {
+	index := r.ReadBits(4)
+	if index == 0x0F {
+		return int(r.ReadBits(24))
+	}
+	if int(index) >= len(aacSampleRates) {
+		r.SetError(ASC_FMT_ERROR)
+		return 0
+	}
+	return aacSampleRates[index]
+}
+
+// 采样率的索引，不在表中时返回0x0F
+func sampleRateIndex(rate int) uint8 {
+	for i, r := range aacSampleRates {
+		if r == rate {
+			return uint8(i)
+		}
+	}
+	return 0x0F
+}
+
+// ParseAudioSpecificConfig 解析AudioSpecificConfig，
+// 支持分层(objectType为5或29)和向后兼容(syncExtensionType 0x2b7)的SBR/PS声明
+func ParseAudioSpecificConfig(b []byte) (asc AudioSpecificConfig, err error) {
+	r := encoding.NewBitReader(b)
+	asc.ObjectType = readObjectType(r)
+	asc.SampleRate = readSampleRate(r)
+	asc.ChannelConfig = uint8(r.ReadBits(4))
+	if asc.ObjectType == AAC_SBR || asc.ObjectType == AAC_PS {
+		asc.SBR = true
+		asc.PS = asc.ObjectType == AAC_PS
+		asc.ExtensionSampleRate = readSampleRate(r)
+		asc.ObjectType = readObjectType(r)
+	}
+	if r.Error() != nil || asc.ObjectType == 0 || asc.SampleRate == 0 {
+		return asc, ASC_FMT_ERROR
+	}
+	if int(asc.ChannelConfig) < len(aacChannels) {
+		asc.Channels = aacChannels[asc.ChannelConfig]
+	}
+
+	asc.FrameLength = 1024
+	switch asc.ObjectType {
+	case 1, 2, 3, 4, 6, 7, 17, 19, 20, 21, 22, 23:
+		// GASpecificConfig
+		if r.ReadFlag() { // frameLengthFlag
+			asc.FrameLength = 960
+		}
+		if r.ReadFlag() { // dependsOnCoreCoder
+			r.Skip(14)
+		}
+		extension := r.ReadFlag()
+		if asc.ChannelConfig == 0 {
+			// program_config_element，不再解析之后的扩展
+			return asc, nil
+		}
+		if asc.ObjectType == 6 || asc.ObjectType == 20 {
+			r.Skip(3) // layerNr
+		}
+		if extension {
+			if asc.ObjectType == 22 {
+				r.Skip(16)
+			}
+			switch asc.ObjectType {
+			case 17, 19, 20, 23:
+				r.Skip(3) // aacSectionDataResilienceFlag等
+			}
+			r.Skip(1) // extensionFlag3
+		}
+	default:
+		return asc, nil
+	}
+
+	// 向后兼容的SBR/PS声明
+	if !asc.SBR && r.Error() == nil && r.Remain() >= 16 && r.ReadBits(11) == 0x2B7 {
+		if readObjectType(r) == AAC_SBR && r.ReadFlag() {
+			asc.SBR = true
+			asc.ExtensionSampleRate = readSampleRate(r)
+			if r.Remain() >= 12 && r.ReadBits(11) == 0x548 {
+				asc.PS = r.ReadFlag()
+			}
+		}
+	}
+	if r.Error() != nil {
+		return asc, ASC_FMT_ERROR
+	}
+	return asc, nil
+}
+
+// OutputSampleRate 解码后的采样率，有SBR时为SBR的采样率
+func (asc AudioSpecificConfig) OutputSampleRate() int {
+	if asc.SBR && asc.ExtensionSampleRate > 0 {
+		return asc.ExtensionSampleRate
+	}
+	return asc.SampleRate
+}
+
+// FrameDuration 每帧的时长，使用核心编码的采样率，SBR不影响时长
+func (asc AudioSpecificConfig) FrameDuration() time.Duration {
+	if asc.SampleRate <= 0 {
+		return 0
+	}
+	return time.Duration(asc.FrameLength) * time.Second / time.Duration(asc.SampleRate)
+}
+
+// Bytes 编码为AudioSpecificConfig，有SBR/PS时使用分层的声明
+func (asc AudioSpecificConfig) Bytes() []byte {
+	var w encoding.BitWriter
+	writeObjectType := func(t uint8) {
+		if t >= 31 {
+			w.WriteBits(5, 31)
+			w.WriteBits(6, uint32(t-32))
+		} else {
+			w.WriteBits(5, uint32(t))
+		}
+	}
+	writeSampleRate := func(rate int) {
+		index := sampleRateIndex(rate)
+		w.WriteBits(4, uint32(index))
+		if index == 0x0F {
+			w.WriteBits(24, uint32(rate))
+		}
+	}
+
+	switch {
+	case asc.PS:
+		writeObjectType(AAC_PS)
+	case asc.SBR:
+		writeObjectType(AAC_SBR)
+	default:
+		writeObjectType(asc.ObjectType)
+	}
+	writeSampleRate(asc.SampleRate)
+	w.WriteBits(4, uint32(asc.ChannelConfig))
+	if asc.SBR || asc.PS {
+		rate := asc.ExtensionSampleRate
+		if rate == 0 {
+			rate = asc.SampleRate * 2
+		}
+		writeSampleRate(rate)
+		writeObjectType(asc.ObjectType)
+	}
+	// GASpecificConfig
+	w.WriteFlag(asc.FrameLength == 960)
+	w.WriteBits(2, 0) // dependsOnCoreCoder, extensionFlag
+	return w.Bytes()
+}
+
+// ADTS 在raw AAC帧之前加上7字节的ADTS头部(没有CRC)。
+// ADTS只能表示AAC Main/LC/SSR/LTP和标准采样率，HE-AAC使用核心的LC和采样率。
+func (asc AudioSpecificConfig) ADTS(frame []byte) ([]byte, error) {
+	index := sampleRateIndex(asc.SampleRate)
+	size := ADTS_HEADER_SIZE + len(frame)
+	if asc.ObjectType < AAC_MAIN || asc.ObjectType > AAC_LTP || index == 0x0F ||
+		asc.ChannelConfig > 7 || size >= 1<<13 {
+		return nil, ADTS_FMT_ERROR
+	}
+	b := make([]byte, ADTS_HEADER_SIZE, size)
+	b[0] = 0xFF
+	b[1] = 0xF1 // MPEG-4，没有CRC
+	b[2] = (asc.ObjectType-1)<<6 | index<<2 | asc.ChannelConfig>>2
+	b[3] = (asc.ChannelConfig&0x03)<<6 | byte(size>>11)
+	b[4] = byte(size >> 3)
+	b[5] = byte(size&0x07)<<5 | 0x1F // buffer fullness 0x7FF
+	b[6] = 0xFC
+	return append(b, frame...), nil
+}
+
+// ParseADTS 解析b开头的ADTS帧，返回对应的AudioSpecificConfig、
+// raw AAC数据和整个ADTS帧的长度。只支持每个ADTS帧一个raw data block。
+func ParseADTS(b []byte) (asc AudioSpecificConfig, frame []byte, length int, err error) {
+	if len(b) < ADTS_HEADER_SIZE || b[0] != 0xFF || b[1]&0xF6 != 0xF0 {
+		return asc, nil, 0, ADTS_FMT_ERROR
+	}
+	header := ADTS_HEADER_SIZE
+	if b[1]&0x01 == 0 { // 有CRC
+		header += 2
+	}
+	length = int(b[3]&0x03)<<11 | int(b[4])<<3 | int(b[5])>>5
+	index := b[2] >> 2 & 0x0F
+	if length < header || length > len(b) || int(index) >= len(aacSampleRates) {
+		return asc, nil, 0, ADTS_FMT_ERROR
+	}
+	asc.ObjectType = b[2]>>6 + 1
+	asc.SampleRate = aacSampleRates[index]
+	asc.ChannelConfig = (b[2]&0x01)<<2 | b[3]>>6
+	asc.Channels = aacChannels[asc.ChannelConfig]
+	asc.FrameLength = 1024
+	return asc, b[header:length], length, nil
+}
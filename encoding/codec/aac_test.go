@@ -0,0 +1,69 @@
+package codec
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/chenyj/rtmp/encoding"
+)
+
+func TestParseAudioSpecificConfig(t *testing.T) {
+	asc, err := ParseAudioSpecificConfig([]byte{0x12, 0x10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc := AudioSpecificConfig{ObjectType: AAC_LC, SampleRate: 44100, ChannelConfig: 2, Channels: 2, FrameLength: 1024}
+	if asc != lc || !bytes.Equal(asc.Bytes(), []byte{0x12, 0x10}) {
+		t.Fatalf("%+v % X", asc, asc.Bytes())
+	}
+	if d := asc.FrameDuration(); d.Round(time.Millisecond) != 23*time.Millisecond {
+		t.Fatalf("frame duration %v", d)
+	}
+
+	// 分层声明的HE-AACv2和不在表中的采样率
+	for _, want := range []AudioSpecificConfig{
+		{ObjectType: AAC_LC, SampleRate: 24000, ChannelConfig: 1, Channels: 1, FrameLength: 1024, SBR: true, PS: true, ExtensionSampleRate: 48000},
+		{ObjectType: AAC_LC, SampleRate: 12345, ChannelConfig: 6, Channels: 6, FrameLength: 960},
+	} {
+		asc, err := ParseAudioSpecificConfig(want.Bytes())
+		if err != nil || asc != want {
+			t.Fatalf("%+v %v, expect %+v", asc, err, want)
+		}
+	}
+
+	// 向后兼容的SBR声明
+	var w encoding.BitWriter
+	for _, f := range [][2]uint32{{5, AAC_LC}, {4, 6}, {4, 2}, {3, 0}, {11, 0x2B7}, {5, AAC_SBR}, {1, 1}, {4, 3}} {
+		w.WriteBits(int(f[0]), f[1])
+	}
+	asc, err = ParseAudioSpecificConfig(w.Bytes())
+	if err != nil || !asc.SBR || asc.PS || asc.SampleRate != 24000 || asc.OutputSampleRate() != 48000 {
+		t.Fatalf("%+v %v", asc, err)
+	}
+
+	if _, err = ParseAudioSpecificConfig([]byte{0x12}); err == nil {
+		t.Fatal("expect error on truncated config")
+	}
+}
+
+func TestADTS(t *testing.T) {
+	asc := AudioSpecificConfig{ObjectType: AAC_LC, SampleRate: 48000, ChannelConfig: 2, Channels: 2, FrameLength: 1024}
+	frame := bytes.Repeat([]byte{0x21}, 300)
+	b, err := asc.ADTS(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, raw, length, err := ParseADTS(append(b, 0xFF))
+	if err != nil || got != asc || !bytes.Equal(raw, frame) || length != len(b) {
+		t.Fatalf("%+v %d %v", got, length, err)
+	}
+
+	asc.SampleRate = 12345
+	if _, err = asc.ADTS(frame); err == nil {
+		t.Fatal("expect error on explicit sample rate")
+	}
+	if _, _, _, err = ParseADTS(b[:len(b)-1]); err == nil {
+		t.Fatal("expect error on truncated frame")
+	}
+}
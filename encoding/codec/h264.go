@@ -0,0 +1,198 @@
+package codec
+
+import (
+	"errors"
+
+	"github.com/chenyj/rtmp/encoding"
+)
+
+var (
+	SPS_FMT_ERROR    = errors.New("codec: invalid sps")
+	CONFIG_FMT_ERROR = errors.New("codec: invalid decoder configuration record")
+)
+
+// SPSInfo SPS中的视频参数
+type SPSInfo struct {
+	Profile      uint8   // profile_idc
+	Level        uint8   // level_idc，H.264为级别*10，H.265为级别*30
+	Width        int     // 裁剪后的宽度
+	Height       int     // 裁剪后的高度
+	FrameRate    float64 // VUI中的帧率，没有timing信息时为0
+	ChromaFormat uint8   // 0: 单色，1: 4:2:0，2: 4:2:2，3: 4:4:4
+	BitDepth     uint8   // 亮度位深
+}
+
+// UnescapeRBSP 去掉NAL单元中的防竞争字节，00 00 03中的03
+func UnescapeRBSP(bs []byte) []byte {
+	out := make([]byte, 0, len(bs))
+	zeros := 0
+	for _, b := range bs {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// ParseAVCDecoderConfig 解析AVCDecoderConfigurationRecord中的第一个SPS
+func ParseAVCDecoderConfig(record []byte) (SPSInfo, error) {
+	if len(record) < 8 || record[5]&0x1F == 0 {
+		return SPSInfo{}, CONFIG_FMT_ERROR
+	}
+	size := int(record[6])<<8 | int(record[7])
+	if 8+size > len(record) {
+		return SPSInfo{}, CONFIG_FMT_ERROR
+	}
+	return ParseH264SPS(record[8 : 8+size])
+}
+
+// 有chroma_format_idc等字段的profile
+func highProfile(profile uint8) bool {
+	switch profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		return true
+	}
+	return false
+}
+
+// ParseH264SPS 解析H.264的SPS，sps包含1字节的NAL头
+func ParseH264SPS(sps []byte) (info SPSInfo, err error) {
+	if len(sps) < 4 || sps[0]&0x1F != 7 {
+		return info, SPS_FMT_ERROR
+	}
+	r := encoding.NewBitReader(UnescapeRBSP(sps[1:]))
+	info.Profile = uint8(r.ReadBits(8))
+	r.Skip(8) // constraint_set flags
+	info.Level = uint8(r.ReadBits(8))
+	r.ReadUE() // seq_parameter_set_id
+
+	info.ChromaFormat, info.BitDepth = 1, 8
+	separatePlanes := false
+	if highProfile(info.Profile) {
+		info.ChromaFormat = uint8(r.ReadUE())
+		if info.ChromaFormat == 3 {
+			separatePlanes = r.ReadFlag()
+		}
+		info.BitDepth = uint8(r.ReadUE()) + 8
+		r.ReadUE() // bit_depth_chroma_minus8
+		r.Skip(1)  // qpprime_y_zero_transform_bypass_flag
+
+		// seq_scaling_matrix_present_flag
+		if r.ReadFlag() {
+			n := 8
+			if info.ChromaFormat == 3 {
+				n = 12
+			}
+			for i := 0; i < n; i++ {
+				if !r.ReadFlag() {
+					continue
+				}
+				if i < 6 {
+					skipScalingList(r, 16)
+				} else {
+					skipScalingList(r, 64)
+				}
+			}
+		}
+	}
+
+	r.ReadUE() // log2_max_frame_num_minus4
+	// pic_order_cnt_type
+	switch r.ReadUE() {
+	case 0:
+		r.ReadUE() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		r.Skip(1) // delta_pic_order_always_zero_flag
+		r.ReadSE()
+		r.ReadSE()
+		n := r.ReadUE()
+		for i := uint32(0); i < n && r.Error() == nil; i++ {
+			r.ReadSE()
+		}
+	}
+	r.ReadUE() // max_num_ref_frames
+	r.Skip(1)  // gaps_in_frame_num_value_allowed_flag
+	widthInMbs := int(r.ReadUE()) + 1
+	heightInMapUnits := int(r.ReadUE()) + 1
+	frameMbsOnly := r.ReadBit()
+	if frameMbsOnly == 0 {
+		r.Skip(1) // mb_adaptive_frame_field_flag
+	}
+	r.Skip(1) // direct_8x8_inference_flag
+
+	var cropLeft, cropRight, cropTop, cropBottom int
+	if r.ReadFlag() {
+		cropLeft, cropRight = int(r.ReadUE()), int(r.ReadUE())
+		cropTop, cropBottom = int(r.ReadUE()), int(r.ReadUE())
+	}
+	// 裁剪的单位
+	cropX, cropY := 1, 2-int(frameMbsOnly)
+	if !separatePlanes && info.ChromaFormat != 0 {
+		if info.ChromaFormat < 3 {
+			cropX = 2
+		}
+		if info.ChromaFormat == 1 {
+			cropY *= 2
+		}
+	}
+	info.Width = widthInMbs*16 - cropX*(cropLeft+cropRight)
+	info.Height = (2-int(frameMbsOnly))*heightInMapUnits*16 - cropY*(cropTop+cropBottom)
+
+	if r.Error() != nil || info.Width <= 0 || info.Height <= 0 {
+		return info, SPS_FMT_ERROR
+	}
+
+	// VUI不完整时忽略帧率
+	if r.ReadFlag() { // vui_parameters_present_flag
+		skipVUIHeader(r)
+		if r.ReadFlag() { // timing_info_present_flag
+			units, scale := r.ReadBits(32), r.ReadBits(32)
+			if r.Error() == nil && units > 0 {
+				// H.264的一帧是两个tick
+				info.FrameRate = float64(scale) / (2 * float64(units))
+			}
+		}
+	}
+	return info, nil
+}
+
+func skipScalingList(r *encoding.BitReader, size int) {
+	last, next := int32(8), int32(8)
+	for j := 0; j < size && r.Error() == nil; j++ {
+		if next != 0 {
+			next = (last + r.ReadSE() + 256) % 256
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+}
+
+// 跳过H.264和H.265的VUI中相同的开头部分
+func skipVUIHeader(r *encoding.BitReader) {
+	if r.ReadFlag() { // aspect_ratio_info_present_flag
+		if r.ReadBits(8) == 255 { // Extended_SAR
+			r.Skip(32)
+		}
+	}
+	if r.ReadFlag() { // overscan_info_present_flag
+		r.Skip(1)
+	}
+	if r.ReadFlag() { // video_signal_type_present_flag
+		r.Skip(4)
+		if r.ReadFlag() { // colour_description_present_flag
+			r.Skip(24)
+		}
+	}
+	if r.ReadFlag() { // chroma_loc_info_present_flag
+		r.ReadUE()
+		r.ReadUE()
+	}
+}
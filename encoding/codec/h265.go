@@ -0,0 +1,221 @@
+package codec
+
+import "github.com/chenyj/rtmp/encoding"
+
+// H.265 NAL单元类型
+const (
+	HEVC_NAL_VPS = 32
+	HEVC_NAL_SPS = 33
+	HEVC_NAL_PPS = 34
+)
+
+// ParseHEVCDecoderConfig 解析HEVCDecoderConfigurationRecord中的第一个SPS
+func ParseHEVCDecoderConfig(record []byte) (SPSInfo, error) {
+	if len(record) < 23 {
+		return SPSInfo{}, CONFIG_FMT_ERROR
+	}
+	numArrays := int(record[22])
+	b := record[23:]
+	for i := 0; i < numArrays; i++ {
+		if len(b) < 3 {
+			break
+		}
+		typ := b[0] & 0x3F
+		n := int(b[1])<<8 | int(b[2])
+		b = b[3:]
+		for j := 0; j < n; j++ {
+			if len(b) < 2 {
+				return SPSInfo{}, CONFIG_FMT_ERROR
+			}
+			size := int(b[0])<<8 | int(b[1])
+			if 2+size > len(b) {
+				return SPSInfo{}, CONFIG_FMT_ERROR
+			}
+			if typ == HEVC_NAL_SPS {
+				return ParseH265SPS(b[2 : 2+size])
+			}
+			b = b[2+size:]
+		}
+	}
+	return SPSInfo{}, CONFIG_FMT_ERROR
+}
+
+// ParseH265SPS 解析H.265的SPS，sps包含2字节的NAL头
+func ParseH265SPS(sps []byte) (info SPSInfo, err error) {
+	if len(sps) < 3 || sps[0]>>1&0x3F != HEVC_NAL_SPS {
+		return info, SPS_FMT_ERROR
+	}
+	r := encoding.NewBitReader(UnescapeRBSP(sps[2:]))
+	r.Skip(4) // sps_video_parameter_set_id
+	maxSubLayers := int(r.ReadBits(3))
+	r.Skip(1) // sps_temporal_id_nesting_flag
+
+	// profile_tier_level
+	r.Skip(3) // general_profile_space, general_tier_flag
+	info.Profile = uint8(r.ReadBits(5))
+	r.Skip(32 + 48) // compatibility和constraint flags
+	info.Level = uint8(r.ReadBits(8))
+	profilePresent := make([]bool, maxSubLayers)
+	levelPresent := make([]bool, maxSubLayers)
+	for i := 0; i < maxSubLayers; i++ {
+		profilePresent[i] = r.ReadFlag()
+		levelPresent[i] = r.ReadFlag()
+	}
+	if maxSubLayers > 0 {
+		r.Skip(2 * (8 - maxSubLayers))
+	}
+	for i := 0; i < maxSubLayers; i++ {
+		if profilePresent[i] {
+			r.Skip(88)
+		}
+		if levelPresent[i] {
+			r.Skip(8)
+		}
+	}
+
+	r.ReadUE() // sps_seq_parameter_set_id
+	info.ChromaFormat = uint8(r.ReadUE())
+	separatePlanes := false
+	if info.ChromaFormat == 3 {
+		separatePlanes = r.ReadFlag()
+	}
+	width, height := int(r.ReadUE()), int(r.ReadUE())
+	if r.ReadFlag() { // conformance_window_flag
+		left, right := int(r.ReadUE()), int(r.ReadUE())
+		top, bottom := int(r.ReadUE()), int(r.ReadUE())
+		cropX, cropY := 1, 1
+		if !separatePlanes && info.ChromaFormat != 0 {
+			if info.ChromaFormat < 3 {
+				cropX = 2
+			}
+			if info.ChromaFormat == 1 {
+				cropY = 2
+			}
+		}
+		width -= cropX * (left + right)
+		height -= cropY * (top + bottom)
+	}
+	info.Width, info.Height = width, height
+	info.BitDepth = uint8(r.ReadUE()) + 8
+	r.ReadUE() // bit_depth_chroma_minus8
+	if r.Error() != nil || info.Width <= 0 || info.Height <= 0 {
+		return info, SPS_FMT_ERROR
+	}
+
+	// 之后的字段只用于找到VUI中的帧率，解析失败时忽略
+	info.FrameRate = h265FrameRate(r, maxSubLayers)
+	return info, nil
+}
+
+// 从log2_max_pic_order_cnt_lsb_minus4开始解析到VUI的timing_info
+func h265FrameRate(r *encoding.BitReader, maxSubLayers int) float64 {
+	log2MaxPocLsb := int(r.ReadUE()) + 4
+	start := maxSubLayers
+	if r.ReadFlag() { // sps_sub_layer_ordering_info_present_flag
+		start = 0
+	}
+	for i := start; i <= maxSubLayers; i++ {
+		r.ReadUE()
+		r.ReadUE()
+		r.ReadUE()
+	}
+	for i := 0; i < 6; i++ { // coding block和transform block的大小
+		r.ReadUE()
+	}
+	if r.ReadFlag() && r.ReadFlag() { // scaling_list_enabled_flag, sps_scaling_list_data_present_flag
+		skipH265ScalingList(r)
+	}
+	// amp_enabled_flag, sample_adaptive_offset_enabled_flag
+	r.Skip(2)
+
+	if r.ReadFlag() { // pcm_enabled_flag
+		r.Skip(8)
+		r.ReadUE()
+		r.ReadUE()
+		r.Skip(1)
+	}
+	numSets := int(r.ReadUE())
+	if numSets > 64 {
+		return 0
+	}
+	numDeltaPocs := make([]int, numSets)
+	for i := 0; i < numSets && r.Error() == nil; i++ {
+		numDeltaPocs[i] = skipShortTermRefPicSet(r, i, numDeltaPocs)
+	}
+	if r.ReadFlag() { // long_term_ref_pics_present_flag
+		n := int(r.ReadUE())
+		for i := 0; i < n && r.Error() == nil; i++ {
+			r.Skip(log2MaxPocLsb + 1)
+		}
+	}
+	r.Skip(2)          // sps_temporal_mvp_enabled_flag, strong_intra_smoothing_enabled_flag
+	if !r.ReadFlag() { // vui_parameters_present_flag
+		return 0
+	}
+	skipVUIHeader(r)
+	r.Skip(3)         // neutral_chroma_indication_flag, field_seq_flag, frame_field_info_present_flag
+	if r.ReadFlag() { // default_display_window_flag
+		for i := 0; i < 4; i++ {
+			r.ReadUE()
+		}
+	}
+	if !r.ReadFlag() { // vui_timing_info_present_flag
+		return 0
+	}
+	units, scale := r.ReadBits(32), r.ReadBits(32)
+	if r.Error() != nil || units == 0 {
+		return 0
+	}
+	return float64(scale) / float64(units)
+}
+
+func skipH265ScalingList(r *encoding.BitReader) {
+	for sizeID := 0; sizeID < 4; sizeID++ {
+		step := 1
+		if sizeID == 3 {
+			step = 3
+		}
+		for matrixID := 0; matrixID < 6; matrixID += step {
+			if !r.ReadFlag() { // scaling_list_pred_mode_flag
+				r.ReadUE()
+				continue
+			}
+			n := 1 << (4 + uint(sizeID)<<1)
+			if n > 64 {
+				n = 64
+			}
+			if sizeID > 1 {
+				r.ReadSE()
+			}
+			for i := 0; i < n; i++ {
+				r.ReadSE()
+			}
+		}
+	}
+}
+
+// 跳过SPS中的第idx个st_ref_pic_set，返回其NumDeltaPocs
+func skipShortTermRefPicSet(r *encoding.BitReader, idx int, numDeltaPocs []int) int {
+	if idx > 0 && r.ReadFlag() { // inter_ref_pic_set_prediction_flag
+		r.Skip(1)  // delta_rps_sign
+		r.ReadUE() // abs_delta_rps_minus1
+		n := 0
+		for j := 0; j <= numDeltaPocs[idx-1]; j++ {
+			// used_by_curr_pic_flag，为0时读取use_delta_flag
+			if r.ReadFlag() || r.ReadFlag() {
+				n++
+			}
+		}
+		return n
+	}
+	negative, positive := int(r.ReadUE()), int(r.ReadUE())
+	if negative > 16 || positive > 16 {
+		r.SetError(SPS_FMT_ERROR)
+		return 0
+	}
+	for i := 0; i < negative+positive; i++ {
+		r.ReadUE()
+		r.Skip(1)
+	}
+	return negative + positive
+}
@@ -0,0 +1,230 @@
+package codec
+
+import (
+	"math"
+	"testing"
+)
+
+type bitWriter struct {
+	b []byte
+	n int // 已写入的位数
+}
+
+func (w *bitWriter) u(bits int, v uint32) {
+	for i := bits - 1; i >= 0; i-- {
+		if w.n%8 == 0 {
+			w.b = append(w.b, 0)
+		}
+		w.b[len(w.b)-1] |= byte(v>>uint(i)&1) << (7 - uint(w.n%8))
+		w.n++
+	}
+}
+
+func (w *bitWriter) ue(v uint32) {
+	bits := 0
+	for (v+1)>>uint(bits) > 1 {
+		bits++
+	}
+	w.u(bits, 0)
+	w.u(bits+1, v+1)
+}
+
+func (w *bitWriter) se(v int32) {
+	if v > 0 {
+		w.ue(uint32(2*v - 1))
+	} else {
+		w.ue(uint32(-2 * v))
+	}
+}
+
+// 加上rbsp_trailing_bits和防竞争字节
+func (w *bitWriter) nalu(header ...byte) []byte {
+	w.u(1, 1)
+	for w.n%8 != 0 {
+		w.u(1, 0)
+	}
+	out := header
+	zeros := 0
+	for _, b := range w.b {
+		if zeros >= 2 && b <= 3 {
+			out = append(out, 3)
+			zeros = 0
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// 1920x1080 High@4.0，25fps
+func testH264SPS() []byte {
+	w := &bitWriter{}
+	w.u(8, 100)
+	w.u(8, 0)
+	w.u(8, 40)
+	w.ue(0)
+	w.ue(1) // chroma_format_idc
+	w.ue(0)
+	w.ue(0)
+	w.u(1, 0)
+	w.u(1, 1) // seq_scaling_matrix_present_flag
+	w.u(1, 1)
+	for i := 0; i < 16; i++ {
+		w.se(0)
+	}
+	w.u(7, 0)
+	w.ue(0)
+	w.ue(0) // pic_order_cnt_type
+	w.ue(2)
+	w.ue(4)
+	w.u(1, 0)
+	w.ue(119)
+	w.ue(67)
+	w.u(1, 1) // frame_mbs_only_flag
+	w.u(1, 1)
+	w.u(1, 1) // frame_cropping_flag
+	w.ue(0)
+	w.ue(0)
+	w.ue(0)
+	w.ue(4)
+	w.u(1, 1) // vui_parameters_present_flag
+	w.u(1, 1)
+	w.u(8, 1)
+	w.u(1, 0)
+	w.u(1, 1)
+	w.u(4, 5)
+	w.u(1, 1)
+	w.u(24, 0x010101)
+	w.u(1, 0)
+	w.u(1, 1) // timing_info_present_flag
+	w.u(32, 1)
+	w.u(32, 50)
+	w.u(1, 1)
+	return w.nalu(0x67)
+}
+
+// 3840x2160 Main 10@5.1，59.94fps
+func testH265SPS() []byte {
+	w := &bitWriter{}
+	w.u(4, 0)
+	w.u(3, 0)
+	w.u(1, 1)
+	w.u(3, 0)
+	w.u(5, 2) // general_profile_idc
+	w.u(32, 0x20000000)
+	w.u(16, 0x9000)
+	w.u(32, 0)
+	w.u(8, 153) // general_level_idc
+	w.ue(0)
+	w.ue(1)
+	w.ue(3840)
+	w.ue(2176)
+	w.u(1, 1) // conformance_window_flag
+	w.ue(0)
+	w.ue(0)
+	w.ue(0)
+	w.ue(8)
+	w.ue(2) // bit_depth_luma_minus8
+	w.ue(2)
+	w.ue(4)
+	w.u(1, 1)
+	w.ue(4)
+	w.ue(0)
+	w.ue(0)
+	for i := 0; i < 6; i++ {
+		w.ue(1)
+	}
+	w.u(1, 1) // scaling_list_enabled_flag
+	w.u(1, 1)
+	for sizeID := 0; sizeID < 4; sizeID++ {
+		step := 1
+		if sizeID == 3 {
+			step = 3
+		}
+		for matrixID := 0; matrixID < 6; matrixID += step {
+			w.u(1, 1)
+			if sizeID > 1 {
+				w.se(8)
+			}
+			n := 16
+			if sizeID > 0 {
+				n = 64
+			}
+			for i := 0; i < n; i++ {
+				w.se(0)
+			}
+		}
+	}
+	w.u(2, 3)
+	w.u(1, 0)
+	w.ue(2) // num_short_term_ref_pic_sets
+	w.ue(1)
+	w.ue(0)
+	w.ue(0)
+	w.u(1, 1)
+	w.u(1, 1) // inter_ref_pic_set_prediction_flag
+	w.u(1, 0)
+	w.ue(0)
+	w.u(1, 1)
+	w.u(1, 0)
+	w.u(1, 1)
+	w.u(1, 1) // long_term_ref_pics_present_flag
+	w.ue(1)
+	w.u(8, 0)
+	w.u(1, 1)
+	w.u(2, 3)
+	w.u(1, 1) // vui_parameters_present_flag
+	w.u(4, 0)
+	w.u(3, 0)
+	w.u(1, 1)
+	w.ue(0)
+	w.ue(0)
+	w.ue(0)
+	w.ue(0)
+	w.u(1, 1) // vui_timing_info_present_flag
+	w.u(32, 1001)
+	w.u(32, 60000)
+	return w.nalu(0x42, 0x01)
+}
+
+func TestParseH264SPS(t *testing.T) {
+	sps := testH264SPS()
+	record := append([]byte{1, 100, 0, 40, 0xFF, 0xE1, 0, byte(len(sps))}, sps...)
+	record = append(record, 1, 0, 1, 0x68)
+	info, err := ParseAVCDecoderConfig(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := SPSInfo{Profile: 100, Level: 40, Width: 1920, Height: 1080, FrameRate: 25, ChromaFormat: 1, BitDepth: 8}
+	if info != want {
+		t.Fatalf("%+v, expect %+v", info, want)
+	}
+
+	// 截断的VUI只影响帧率
+	info, err = ParseH264SPS(sps[:len(sps)-6])
+	if err != nil || info.Width != 1920 || info.FrameRate != 0 {
+		t.Fatalf("truncated vui: %+v %v", info, err)
+	}
+	if _, err = ParseH264SPS(sps[:8]); err == nil {
+		t.Fatal("expect error on truncated sps")
+	}
+}
+
+func TestParseH265SPS(t *testing.T) {
+	sps := testH265SPS()
+	record := make([]byte, 22)
+	record = append(record, 1, 0x80|HEVC_NAL_SPS, 0, 1, 0, byte(len(sps)))
+	record = append(record, sps...)
+	info, err := ParseHEVCDecoderConfig(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Profile != 2 || info.Level != 153 || info.Width != 3840 || info.Height != 2160 ||
+		info.ChromaFormat != 1 || info.BitDepth != 10 || math.Abs(info.FrameRate-59.94) > 0.01 {
+		t.Fatalf("%+v", info)
+	}
+}
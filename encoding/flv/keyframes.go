@@ -0,0 +1,209 @@
+package flv
+
+import (
+	"io"
+	"os"
+
+	"github.com/chenyj/rtmp/encoding/amf0"
+	"github.com/chenyj/rtmp/encoding/av"
+)
+
+// 第一遍扫描的结果
+type flvIndex struct {
+	header    FlvHeader
+	meta      *amf0.Object // 原有的onMetaData
+	metaPos   int64        // onMetaData的Tag在原文件中的偏移，-1表示没有
+	metaSize  uint32       // 原有onMetaData的数据大小
+	times     []any        // 关键帧时间，秒
+	positions []int64      // 关键帧Tag在原文件中的偏移
+	duration  uint32       // 毫秒
+}
+
+// InjectKeyframes 扫描src中的视频关键帧，把keyframes(filepositions/times)和duration
+// 写入onMetaData后复制到dst，没有onMetaData时在第一个Tag之前插入。
+// src读取两遍，每次只读取一个Tag，不会整个读入内存。
+func InjectKeyframes(dst io.Writer, src io.ReadSeeker) error {
+	idx, err := scanIndex(src)
+	if err != nil {
+		return err
+	}
+
+	// 数字都编码为9字节，所以onMetaData的大小只和关键帧数量有关，
+	// 先用原偏移编码一次得到大小，再用修正后的偏移编码
+	data, err := idx.encodeMeta(0, 0)
+	if err != nil {
+		return err
+	}
+	shift := int64(len(data)) - int64(idx.metaSize)
+	if idx.metaPos < 0 {
+		shift = 15 + int64(len(data))
+	}
+	// 扩展的头部不会复制，所有的偏移都要减去
+	head := 9 - int64(idx.header.Size)
+	if data, err = idx.encodeMeta(head, shift); err != nil {
+		return err
+	}
+
+	if _, err = src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r := NewTagReader(src)
+	w := NewTagWriter(dst)
+	if _, err = r.ReadFlvHeader(); err != nil {
+		return err
+	}
+	if err = w.WriteFlvHeader(idx.header.HasAudio, idx.header.HasVideo); err != nil {
+		return err
+	}
+	if idx.metaPos < 0 {
+		if err = w.WriteTag(FLV_TAG_DATA, 0, data); err != nil {
+			return err
+		}
+	}
+	for {
+		pos := r.Offset() + 4
+		h, tag, err := r.ReadTag()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if pos == idx.metaPos {
+			tag = data
+		}
+		if err = w.WriteTag(h.TagType, h.Timestamp, tag); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+func scanIndex(src io.ReadSeeker) (*flvIndex, error) {
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	r := NewTagReader(src)
+	h, err := r.ReadFlvHeader()
+	if err != nil {
+		return nil, err
+	}
+	idx := &flvIndex{header: h, metaPos: -1}
+	for {
+		pos := r.Offset() + 4
+		th, data, err := r.ReadTag()
+		if err == io.EOF {
+			return idx, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch th.TagType {
+		case FLV_TAG_DATA:
+			if idx.metaPos >= 0 {
+				break
+			}
+			if meta, ok := decodeMeta(data); ok {
+				idx.meta, idx.metaPos, idx.metaSize = meta, pos, th.DataSize
+			}
+		case FLV_TAG_VIDEO:
+			if p := av.VideoPack(th.Timestamp, data); p.IsKeyFrame && !p.IsConfig {
+				idx.times = append(idx.times, float64(th.Timestamp)/1000)
+				idx.positions = append(idx.positions, pos)
+			}
+		}
+		if th.Timestamp > idx.duration {
+			idx.duration = th.Timestamp
+		}
+	}
+}
+
+// 解码onMetaData，返回其中的属性，保持原有的顺序
+func decodeMeta(data []byte) (*amf0.Object, bool) {
+	ar, err := amf0.DecodeOrdered(data)
+	if err != nil || len(ar) < 2 {
+		return nil, false
+	}
+	if name, _ := ar.GetString(0); name != "onMetaData" {
+		return nil, false
+	}
+	meta, ok := ar.Get(1).(*amf0.Object)
+	if !ok {
+		meta = amf0.NewECMAArray()
+	}
+	return meta, true
+}
+
+// 编码带关键帧索引的onMetaData，所有Tag的偏移加上head，
+// 位于onMetaData之后的再加上shift
+func (idx *flvIndex) encodeMeta(head, shift int64) ([]byte, error) {
+	meta := amf0.NewECMAArray()
+	if idx.meta != nil {
+		meta.Props = append(meta.Props, idx.meta.Props...)
+		meta.ECMA, meta.Count = idx.meta.ECMA, idx.meta.Count
+	}
+	positions := make([]any, len(idx.positions))
+	for i, pos := range idx.positions {
+		if pos > idx.metaPos {
+			pos += shift
+		}
+		positions[i] = float64(pos + head)
+	}
+	meta.Set("duration", float64(idx.duration)/1000)
+	meta.Set("hasKeyframes", len(positions) > 0)
+	meta.Set("keyframes", &amf0.Object{Props: []amf0.Property{
+		{Key: "filepositions", Value: positions},
+		{Key: "times", Value: idx.times},
+	}})
+	return amf0.Encode("onMetaData", meta)
+}
+
+// FileWriter 写入FLV文件。
+// 开启关键帧索引时先写入临时文件，Close时注入索引后写入目标文件。
+type FileWriter struct {
+	*TagWriter
+	f     *os.File
+	name  string
+	index bool
+}
+
+// Create 创建FLV文件，indexKeyframes为true时关闭时在onMetaData中写入关键帧索引
+func Create(name string, indexKeyframes bool) (*FileWriter, error) {
+	path := name
+	if indexKeyframes {
+		path = name + ".tmp"
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &FileWriter{TagWriter: NewTagWriter(f), f: f, name: name, index: indexKeyframes}, nil
+}
+
+// Close 写入最后一个Tag的大小并关闭文件
+func (w *FileWriter) Close() error {
+	err := w.TagWriter.Close()
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || !w.index {
+		return err
+	}
+
+	tmp := w.f.Name()
+	defer os.Remove(tmp)
+	src, err := os.Open(tmp)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(w.name)
+	if err != nil {
+		return err
+	}
+	if err = InjectKeyframes(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
@@ -0,0 +1,147 @@
+package flv
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chenyj/rtmp/encoding/amf0"
+)
+
+// 配置帧和10帧视频，每帧40毫秒，第0和第5帧是关键帧。
+// onMetaData写在第meta个视频Tag之前，-1表示没有onMetaData
+func writeFrames(t *testing.T, w *TagWriter, meta int) {
+	w.WriteFlvHeader(false, true)
+	for i := -1; i < 10; i++ {
+		if i+1 == meta {
+			data, err := amf0.Encode("onMetaData", map[string]any{"width": 640.0, "duration": 0.0})
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.WriteTag(FLV_TAG_DATA, 0, data)
+		}
+		if i < 0 {
+			w.WriteTag(FLV_TAG_VIDEO, 0, []byte{0x17, 0, 0, 0, 0})
+			continue
+		}
+		frameType := byte(0x27)
+		if i%5 == 0 {
+			frameType = 0x17
+		}
+		w.WriteTag(FLV_TAG_VIDEO, uint32(i*40), []byte{frameType, 1, 0, 0, 0, byte(i)})
+	}
+}
+
+// 检查onMetaData中的关键帧索引指向正确的Tag
+func checkKeyframes(t *testing.T, file []byte) amf0.Amfkv {
+	r := NewTagReader(bytes.NewReader(file))
+	if _, err := r.ReadFlvHeader(); err != nil {
+		t.Fatal(err)
+	}
+	var data []byte
+	for {
+		h, tag, err := r.ReadTag()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.TagType == FLV_TAG_DATA {
+			data = tag
+			break
+		}
+	}
+	ar, err := amf0.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, _ := ar.GetKV(1)
+	if d, _ := meta.GetFloat64("duration"); d != 0.36 {
+		t.Fatalf("duration %v, expect 0.36", d)
+	}
+	kf, _ := meta.Get("keyframes").(amf0.Amfkv)
+	positions, _ := kf.Get("filepositions").(amf0.Amfarr)
+	times, _ := kf.Get("times").(amf0.Amfarr)
+	if len(positions) != 2 || len(times) != 2 || times[1] != 0.2 {
+		t.Fatalf("keyframes: %v", kf)
+	}
+	for i, pos := range positions {
+		p := int64(pos.(float64))
+		r := NewTagReader(bytes.NewReader(file[p-4:]))
+		h, data, err := r.ReadTag()
+		if err != nil || h.TagType != FLV_TAG_VIDEO || data[0] != 0x17 || data[5] != byte(i*5) {
+			t.Fatalf("keyframe %d at %d: %+v % X %v", i, p, h, data, err)
+		}
+	}
+	return meta
+}
+
+func TestInjectKeyframes(t *testing.T) {
+	for _, withMeta := range []bool{true, false} {
+		var src, dst bytes.Buffer
+		w := NewTagWriter(&src)
+		at := -1
+		if withMeta {
+			at = 0
+		}
+		writeFrames(t, w, at)
+		w.Close()
+		if err := InjectKeyframes(&dst, bytes.NewReader(src.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+		meta := checkKeyframes(t, dst.Bytes())
+		if width, _ := meta.GetFloat64("width"); withMeta && width != 640 {
+			t.Fatal("lost original metadata")
+		}
+
+		// 所有Tag都复制了
+		r := NewTagReader(bytes.NewReader(dst.Bytes()))
+		r.ReadFlvHeader()
+		n := 0
+		for {
+			if _, _, err := r.ReadTag(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			n++
+		}
+		if n != 12 {
+			t.Fatalf("%d tags, expect 12", n)
+		}
+	}
+}
+
+func TestInjectKeyframesExtendedHeader(t *testing.T) {
+	// 13字节的头部，第一个关键帧在onMetaData之前
+	var src, dst bytes.Buffer
+	w := NewTagWriter(&src)
+	writeFrames(t, w, 2)
+	w.Close()
+	file := append(append(append([]byte{}, src.Bytes()[:9]...), 0, 0, 0, 0), src.Bytes()[9:]...)
+	file[8] = 13
+	if err := InjectKeyframes(&dst, bytes.NewReader(file)); err != nil {
+		t.Fatal(err)
+	}
+	checkKeyframes(t, dst.Bytes())
+}
+
+func TestFileWriterIndex(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "record.flv")
+	w, err := Create(name, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFrames(t, w.TagWriter, 0)
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkKeyframes(t, file)
+	if _, err = os.Stat(name + ".tmp"); !os.IsNotExist(err) {
+		t.Fatal("temporary file left")
+	}
+}
@@ -0,0 +1,90 @@
+package flv
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// TagReader 从io.Reader中逐个读取FLV Tag，不需要把整个文件读入内存
+type TagReader struct {
+	r      io.Reader
+	offset int64 // 下一个Tag(包含前一个Tag大小)的偏移
+	buf    [15]byte
+}
+
+func NewTagReader(r io.Reader) *TagReader {
+	return &TagReader{r: r}
+}
+
+// 读取flv头部
+func (t *TagReader) ReadFlvHeader() (h FlvHeader, err error) {
+	bs := t.buf[:9]
+	if _, err = io.ReadFull(t.r, bs); err != nil {
+		return
+	}
+	copy(h.flv[:], bs[:3])
+	if h.flv != _FLV_ {
+		err = FLV_FMT_ERROR
+		return
+	}
+	h.Version = bs[3]
+	h.HasAudio = bs[4]&0x04 == 0x04
+	h.HasVideo = bs[4]&0x01 == 0x01
+	h.Size = binary.BigEndian.Uint32(bs[5:9])
+	if h.Size < 9 {
+		err = FLV_FMT_ERROR
+		return
+	}
+	t.offset = int64(h.Size)
+	// 跳过扩展的头部
+	if h.Size > 9 {
+		_, err = io.CopyN(io.Discard, t.r, int64(h.Size-9))
+	}
+	return
+}
+
+// 读取一个flv tag，文件结束时返回io.EOF
+func (t *TagReader) ReadTag() (h FlvTagHeader, data []byte, err error) {
+	bs := t.buf[:15]
+	var n int
+	if n, err = io.ReadFull(t.r, bs); err != nil {
+		// 文件末尾只有最后一个Tag的大小
+		if err == io.ErrUnexpectedEOF && n == 4 {
+			err = io.EOF
+		}
+		return
+	}
+	h.PreTagSzie = binary.BigEndian.Uint32(bs[0:4])
+	h.TagType = bs[4]
+	h.DataSize = uint32(bs[5])<<16 | uint32(bs[6])<<8 | uint32(bs[7])
+	h.Timestamp = uint32(bs[8])<<16 | uint32(bs[9])<<8 | uint32(bs[10]) | uint32(bs[11])<<24
+	h.StreamID = uint32(bs[12])<<16 | uint32(bs[13])<<8 | uint32(bs[14])
+	data = make([]byte, h.DataSize)
+	if _, err = io.ReadFull(t.r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+	t.offset += 15 + int64(h.DataSize)
+	return
+}
+
+// Offset 返回下一个Tag的偏移，可以用于Seek
+func (t *TagReader) Offset() int64 {
+	return t.offset
+}
+
+// SeekTag 移动到offset处的Tag，底层Reader必须实现io.Seeker
+func (t *TagReader) SeekTag(offset int64) error {
+	s, ok := t.r.(io.Seeker)
+	if !ok {
+		return errors.New("flv: reader is not seekable")
+	}
+	if _, err := s.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	t.offset = offset
+	return nil
+}
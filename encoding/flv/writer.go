@@ -0,0 +1,78 @@
+package flv
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// TagWriter 向io.Writer中逐个写入FLV Tag，与TagReader对应
+type TagWriter struct {
+	w       io.Writer
+	offset  int64  // 下一个Tag(包含前一个Tag大小)的偏移
+	prevTag uint32 // 前一个Tag的大小
+	buf     [15]byte
+}
+
+func NewTagWriter(w io.Writer) *TagWriter {
+	return &TagWriter{w: w}
+}
+
+// ResumeTagWriter 在已有的FLV之后继续写入，w的位置应在offset处。
+// offset是TagReader读完最后一个Tag后的Offset，prevTag是最后一个Tag的大小
+func ResumeTagWriter(w io.Writer, offset int64, prevTag uint32) *TagWriter {
+	return &TagWriter{w: w, offset: offset, prevTag: prevTag}
+}
+
+// 写入flv头部
+func (t *TagWriter) WriteFlvHeader(hasAudio, hasVideo bool) error {
+	bs := t.buf[:9]
+	copy(bs, _FLV_[:])
+	bs[3] = 1
+	bs[4] = 0
+	if hasAudio {
+		bs[4] |= 0x04
+	}
+	if hasVideo {
+		bs[4] |= 0x01
+	}
+	binary.BigEndian.PutUint32(bs[5:9], 9)
+	if _, err := t.w.Write(bs); err != nil {
+		return err
+	}
+	t.offset = 9
+	return nil
+}
+
+// 写入一个flv tag，包括前一个Tag的大小
+func (t *TagWriter) WriteTag(tagType uint8, timestamp uint32, data []byte) error {
+	bs := t.buf[:15]
+	size := len(data)
+	binary.BigEndian.PutUint32(bs[0:4], t.prevTag)
+	bs[4] = tagType
+	bs[5], bs[6], bs[7] = byte(size>>16), byte(size>>8), byte(size)
+	bs[8], bs[9], bs[10] = byte(timestamp>>16), byte(timestamp>>8), byte(timestamp)
+	bs[11] = byte(timestamp >> 24)
+	bs[12], bs[13], bs[14] = 0, 0, 0
+	if _, err := t.w.Write(bs); err != nil {
+		return err
+	}
+	if _, err := t.w.Write(data); err != nil {
+		return err
+	}
+	t.prevTag = uint32(11 + size)
+	t.offset += 15 + int64(size)
+	return nil
+}
+
+// Close 写入最后一个Tag的大小，不关闭底层的Writer
+func (t *TagWriter) Close() error {
+	bs := t.buf[:4]
+	binary.BigEndian.PutUint32(bs, t.prevTag)
+	_, err := t.w.Write(bs)
+	return err
+}
+
+// Offset 返回下一个Tag的偏移
+func (t *TagWriter) Offset() int64 {
+	return t.offset
+}
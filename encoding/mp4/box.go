@@ -0,0 +1,345 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	MP4_FMT_ERROR   = errors.New("mp4 format error")
+	MP4_UNSUPPORTED = errors.New("mp4: unsupported file")
+)
+
+// 依次处理b中的box，fn的参数是box类型和去掉头部的内容
+func boxes(b []byte, fn func(typ string, payload []byte) error) error {
+	for len(b) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(b))
+		typ := string(b[4:8])
+		hdr := uint64(8)
+		switch size {
+		case 0: // 到结尾
+			size = uint64(len(b))
+		case 1: // 64位大小
+			if len(b) < 16 {
+				return MP4_FMT_ERROR
+			}
+			size = binary.BigEndian.Uint64(b[8:16])
+			hdr = 16
+		}
+		if size < hdr || size > uint64(len(b)) {
+			return MP4_FMT_ERROR
+		}
+		if err := fn(typ, b[hdr:size]); err != nil {
+			return err
+		}
+		b = b[size:]
+	}
+	return nil
+}
+
+// 按顺序读取box内容，越界后所有读取返回0，由err报告
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil || n < 0 || n > len(r.b) {
+		r.err = MP4_FMT_ERROR
+		return nil
+	}
+	bs := r.b[:n]
+	r.b = r.b[n:]
+	return bs
+}
+
+func (r *reader) u8() uint8 {
+	if bs := r.next(1); bs != nil {
+		return bs[0]
+	}
+	return 0
+}
+
+func (r *reader) u16() uint16 {
+	if bs := r.next(2); bs != nil {
+		return binary.BigEndian.Uint16(bs)
+	}
+	return 0
+}
+
+func (r *reader) u32() uint32 {
+	if bs := r.next(4); bs != nil {
+		return binary.BigEndian.Uint32(bs)
+	}
+	return 0
+}
+
+func (r *reader) u64() uint64 {
+	if bs := r.next(8); bs != nil {
+		return binary.BigEndian.Uint64(bs)
+	}
+	return 0
+}
+
+func (r *reader) skip(n int) {
+	r.next(n)
+}
+
+// full box的版本，跳过flags
+func (r *reader) version() uint8 {
+	v := r.u8()
+	r.skip(3)
+	return v
+}
+
+// 读取entry数量，数量超过剩余数据能容纳的上限时报错，避免分配过多内存
+func (r *reader) count(entrySize int) int {
+	n := r.u32()
+	if r.err == nil && uint64(n)*uint64(entrySize) > uint64(len(r.b)) {
+		r.err = MP4_FMT_ERROR
+		return 0
+	}
+	return int(n)
+}
+
+type sttsEntry struct {
+	count uint32
+	delta uint32
+}
+
+type cttsEntry struct {
+	count  uint32
+	offset int32
+}
+
+type stscEntry struct {
+	firstChunk uint32
+	perChunk   uint32
+}
+
+// 一个音频或视频轨道
+type track struct {
+	id         uint32
+	handler    string // vide, soun
+	timescale  uint32
+	duration   uint64
+	codec      string // avc1, mp4a ...
+	width      uint16
+	height     uint16
+	channels   uint16
+	sampleRate uint32
+	objectType uint8  // esds中的objectTypeIndication
+	config     []byte // AVCDecoderConfigurationRecord或AudioSpecificConfig
+
+	stts        []sttsEntry
+	ctts        []cttsEntry
+	stss        []uint32 // 从1开始的同步帧编号，nil表示都是同步帧
+	sampleSize  uint32   // 所有sample大小相同时不为0
+	sampleCount int
+	sizes       []uint32
+	stsc        []stscEntry
+	chunks      []uint64
+}
+
+func parseTrak(b []byte) (*track, error) {
+	t := &track{}
+	err := boxes(b, func(typ string, p []byte) error {
+		switch typ {
+		case "tkhd":
+			r := &reader{b: p}
+			if r.version() == 1 {
+				r.skip(16)
+			} else {
+				r.skip(8)
+			}
+			t.id = r.u32()
+			return r.err
+		case "mdia":
+			return t.parseContainer(p)
+		}
+		return nil
+	})
+	return t, err
+}
+
+// 递归处理mdia/minf/stbl中的box
+func (t *track) parseContainer(b []byte) error {
+	return boxes(b, func(typ string, p []byte) error {
+		r := &reader{b: p}
+		switch typ {
+		case "mdia", "minf", "stbl":
+			return t.parseContainer(p)
+		case "mdhd":
+			if r.version() == 1 {
+				r.skip(16)
+				t.timescale = r.u32()
+				t.duration = r.u64()
+			} else {
+				r.skip(8)
+				t.timescale = r.u32()
+				t.duration = uint64(r.u32())
+			}
+		case "hdlr":
+			r.version()
+			r.skip(4)
+			t.handler = string(r.next(4))
+		case "stsd":
+			r.version()
+			r.skip(4)
+			return t.parseSampleEntry(r.b)
+		case "stts":
+			r.version()
+			n := r.count(8)
+			t.stts = make([]sttsEntry, n)
+			for i := range t.stts {
+				t.stts[i] = sttsEntry{r.u32(), r.u32()}
+			}
+		case "ctts":
+			// version 0的offset是无符号数，实际文件中也按有符号数处理
+			r.version()
+			n := r.count(8)
+			t.ctts = make([]cttsEntry, n)
+			for i := range t.ctts {
+				t.ctts[i] = cttsEntry{r.u32(), int32(r.u32())}
+			}
+		case "stss":
+			r.version()
+			n := r.count(4)
+			t.stss = make([]uint32, n)
+			for i := range t.stss {
+				t.stss[i] = r.u32()
+			}
+		case "stsz":
+			r.version()
+			t.sampleSize = r.u32()
+			if t.sampleSize != 0 {
+				// 大小相同时只有数量
+				t.sampleCount = int(r.u32())
+				break
+			}
+			t.sampleCount = r.count(4)
+			t.sizes = make([]uint32, t.sampleCount)
+			for i := range t.sizes {
+				t.sizes[i] = r.u32()
+			}
+		case "stsc":
+			r.version()
+			n := r.count(12)
+			t.stsc = make([]stscEntry, n)
+			for i := range t.stsc {
+				t.stsc[i] = stscEntry{r.u32(), r.u32()}
+				r.skip(4) // sample description index
+			}
+		case "stco":
+			r.version()
+			n := r.count(4)
+			t.chunks = make([]uint64, n)
+			for i := range t.chunks {
+				t.chunks[i] = uint64(r.u32())
+			}
+		case "co64":
+			r.version()
+			n := r.count(8)
+			t.chunks = make([]uint64, n)
+			for i := range t.chunks {
+				t.chunks[i] = r.u64()
+			}
+		}
+		return r.err
+	})
+}
+
+// 只处理第一个sample entry
+func (t *track) parseSampleEntry(b []byte) error {
+	return boxes(b, func(typ string, p []byte) error {
+		if t.codec != "" {
+			return nil
+		}
+		t.codec = typ
+		r := &reader{b: p}
+		r.skip(8) // reserved, data reference index
+		switch typ {
+		case "avc1", "avc3":
+			r.skip(16)
+			t.width = r.u16()
+			t.height = r.u16()
+			r.skip(50)
+			if r.err != nil {
+				return r.err
+			}
+			return boxes(r.b, func(typ string, p []byte) error {
+				if typ == "avcC" {
+					t.config = p
+				}
+				return nil
+			})
+		case "mp4a":
+			version := r.u16()
+			r.skip(6)
+			t.channels = r.u16()
+			r.skip(6)
+			t.sampleRate = r.u32() >> 16
+			switch version {
+			case 1:
+				r.skip(16)
+			case 2:
+				r.skip(36)
+			}
+			if r.err != nil {
+				return r.err
+			}
+			return boxes(r.b, func(typ string, p []byte) error {
+				if typ == "esds" {
+					t.parseESDS(p)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+}
+
+// esds中的ES_Descriptor，取出objectTypeIndication和DecoderSpecificInfo
+func (t *track) parseESDS(b []byte) {
+	r := &reader{b: b}
+	r.version()
+	for r.err == nil && len(r.b) > 0 {
+		tag := r.u8()
+		size := descriptorSize(r)
+		switch tag {
+		case 0x03: // ES_Descriptor
+			r.skip(2)
+			flags := r.u8()
+			if flags&0x80 != 0 {
+				r.skip(2)
+			}
+			if flags&0x40 != 0 {
+				r.skip(int(r.u8()))
+			}
+			if flags&0x20 != 0 {
+				r.skip(2)
+			}
+		case 0x04: // DecoderConfigDescriptor
+			t.objectType = r.u8()
+			r.skip(12)
+		case 0x05: // DecoderSpecificInfo
+			t.config = r.next(size)
+			return
+		default:
+			r.skip(size)
+		}
+	}
+}
+
+// 描述符的长度，每字节7位，最高位表示后面还有
+func descriptorSize(r *reader) int {
+	size := 0
+	for i := 0; i < 4; i++ {
+		b := r.u8()
+		size = size<<7 | int(b&0x7F)
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	return size
+}
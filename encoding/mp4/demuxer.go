@@ -0,0 +1,414 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"io"
+	"sort"
+
+	"github.com/chenyj/rtmp/encoding/amf0"
+	"github.com/chenyj/rtmp/encoding/av"
+)
+
+// moov的大小上限
+const maxMoovSize = 256 << 20
+
+// 一个sample在文件中的位置和时间
+type sample struct {
+	track  *track
+	offset int64
+	size   uint32
+	dts    uint32 // 毫秒
+	cts    int32  // composition time offset，毫秒
+	key    bool
+}
+
+// Demuxer 读取mp4文件中的H.264视频和AAC/MP3音频，转换为FLV格式的数据包。
+//
+// 打开时解析moov建立sample表，ReadPacket先返回onMetaData和配置帧，
+// 再按解码时间顺序返回音视频数据。不支持分片的mp4(moof)。
+type Demuxer struct {
+	r        io.ReadSeeker
+	video    *track
+	audio    *track
+	samples  []sample
+	pos      int          // 下一个sample
+	headers  []*av.Packet // onMetaData, video config, audio config
+	hpos     int          // 下一个返回的headers
+	duration uint32       // 毫秒
+}
+
+func NewDemuxer(r io.ReadSeeker) (*Demuxer, error) {
+	moov, err := readMoov(r)
+	if err != nil {
+		return nil, err
+	}
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	d := &Demuxer{r: r}
+	var timescale uint32
+	var duration uint64
+	err = boxes(moov, func(typ string, p []byte) error {
+		switch typ {
+		case "mvhd":
+			r := &reader{b: p}
+			if r.version() == 1 {
+				r.skip(16)
+				timescale = r.u32()
+				duration = r.u64()
+			} else {
+				r.skip(8)
+				timescale = r.u32()
+				duration = uint64(r.u32())
+			}
+			return r.err
+		case "trak":
+			t, err := parseTrak(p)
+			if err != nil {
+				return err
+			}
+			d.addTrack(t)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if d.video == nil && d.audio == nil {
+		return nil, MP4_UNSUPPORTED
+	}
+
+	for _, t := range []*track{d.video, d.audio} {
+		if t != nil {
+			samples, err := t.samples(size)
+			if err != nil {
+				return nil, err
+			}
+			d.samples = append(d.samples, samples...)
+		}
+	}
+	if len(d.samples) == 0 {
+		// 分片的mp4中sample表为空
+		return nil, MP4_UNSUPPORTED
+	}
+	sort.Slice(d.samples, func(i, j int) bool {
+		a, b := d.samples[i], d.samples[j]
+		if a.dts != b.dts {
+			return a.dts < b.dts
+		}
+		return a.offset < b.offset
+	})
+	if timescale > 0 {
+		d.duration = uint32(duration * 1000 / uint64(timescale))
+	}
+	if last := d.samples[len(d.samples)-1].dts; d.duration < last {
+		d.duration = last
+	}
+	if err = d.makeHeaders(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// 读取moov的内容，跳过其它顶层box
+func readMoov(r io.ReadSeeker) ([]byte, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var buf [16]byte
+	for {
+		if _, err := io.ReadFull(r, buf[:8]); err != nil {
+			if err == io.EOF {
+				err = MP4_FMT_ERROR
+			}
+			return nil, err
+		}
+		size := int64(binary.BigEndian.Uint32(buf[:4]))
+		typ := string(buf[4:8])
+		hdr := int64(8)
+		if size == 1 {
+			if _, err := io.ReadFull(r, buf[8:16]); err != nil {
+				return nil, err
+			}
+			size = int64(binary.BigEndian.Uint64(buf[8:16]))
+			hdr = 16
+		}
+		if typ == "moov" {
+			if size == 0 {
+				return io.ReadAll(io.LimitReader(r, maxMoovSize))
+			}
+			if size < hdr || size-hdr > maxMoovSize {
+				return nil, MP4_FMT_ERROR
+			}
+			moov := make([]byte, size-hdr)
+			_, err := io.ReadFull(r, moov)
+			return moov, err
+		}
+		if size == 0 {
+			// 最后一个box，没有moov
+			return nil, MP4_FMT_ERROR
+		}
+		if size < hdr {
+			return nil, MP4_FMT_ERROR
+		}
+		if _, err := r.Seek(size-hdr, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// 只取第一个H.264视频轨道和第一个AAC或MP3音频轨道
+func (d *Demuxer) addTrack(t *track) {
+	if t.timescale == 0 {
+		return
+	}
+	switch t.handler {
+	case "vide":
+		if d.video == nil && (t.codec == "avc1" || t.codec == "avc3") && len(t.config) > 0 {
+			d.video = t
+		}
+	case "soun":
+		if d.audio == nil && t.codec == "mp4a" && (t.isMP3() || (t.isAAC() && len(t.config) > 0)) {
+			d.audio = t
+		}
+	}
+}
+
+// stsc/stco描述的sample数量，超过limit时返回limit
+func (t *track) chunkSamples(limit int) int {
+	n, e := 0, 0
+	for ci := 0; ci < len(t.chunks) && n < limit; ci++ {
+		for e+1 < len(t.stsc) && t.stsc[e+1].firstChunk <= uint32(ci+1) {
+			e++
+		}
+		if e >= len(t.stsc) {
+			break
+		}
+		perChunk := int64(t.stsc[e].perChunk)
+		if perChunk >= int64(limit-n) {
+			return limit
+		}
+		n += int(perChunk)
+	}
+	return n
+}
+
+func (t *track) isAAC() bool {
+	switch t.objectType {
+	case 0x40, 0x66, 0x67, 0x68:
+		return true
+	}
+	return false
+}
+
+func (t *track) isMP3() bool {
+	return t.objectType == 0x69 || t.objectType == 0x6B
+}
+
+// 根据stsc/stco计算sample的位置，根据stts/ctts/stss计算时间和关键帧
+func (t *track) samples(fileSize int64) ([]sample, error) {
+	n := t.sampleCount
+	if t.sampleSize == 0 && len(t.sizes) < n {
+		return nil, MP4_FMT_ERROR
+	}
+	if t.sampleSize != 0 {
+		// 大小相同时数量没有对应的表，按stsc/stco描述的数量和文件大小限制，避免分配过大的内存
+		if max := t.chunkSamples(n); n > max {
+			n = max
+		}
+		if max := fileSize / int64(t.sampleSize); int64(n) > max {
+			n = int(max)
+		}
+	}
+	out := make([]sample, 0, n)
+	e := 0 // stsc entry
+	for ci := 0; ci < len(t.chunks) && len(out) < n; ci++ {
+		for e+1 < len(t.stsc) && t.stsc[e+1].firstChunk <= uint32(ci+1) {
+			e++
+		}
+		if e >= len(t.stsc) {
+			return nil, MP4_FMT_ERROR
+		}
+		offset := int64(t.chunks[ci])
+		for j := uint32(0); j < t.stsc[e].perChunk && len(out) < n; j++ {
+			size := t.sampleSize
+			if size == 0 {
+				size = t.sizes[len(out)]
+			}
+			out = append(out, sample{track: t, offset: offset, size: size})
+			offset += int64(size)
+		}
+	}
+
+	ms := func(v int64) int64 {
+		return v * 1000 / int64(t.timescale)
+	}
+	var dts int64
+	si, sc := 0, uint32(0) // stts entry和已用数量
+	ci, cc := 0, uint32(0) // ctts entry和已用数量
+	ki := 0                // stss entry
+	for i := range out {
+		s := &out[i]
+		s.dts = uint32(ms(dts))
+		for si < len(t.stts) && sc >= t.stts[si].count {
+			si, sc = si+1, 0
+		}
+		if si < len(t.stts) {
+			dts += int64(t.stts[si].delta)
+			sc++
+		}
+		for ci < len(t.ctts) && cc >= t.ctts[ci].count {
+			ci, cc = ci+1, 0
+		}
+		if ci < len(t.ctts) {
+			s.cts = int32(ms(int64(t.ctts[ci].offset)))
+			cc++
+		}
+		if t.stss == nil {
+			s.key = true
+		} else {
+			for ki < len(t.stss) && t.stss[ki] < uint32(i+1) {
+				ki++
+			}
+			s.key = ki < len(t.stss) && t.stss[ki] == uint32(i+1)
+		}
+	}
+	return out, nil
+}
+
+// onMetaData和配置帧
+func (d *Demuxer) makeHeaders() error {
+	meta := map[string]any{
+		"duration": float64(d.duration) / 1000,
+		"hasVideo": d.video != nil,
+		"hasAudio": d.audio != nil,
+	}
+	if t := d.video; t != nil {
+		meta["videocodecid"] = 7
+		meta["width"] = int(t.width)
+		meta["height"] = int(t.height)
+	}
+	if t := d.audio; t != nil {
+		if t.isAAC() {
+			meta["audiocodecid"] = 10
+		} else {
+			meta["audiocodecid"] = 2
+		}
+		meta["audiosamplerate"] = int(t.sampleRate)
+		meta["audiochannels"] = int(t.channels)
+		meta["stereo"] = t.channels > 1
+	}
+	data, err := amf0.Encode("onMetaData", meta)
+	if err != nil {
+		return err
+	}
+	d.headers = append(d.headers, av.MetaPack(0, data))
+	if t := d.video; t != nil {
+		payload := append([]byte{0x17, 0, 0, 0, 0}, t.config...)
+		d.headers = append(d.headers, av.VideoPack(0, payload))
+	}
+	if t := d.audio; t != nil && t.isAAC() {
+		payload := append([]byte{0xAF, 0}, t.config...)
+		d.headers = append(d.headers, av.AudioPack(0, payload))
+	}
+	return nil
+}
+
+// Headers 返回onMetaData和配置帧
+func (d *Demuxer) Headers() []*av.Packet {
+	return d.headers
+}
+
+// Duration 返回时长，毫秒
+func (d *Demuxer) Duration() uint32 {
+	return d.duration
+}
+
+// Tracks 返回是否有音频和视频
+func (d *Demuxer) Tracks() (hasAudio, hasVideo bool) {
+	return d.audio != nil, d.video != nil
+}
+
+// ReadPacket 返回下一个数据包，先返回Headers，读完时返回io.EOF
+func (d *Demuxer) ReadPacket() (*av.Packet, error) {
+	if d.hpos < len(d.headers) {
+		d.hpos++
+		return d.headers[d.hpos-1], nil
+	}
+	if d.pos >= len(d.samples) {
+		return nil, io.EOF
+	}
+	s := d.samples[d.pos]
+	d.pos++
+
+	var prefix []byte
+	if s.track == d.video {
+		frameType := byte(0x27)
+		if s.key {
+			frameType = 0x17
+		}
+		prefix = []byte{frameType, 1, byte(s.cts >> 16), byte(s.cts >> 8), byte(s.cts)}
+	} else if s.track.isAAC() {
+		prefix = []byte{0xAF, 1}
+	} else {
+		prefix = []byte{mp3SoundHeader(s.track)}
+	}
+	payload := make([]byte, len(prefix)+int(s.size))
+	copy(payload, prefix)
+	if _, err := d.r.Seek(s.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(d.r, payload[len(prefix):]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if s.track == d.video {
+		return av.VideoPack(s.dts, payload), nil
+	}
+	return av.AudioPack(s.dts, payload), nil
+}
+
+// FLV的MP3音频头：format(2)，采样率，16位，声道
+func mp3SoundHeader(t *track) byte {
+	var rate byte
+	switch {
+	case t.sampleRate >= 44100:
+		rate = 3
+	case t.sampleRate >= 22050:
+		rate = 2
+	case t.sampleRate >= 11025:
+		rate = 1
+	}
+	h := byte(0x20) | rate<<2 | 0x02
+	if t.channels > 1 {
+		h |= 0x01
+	}
+	return h
+}
+
+// Seek 定位到ms之前最近的关键帧，ms早于第一个关键帧时定位到第一个关键帧，
+// 返回关键帧的时间戳。没有视频时每个音频帧都可以定位。之后不再返回Headers。
+func (d *Demuxer) Seek(ms uint32) (uint32, error) {
+	found := -1
+	for i, s := range d.samples {
+		if !s.key || (d.video != nil && s.track != d.video) {
+			continue
+		}
+		if found >= 0 && s.dts > ms {
+			break
+		}
+		found = i
+		if s.dts > ms {
+			break
+		}
+	}
+	if found < 0 {
+		return 0, MP4_FMT_ERROR
+	}
+	d.pos = found
+	d.hpos = len(d.headers)
+	return d.samples[found].dts, nil
+}
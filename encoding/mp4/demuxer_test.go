@@ -0,0 +1,175 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+func box(typ string, payload ...[]byte) []byte {
+	b := make([]byte, 8)
+	copy(b[4:], typ)
+	for _, p := range payload {
+		b = append(b, p...)
+	}
+	binary.BigEndian.PutUint32(b, uint32(len(b)))
+	return b
+}
+
+// full box：version和flags，之后是32位整数
+func fullBox(typ string, vs ...uint32) []byte {
+	b := make([]byte, 4+4*len(vs))
+	for i, v := range vs {
+		binary.BigEndian.PutUint32(b[4+4*i:], v)
+	}
+	return box(typ, b)
+}
+
+func hdlr(handler string) []byte {
+	b := make([]byte, 8, 24)
+	b = append(b, handler...)
+	b = append(b, make([]byte, 12)...)
+	return box("hdlr", b)
+}
+
+func mdhd(timescale, duration uint32) []byte {
+	return fullBox("mdhd", 0, 0, timescale, duration, 0)
+}
+
+func avc1() []byte {
+	b := make([]byte, 78)
+	binary.BigEndian.PutUint16(b[24:], 640)
+	binary.BigEndian.PutUint16(b[26:], 360)
+	return box("avc1", b, box("avcC", []byte{1, 0x64, 0, 0x1F, 0xFF}))
+}
+
+func mp4a() []byte {
+	b := make([]byte, 28)
+	binary.BigEndian.PutUint16(b[16:], 2)
+	binary.BigEndian.PutUint32(b[24:], 44100<<16)
+	esds := []byte{0, 0, 0, 0,
+		0x03, 24, 0, 1, 0, // ES_Descriptor
+		0x04, 17, 0x40, 0x15, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, // DecoderConfigDescriptor
+		0x05, 2, 0x12, 0x10, // AudioSpecificConfig
+	}
+	return box("mp4a", b, box("esds", esds))
+}
+
+func trak(id uint32, handler string, timescale uint32, entry []byte, stbl ...[]byte) []byte {
+	tkhd := fullBox("tkhd", 0, 0, id)
+	stsd := box("stsd", []byte{0, 0, 0, 0, 0, 0, 0, 1}, entry)
+	return box("trak", tkhd, box("mdia", mdhd(timescale, 0), hdlr(handler),
+		box("minf", box("stbl", append([][]byte{stsd}, stbl...)...))))
+}
+
+// 4帧视频(40ms，第0和第2帧是关键帧，ctts为80ms)和3帧AAC音频
+func testFile() []byte {
+	ftyp := box("ftyp", []byte("isom\x00\x00\x00\x00"))
+	var data []byte
+	fill := func(v byte, n int) {
+		data = append(data, bytes.Repeat([]byte{v}, n)...)
+	}
+	base := uint32(len(ftyp) + 8)
+	v1 := base
+	fill(0, 10)
+	fill(1, 5)
+	a1 := base + uint32(len(data))
+	fill(0xA0, 4)
+	fill(0xA1, 4)
+	fill(0xA2, 4)
+	v2 := base + uint32(len(data))
+	fill(2, 5)
+	fill(3, 5)
+	mdat := box("mdat", data)
+
+	video := trak(1, "vide", 25000, avc1(),
+		fullBox("stts", 1, 4, 1000),
+		fullBox("ctts", 1, 4, 2000),
+		fullBox("stss", 2, 1, 3),
+		fullBox("stsz", 0, 4, 10, 5, 5, 5),
+		fullBox("stsc", 1, 1, 2, 1),
+		fullBox("stco", 2, v1, v2))
+	audio := trak(2, "soun", 44100, mp4a(),
+		fullBox("stts", 1, 3, 1024),
+		fullBox("stsz", 4, 3),
+		fullBox("stsc", 1, 1, 3, 1),
+		fullBox("stco", 1, a1))
+	mvhd := fullBox("mvhd", 0, 0, 1000, 160)
+	return append(append(ftyp, mdat...), box("moov", mvhd, video, audio)...)
+}
+
+func TestDemuxer(t *testing.T) {
+	d, err := NewDemuxer(bytes.NewReader(testFile()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Duration() != 160 {
+		t.Fatalf("duration %d, expect 160", d.Duration())
+	}
+	hs := d.Headers()
+	if len(hs) != 3 || !hs[0].IsMeta() || !hs[1].IsConfig || !hs[2].IsConfig {
+		t.Fatalf("headers: %v", hs)
+	}
+	if !bytes.Equal(hs[2].Payload, []byte{0xAF, 0, 0x12, 0x10}) {
+		t.Fatalf("audio config % X", hs[2].Payload)
+	}
+
+	for range hs {
+		d.ReadPacket()
+	}
+	want := []struct {
+		video bool
+		ts    uint32
+		first byte // 数据的第一个字节
+		key   bool
+	}{
+		{true, 0, 0, true}, {false, 0, 0xA0, true}, {false, 23, 0xA1, true}, {true, 40, 1, false},
+		{false, 46, 0xA2, true}, {true, 80, 2, true}, {true, 120, 3, false},
+	}
+	for i, w := range want {
+		p, err := d.ReadPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.IsVideo() != w.video || p.Timestamp != w.ts {
+			t.Fatalf("packet %d: video %v ts %d, expect %v %d", i, p.IsVideo(), p.Timestamp, w.video, w.ts)
+		}
+		data := p.Payload[2:]
+		if w.video {
+			if p.IsKeyFrame != w.key || !bytes.Equal(p.Payload[2:5], []byte{0, 0, 80}) {
+				t.Fatalf("packet %d: key %v cts % X", i, p.IsKeyFrame, p.Payload[2:5])
+			}
+			data = p.Payload[5:]
+		}
+		if data[0] != w.first {
+			t.Fatalf("packet %d: data % X", i, data)
+		}
+	}
+	if _, err = d.ReadPacket(); err != io.EOF {
+		t.Fatalf("expect EOF, got %v", err)
+	}
+
+	if ts, err := d.Seek(100); err != nil || ts != 80 {
+		t.Fatalf("seek: %d %v", ts, err)
+	}
+	if p, _ := d.ReadPacket(); p.Timestamp != 80 || !p.IsKeyFrame {
+		t.Fatalf("after seek: %d", p.Timestamp)
+	}
+}
+
+func TestDemuxerInvalid(t *testing.T) {
+	file := testFile()
+	if _, err := NewDemuxer(bytes.NewReader(file[:len(file)-20])); err == nil {
+		t.Fatal("expect error on truncated moov")
+	}
+	if _, err := NewDemuxer(bytes.NewReader(box("ftyp", []byte("isom")))); err == nil {
+		t.Fatal("expect error without moov")
+	}
+	// 大小相同的sample数量按stsc/stco限制
+	file = bytes.Replace(file, fullBox("stsz", 4, 3), fullBox("stsz", 4, 0xFFFFFFFF), 1)
+	d, err := NewDemuxer(bytes.NewReader(file))
+	if err != nil || len(d.samples) != 7 {
+		t.Fatalf("huge sample count: %v", err)
+	}
+}
@@ -0,0 +1,502 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/chenyj/rtmp/encoding/amf0"
+	"github.com/chenyj/rtmp/encoding/av"
+	"github.com/chenyj/rtmp/encoding/codec"
+	"github.com/chenyj/rtmp/encoding/flv"
+)
+
+// 写入的mp4时间单位都是毫秒
+const remuxTimescale = 1000
+
+// 写入mp4的一个轨道
+type outTrack struct {
+	id         uint32
+	video      bool
+	codec      string // avc1, hvc1, mp4a
+	config     []byte // 解码配置，MP3没有
+	objectType uint8  // 音频的objectTypeIndication
+	width      uint16
+	height     uint16
+	channels   uint16
+	sampleRate uint32
+
+	sizes   []uint32
+	dts     []uint32
+	cts     []int32
+	keys    []uint32 // 从1开始的关键帧编号
+	chunks  []int64  // chunk相对mdat数据开始的偏移
+	stsc    []stscEntry
+	lastDTS uint32
+}
+
+// 一段连续的同一轨道的sample
+type outChunk struct {
+	track  *outTrack
+	offset int64
+	count  uint32
+}
+
+// FLV到MP4的转换
+type remuxer struct {
+	video  *outTrack
+	audio  *outTrack
+	chunks []*outChunk
+	size   int64  // mdat数据大小
+	keep   []bool // 第一遍每个Tag是否写入mdat，第二遍按它复制
+	width  uint16
+	height uint16
+}
+
+// RemuxFLV 把src中的FLV转换为moov在前的MP4写入dst，支持H.264/H.265视频和AAC/MP3音频。
+// src读取两遍，第一遍建立sample表，第二遍复制数据，媒体数据不会整个读入内存。
+// 只使用每个轨道的第一个配置帧，之前的数据帧被丢弃。
+func RemuxFLV(dst io.Writer, src io.ReadSeeker) error {
+	m := &remuxer{}
+	err := eachTag(src, func(p *av.Packet) error {
+		keep := false
+		if p.IsMeta() {
+			m.readMeta(p)
+		} else if t, data := m.sample(p); t != nil && !p.IsConfig {
+			m.add(t, p, data)
+			keep = true
+		}
+		m.keep = append(m.keep, keep)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if m.video == nil && m.audio == nil {
+		return MP4_UNSUPPORTED
+	}
+
+	ftyp := buildBox("ftyp", []byte("isom"), u32(512), []byte("isomiso2avc1mp41"))
+	mdatHeader := u32(uint32(8 + m.size))
+	mdatHeader = append(mdatHeader, "mdat"...)
+	if 8+m.size > math.MaxUint32 {
+		mdatHeader = append(u32(1), "mdat"...)
+		mdatHeader = append(mdatHeader, u64(uint64(16+m.size))...)
+	}
+	// 用co64时moov最大，按它判断偏移是否超过32位，
+	// 确定了large之后moov的大小不再变化
+	moov := m.moov(0, true)
+	large := int64(len(ftyp)+len(moov))+int64(len(mdatHeader))+m.size > math.MaxUint32
+	moov = m.moov(0, large)
+	base := int64(len(ftyp)+len(moov)) + int64(len(mdatHeader))
+	moov = m.moov(base, large)
+	for _, b := range [][]byte{ftyp, moov, mdatHeader} {
+		if _, err = dst.Write(b); err != nil {
+			return err
+		}
+	}
+
+	// 第二遍复制第一遍选中的Tag，轨道已经创建，配置帧之前的数据帧也要跳过
+	n := 0
+	return eachTag(src, func(p *av.Packet) error {
+		n++
+		if n > len(m.keep) || !m.keep[n-1] {
+			return nil
+		}
+		_, data := m.sample(p)
+		_, err := dst.Write(data)
+		return err
+	})
+}
+
+// 从头读取src中的所有Tag
+func eachTag(src io.ReadSeeker, fn func(*av.Packet) error) error {
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r := flv.NewTagReader(src)
+	if _, err := r.ReadFlvHeader(); err != nil {
+		return err
+	}
+	for {
+		h, data, err := r.ReadTag()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var p *av.Packet
+		switch h.TagType {
+		case flv.FLV_TAG_AUDIO:
+			p = av.AudioPack(h.Timestamp, data)
+		case flv.FLV_TAG_VIDEO:
+			p = av.VideoPack(h.Timestamp, data)
+		case flv.FLV_TAG_DATA:
+			p = av.MetaPack(h.Timestamp, data)
+		default:
+			continue
+		}
+		if err = fn(p); err != nil {
+			return err
+		}
+	}
+}
+
+// onMetaData中的宽高
+func (m *remuxer) readMeta(p *av.Packet) {
+	ar, err := amf0.Decode(p.Payload)
+	if err != nil || len(ar) < 2 {
+		return
+	}
+	if kv, ok := ar.GetKV(1); ok {
+		if w, ok := kv.GetFloat64("width"); ok {
+			m.width = uint16(w)
+		}
+		if h, ok := kv.GetFloat64("height"); ok {
+			m.height = uint16(h)
+		}
+	}
+}
+
+// 数据包所属的轨道和去掉FLV头部的数据。
+// 第一遍遇到第一个配置帧时创建轨道，没有轨道或不支持的编码返回nil。
+func (m *remuxer) sample(p *av.Packet) (*outTrack, []byte) {
+	switch {
+	case p.IsVideo():
+		codec := p.VideoCodec()
+		if codec != "avc1" && codec != "hvc1" {
+			return nil, nil
+		}
+		_, data := p.VideoData()
+		if m.video == nil && p.IsConfig && len(data) > 0 {
+			m.video = &outTrack{video: true, codec: codec, config: data}
+		}
+		if m.video == nil || m.video.codec != codec || len(data) == 0 {
+			return nil, nil
+		}
+		return m.video, data
+	case p.IsAudio():
+		if len(p.Payload) < 2 {
+			return nil, nil
+		}
+		switch p.Payload[0] >> 4 {
+		case 10: // AAC
+			if m.audio == nil && p.IsConfig {
+				m.audio = &outTrack{codec: "mp4a", objectType: 0x40, config: p.Payload[2:], sampleRate: 44100, channels: 2}
+				if asc, err := codec.ParseAudioSpecificConfig(p.Payload[2:]); err == nil {
+					m.audio.sampleRate, m.audio.channels = uint32(asc.OutputSampleRate()), uint16(asc.Channels)
+				}
+			}
+			if m.audio == nil || m.audio.objectType != 0x40 {
+				return nil, nil
+			}
+			return m.audio, p.Payload[2:]
+		case 2: // MP3
+			if m.audio == nil {
+				rates := [4]uint32{5512, 11025, 22050, 44100}
+				m.audio = &outTrack{codec: "mp4a", objectType: 0x6B,
+					sampleRate: rates[(p.Payload[0]>>2)&0x03], channels: uint16(p.Payload[0]&0x01) + 1}
+			}
+			if m.audio.objectType != 0x6B {
+				return nil, nil
+			}
+			return m.audio, p.Payload[1:]
+		}
+	}
+	return nil, nil
+}
+
+// 记录一个sample，同一轨道连续的sample放在一个chunk中
+func (m *remuxer) add(t *outTrack, p *av.Packet, data []byte) {
+	if p.IsConfig {
+		return
+	}
+	cts, _ := p.VideoData()
+	if !t.video {
+		cts = 0
+	}
+	t.sizes = append(t.sizes, uint32(len(data)))
+	t.dts = append(t.dts, p.Timestamp)
+	t.cts = append(t.cts, cts)
+	if !t.video || p.IsKeyFrame {
+		t.keys = append(t.keys, uint32(len(t.sizes)))
+	}
+
+	n := len(m.chunks)
+	if n > 0 && m.chunks[n-1].track == t {
+		m.chunks[n-1].count++
+	} else {
+		m.chunks = append(m.chunks, &outChunk{track: t, offset: m.size, count: 1})
+	}
+	m.size += int64(len(data))
+}
+
+func (m *remuxer) tracks() []*outTrack {
+	var tracks []*outTrack
+	for _, t := range []*outTrack{m.video, m.audio} {
+		if t != nil && len(t.sizes) > 0 {
+			t.id = uint32(len(tracks) + 1)
+			tracks = append(tracks, t)
+		}
+	}
+	return tracks
+}
+
+// 所有轨道中最早的时间戳，作为mp4的0点
+func (m *remuxer) start() uint32 {
+	start := uint32(math.MaxUint32)
+	for _, t := range m.tracks() {
+		if t.dts[0] < start {
+			start = t.dts[0]
+		}
+	}
+	return start
+}
+
+// moov，base是mdat数据在文件中的偏移，large时使用co64
+func (m *remuxer) moov(base int64, large bool) []byte {
+	for _, t := range m.tracks() {
+		t.chunks, t.stsc = t.chunks[:0], t.stsc[:0]
+	}
+	for _, c := range m.chunks {
+		t := c.track
+		t.chunks = append(t.chunks, base+c.offset)
+		if n := len(t.stsc); n == 0 || t.stsc[n-1].perChunk != c.count {
+			t.stsc = append(t.stsc, stscEntry{uint32(len(t.chunks)), c.count})
+		}
+	}
+
+	start := m.start()
+	var duration uint32
+	var traks [][]byte
+	tracks := m.tracks()
+	for _, t := range tracks {
+		if t.video {
+			t.width, t.height = m.width, m.height
+		}
+		trak, end := t.trak(start, large)
+		traks = append(traks, trak)
+		if end > duration {
+			duration = end
+		}
+	}
+
+	mvhd := buildFullBox("mvhd", 0, 0,
+		u32(0), u32(0), u32(remuxTimescale), u32(duration),
+		u32(0x00010000), u16(0x0100), make([]byte, 10), matrix(),
+		make([]byte, 24), u32(uint32(len(tracks)+1)))
+	return buildBox("moov", append([][]byte{mvhd}, traks...)...)
+}
+
+// 单位矩阵
+func matrix() []byte {
+	var b []byte
+	for _, v := range []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000} {
+		b = append(b, u32(v)...)
+	}
+	return b
+}
+
+// trak和轨道在mp4时间轴上的结束时间
+func (t *outTrack) trak(start uint32, large bool) ([]byte, uint32) {
+	// 最后一个sample的时长和前一个相同
+	deltas := make([]uint32, len(t.dts))
+	for i := 0; i+1 < len(t.dts); i++ {
+		if t.dts[i+1] > t.dts[i] {
+			deltas[i] = t.dts[i+1] - t.dts[i]
+		}
+	}
+	if n := len(deltas); n > 1 {
+		deltas[n-1] = deltas[n-2]
+	}
+	var duration uint32
+	for _, d := range deltas {
+		duration += d
+	}
+	delay := t.dts[0] - start
+
+	var volume uint16
+	handler, name := "vide", "VideoHandler"
+	mhd := buildFullBox("vmhd", 0, 1, make([]byte, 8))
+	if !t.video {
+		volume = 0x0100
+		handler, name = "soun", "SoundHandler"
+		mhd = buildFullBox("smhd", 0, 0, make([]byte, 4))
+	}
+	tkhd := buildFullBox("tkhd", 0, 3,
+		u32(0), u32(0), u32(t.id), u32(0), u32(delay+duration),
+		make([]byte, 8), u16(0), u16(0), u16(volume), u16(0), matrix(),
+		u32(uint32(t.width)<<16), u32(uint32(t.height)<<16))
+
+	var edts []byte
+	if delay > 0 {
+		// 轨道开始得晚，用空的edit补齐
+		edts = buildBox("edts", buildFullBox("elst", 0, 0, u32(2),
+			u32(delay), u32(math.MaxUint32), u32(0x00010000),
+			u32(duration), u32(0), u32(0x00010000)))
+	}
+
+	mdhd := buildFullBox("mdhd", 0, 0, u32(0), u32(0), u32(remuxTimescale), u32(duration), u16(0x55C4), u16(0))
+	hdlr := buildFullBox("hdlr", 0, 0, u32(0), []byte(handler), make([]byte, 12), []byte(name+"\x00"))
+	dinf := buildBox("dinf", buildFullBox("dref", 0, 0, u32(1), buildFullBox("url ", 0, 1)))
+	stbl := buildBox("stbl", t.stbl(deltas, large)...)
+	mdia := buildBox("mdia", mdhd, hdlr, buildBox("minf", mhd, dinf, stbl))
+	return buildBox("trak", tkhd, edts, mdia), delay + duration
+}
+
+func (t *outTrack) stbl(deltas []uint32, large bool) [][]byte {
+	boxes := [][]byte{buildFullBox("stsd", 0, 0, u32(1), t.sampleEntry())}
+
+	// stts按时长合并
+	var stts []byte
+	var entries uint32
+	for i := 0; i < len(deltas); {
+		j := i + 1
+		for j < len(deltas) && deltas[j] == deltas[i] {
+			j++
+		}
+		stts = append(stts, u32(uint32(j-i))...)
+		stts = append(stts, u32(deltas[i])...)
+		entries++
+		i = j
+	}
+	boxes = append(boxes, buildFullBox("stts", 0, 0, u32(entries), stts))
+
+	// ctts只在有composition time时写入，有负数时使用version 1
+	var ctts []byte
+	var version uint8
+	entries = 0
+	nonZero := false
+	for i := 0; i < len(t.cts); {
+		j := i + 1
+		for j < len(t.cts) && t.cts[j] == t.cts[i] {
+			j++
+		}
+		if t.cts[i] != 0 {
+			nonZero = true
+		}
+		if t.cts[i] < 0 {
+			version = 1
+		}
+		ctts = append(ctts, u32(uint32(j-i))...)
+		ctts = append(ctts, u32(uint32(t.cts[i]))...)
+		entries++
+		i = j
+	}
+	if nonZero {
+		boxes = append(boxes, buildFullBox("ctts", version, 0, u32(entries), ctts))
+	}
+
+	if t.video && len(t.keys) < len(t.sizes) {
+		var stss []byte
+		for _, k := range t.keys {
+			stss = append(stss, u32(k)...)
+		}
+		boxes = append(boxes, buildFullBox("stss", 0, 0, u32(uint32(len(t.keys))), stss))
+	}
+
+	stsz := make([]byte, 0, 4*len(t.sizes))
+	for _, size := range t.sizes {
+		stsz = append(stsz, u32(size)...)
+	}
+	boxes = append(boxes, buildFullBox("stsz", 0, 0, u32(0), u32(uint32(len(t.sizes))), stsz))
+
+	var stsc []byte
+	for _, e := range t.stsc {
+		stsc = append(stsc, u32(e.firstChunk)...)
+		stsc = append(stsc, u32(e.perChunk)...)
+		stsc = append(stsc, u32(1)...)
+	}
+	boxes = append(boxes, buildFullBox("stsc", 0, 0, u32(uint32(len(t.stsc))), stsc))
+
+	var stco []byte
+	for _, offset := range t.chunks {
+		if large {
+			stco = append(stco, u64(uint64(offset))...)
+		} else {
+			stco = append(stco, u32(uint32(offset))...)
+		}
+	}
+	typ := "stco"
+	if large {
+		typ = "co64"
+	}
+	return append(boxes, buildFullBox(typ, 0, 0, u32(uint32(len(t.chunks))), stco))
+}
+
+func (t *outTrack) sampleEntry() []byte {
+	if t.video {
+		compressor := make([]byte, 32)
+		config := "avcC"
+		if t.codec == "hvc1" {
+			config = "hvcC"
+		}
+		return buildBox(t.codec, make([]byte, 6), u16(1),
+			make([]byte, 16), u16(t.width), u16(t.height),
+			u32(0x00480000), u32(0x00480000), u32(0), u16(1), compressor,
+			u16(0x0018), u16(0xFFFF), buildBox(config, t.config))
+	}
+
+	// DecoderConfigDescriptor: objectType, streamType(audio), bufferSize, maxBitrate, avgBitrate
+	dcd := append([]byte{t.objectType, 0x15}, make([]byte, 11)...)
+	if len(t.config) > 0 {
+		dcd = append(dcd, descriptor(0x05, t.config)...)
+	}
+	es := append(u16(uint16(t.id)), 0)
+	es = append(es, descriptor(0x04, dcd)...)
+	es = append(es, descriptor(0x06, []byte{0x02})...)
+	esds := buildFullBox("esds", 0, 0, descriptor(0x03, es))
+	return buildBox("mp4a", make([]byte, 6), u16(1),
+		make([]byte, 8), u16(t.channels), u16(16), u16(0), u16(0),
+		u32(t.sampleRate<<16), esds)
+}
+
+// 描述符，长度每字节7位
+func descriptor(tag byte, data []byte) []byte {
+	b := []byte{tag}
+	n := len(data)
+	var size []byte
+	for {
+		size = append([]byte{byte(n & 0x7F)}, size...)
+		n >>= 7
+		if n == 0 {
+			break
+		}
+	}
+	for i := 0; i < len(size)-1; i++ {
+		size[i] |= 0x80
+	}
+	return append(append(b, size...), data...)
+}
+
+func buildBox(typ string, payload ...[]byte) []byte {
+	size := 8
+	for _, p := range payload {
+		size += len(p)
+	}
+	b := make([]byte, 8, size)
+	binary.BigEndian.PutUint32(b, uint32(size))
+	copy(b[4:], typ)
+	for _, p := range payload {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func buildFullBox(typ string, version uint8, flags uint32, payload ...[]byte) []byte {
+	vf := u32(uint32(version)<<24 | flags&0xFFFFFF)
+	return buildBox(typ, append([][]byte{vf}, payload...)...)
+}
+
+func u16(v uint16) []byte {
+	return []byte{byte(v >> 8), byte(v)}
+}
+
+func u32(v uint32) []byte {
+	return []byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+}
+
+func u64(v uint64) []byte {
+	return append(u32(uint32(v>>32)), u32(uint32(v))...)
+}
@@ -0,0 +1,136 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/chenyj/rtmp/encoding/amf0"
+	"github.com/chenyj/rtmp/encoding/flv"
+)
+
+// 4帧视频(40ms，第0和第2帧是关键帧，composition time为80ms)和3帧AAC音频
+func testFLV(t *testing.T) []byte {
+	var b bytes.Buffer
+	w := flv.NewTagWriter(&b)
+	w.WriteFlvHeader(true, true)
+	meta, err := amf0.Encode("onMetaData", map[string]any{"width": 640.0, "height": 360.0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteTag(flv.FLV_TAG_DATA, 0, meta)
+	w.WriteTag(flv.FLV_TAG_VIDEO, 0, []byte{0x17, 0, 0, 0, 0, 1, 0x64, 0, 0x1F, 0xFF})
+	w.WriteTag(flv.FLV_TAG_AUDIO, 0, []byte{0xAF, 0, 0x12, 0x10})
+	for i := 0; i < 4; i++ {
+		frameType := byte(0x27)
+		if i%2 == 0 {
+			frameType = 0x17
+		}
+		w.WriteTag(flv.FLV_TAG_VIDEO, uint32(i*40), []byte{frameType, 1, 0, 0, 80, byte(i), byte(i)})
+		if i < 3 {
+			w.WriteTag(flv.FLV_TAG_AUDIO, uint32(i*23), []byte{0xAF, 1, 0xA0 + byte(i)})
+		}
+	}
+	w.Close()
+	return b.Bytes()
+}
+
+func TestRemuxFLV(t *testing.T) {
+	var out bytes.Buffer
+	if err := RemuxFLV(&out, bytes.NewReader(testFLV(t))); err != nil {
+		t.Fatal(err)
+	}
+	file := out.Bytes()
+	if typ := string(file[binary.BigEndian.Uint32(file)+4:][:4]); typ != "moov" {
+		t.Fatalf("%s after ftyp, expect moov", typ)
+	}
+
+	d, err := NewDemuxer(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.video.width != 640 || d.video.height != 360 || d.audio.sampleRate != 44100 || d.audio.channels != 2 {
+		t.Fatalf("video %dx%d, audio %d/%d", d.video.width, d.video.height, d.audio.sampleRate, d.audio.channels)
+	}
+	hs := d.Headers()
+	if len(hs) != 3 || !bytes.Equal(hs[1].Payload[5:], []byte{1, 0x64, 0, 0x1F, 0xFF}) ||
+		!bytes.Equal(hs[2].Payload[2:], []byte{0x12, 0x10}) {
+		t.Fatalf("headers: %v", hs)
+	}
+	for range hs {
+		d.ReadPacket()
+	}
+	want := []struct {
+		video bool
+		ts    uint32
+		first byte
+		key   bool
+	}{
+		{true, 0, 0, true}, {false, 0, 0xA0, true}, {false, 23, 0xA1, true}, {true, 40, 1, false},
+		{false, 46, 0xA2, true}, {true, 80, 2, true}, {true, 120, 3, false},
+	}
+	for i, w := range want {
+		p, err := d.ReadPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.IsVideo() != w.video || p.Timestamp != w.ts {
+			t.Fatalf("packet %d: video %v ts %d, expect %v %d", i, p.IsVideo(), p.Timestamp, w.video, w.ts)
+		}
+		data := p.Payload[2:]
+		if w.video {
+			cts, vdata := p.VideoData()
+			if p.IsKeyFrame != w.key || cts != 80 || len(vdata) != 2 {
+				t.Fatalf("packet %d: key %v cts %d data % X", i, p.IsKeyFrame, cts, vdata)
+			}
+			data = vdata
+		}
+		if data[0] != w.first {
+			t.Fatalf("packet %d: data % X", i, data)
+		}
+	}
+}
+
+func TestRemuxFrameBeforeConfig(t *testing.T) {
+	// 配置帧之前的视频帧和AAC帧被丢弃，不能写入mdat
+	var b bytes.Buffer
+	w := flv.NewTagWriter(&b)
+	w.WriteFlvHeader(true, true)
+	w.WriteTag(flv.FLV_TAG_VIDEO, 0, []byte{0x27, 1, 0, 0, 0, 9, 9})
+	w.WriteTag(flv.FLV_TAG_AUDIO, 0, []byte{0xAF, 1, 0xA9})
+	w.WriteTag(flv.FLV_TAG_VIDEO, 0, []byte{0x17, 0, 0, 0, 0, 1, 0x64, 0, 0x1F, 0xFF})
+	w.WriteTag(flv.FLV_TAG_AUDIO, 0, []byte{0xAF, 0, 0x12, 0x10})
+	for i := 0; i < 3; i++ {
+		w.WriteTag(flv.FLV_TAG_VIDEO, uint32(i*40), []byte{0x17, 1, 0, 0, 0, byte(i), byte(i)})
+		w.WriteTag(flv.FLV_TAG_AUDIO, uint32(i*40), []byte{0xAF, 1, 0xA0 + byte(i)})
+	}
+	w.Close()
+
+	var out bytes.Buffer
+	if err := RemuxFLV(&out, bytes.NewReader(b.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDemuxer(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range d.Headers() {
+		d.ReadPacket()
+	}
+	var video, audio []byte
+	for i := 0; i < 6; i++ {
+		p, err := d.ReadPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.IsVideo() {
+			_, data := p.VideoData()
+			video = append(video, data...)
+		} else {
+			audio = append(audio, p.Payload[2:]...)
+		}
+	}
+	if !bytes.Equal(video, []byte{0, 0, 1, 1, 2, 2}) || !bytes.Equal(audio, []byte{0xA0, 0xA1, 0xA2}) {
+		t.Fatalf("video % X audio % X", video, audio)
+	}
+}
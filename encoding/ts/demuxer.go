@@ -0,0 +1,447 @@
+package ts
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/chenyj/rtmp/encoding/amf0"
+	"github.com/chenyj/rtmp/encoding/av"
+	"github.com/chenyj/rtmp/encoding/codec"
+)
+
+const PACKET_SIZE = 188
+
+// PMT中支持的stream_type
+const (
+	STREAM_TYPE_AAC  = 0x0F // ADTS
+	STREAM_TYPE_H264 = 0x1B
+	STREAM_TYPE_H265 = 0x24
+)
+
+// 33位时间戳，90kHz
+const (
+	timestampMask = 1<<33 - 1
+	clockRate     = 90
+)
+
+// Demuxer 解析MPEG-TS，把每个节目中的第一个H.264/H.265视频和第一个AAC音频
+// 转换为FLV格式的数据包：Annex-B转换为AVCC，ADTS转换为raw AAC，
+// SPS/PPS和AudioSpecificConfig变化时先生成配置帧。
+//
+// 解析到PMT时生成onMetaData，时间戳从节目的第一个时间戳开始，单位毫秒。
+type Demuxer struct {
+	fn       func(program uint16, p *av.Packet)
+	buf      []byte              // 不完整的TS包
+	pmts     map[uint16]*program // PMT的PID
+	programs map[uint16]*program
+	streams  map[uint16]*pesStream // 音视频的PID
+}
+
+// 一个节目
+type program struct {
+	number  uint16
+	video   *pesStream
+	audio   *pesStream
+	started bool  // 已收到第一个时间戳
+	base    int64 // 第一个时间戳
+	last    int64 // 最近的时间戳，处理33位回绕
+	meta    bool  // 已生成onMetaData
+}
+
+// 一个音视频流的PES
+type pesStream struct {
+	program    *program
+	streamType uint8
+	cc         int    // 上一个continuity_counter，-1表示没有
+	buf        []byte // 正在组装的PES
+	length     int    // PES的总长度，0表示没有指定
+	started    bool
+	config     []byte // 上一个配置帧的内容
+	vps        []byte
+	sps        []byte
+	pps        []byte
+}
+
+// NewDemuxer fn接收每个节目的数据包，在Write或Flush中调用
+func NewDemuxer(fn func(program uint16, p *av.Packet)) *Demuxer {
+	return &Demuxer{
+		fn:       fn,
+		pmts:     map[uint16]*program{},
+		programs: map[uint16]*program{},
+		streams:  map[uint16]*pesStream{},
+	}
+}
+
+// Write 写入TS数据，不需要按188字节对齐，同步字节错误时重新同步，不会返回错误
+func (d *Demuxer) Write(b []byte) (int, error) {
+	d.buf = append(d.buf, b...)
+	i := 0
+	for len(d.buf)-i >= PACKET_SIZE {
+		if d.buf[i] != 0x47 {
+			next := bytes.IndexByte(d.buf[i+1:], 0x47)
+			if next < 0 {
+				i = len(d.buf)
+				break
+			}
+			i += next + 1
+			continue
+		}
+		d.packet(d.buf[i : i+PACKET_SIZE])
+		i += PACKET_SIZE
+	}
+	d.buf = append(d.buf[:0], d.buf[i:]...)
+	return len(b), nil
+}
+
+// Flush 输出所有未结束的PES，输入结束时调用
+func (d *Demuxer) Flush() {
+	for _, s := range d.streams {
+		d.flush(s)
+	}
+}
+
+func (d *Demuxer) packet(b []byte) {
+	pusi := b[1]&0x40 != 0
+	pid := uint16(b[1]&0x1F)<<8 | uint16(b[2])
+	afc := b[3] >> 4 & 0x03
+	cc := int(b[3] & 0x0F)
+	payload := b[4:]
+	discontinuity := false
+	if afc&0x02 != 0 {
+		if int(payload[0]) >= len(payload) {
+			return
+		}
+		discontinuity = payload[0] > 0 && payload[1]&0x80 != 0
+		payload = payload[1+int(payload[0]):]
+	}
+	if afc&0x01 == 0 {
+		return
+	}
+
+	if pid == 0 {
+		d.parsePAT(section(payload, pusi))
+	} else if p, ok := d.pmts[pid]; ok {
+		d.parsePMT(p, section(payload, pusi))
+	} else if s, ok := d.streams[pid]; ok {
+		if s.cc >= 0 && !discontinuity {
+			switch {
+			case cc == s.cc:
+				// 重复发送的TS包
+				return
+			case cc != (s.cc+1)&0x0F:
+				// 丢包时丢弃正在组装的PES
+				s.started = false
+			}
+		}
+		s.cc = cc
+		d.pes(s, payload, pusi)
+	}
+}
+
+// PSI的section，只处理在一个TS包内的section
+func section(payload []byte, pusi bool) []byte {
+	if !pusi || len(payload) == 0 || 1+int(payload[0]) > len(payload) {
+		return nil
+	}
+	sec := payload[1+int(payload[0]):]
+	if len(sec) < 3 {
+		return nil
+	}
+	end := 3 + (int(sec[1]&0x0F)<<8 | int(sec[2]))
+	if end > len(sec) || end < 12 {
+		return nil
+	}
+	// 去掉CRC
+	return sec[:end-4]
+}
+
+func (d *Demuxer) parsePAT(sec []byte) {
+	if len(sec) < 8 || sec[0] != 0x00 {
+		return
+	}
+	for i := 8; i+4 <= len(sec); i += 4 {
+		number := binary.BigEndian.Uint16(sec[i:])
+		pid := binary.BigEndian.Uint16(sec[i+2:]) & 0x1FFF
+		if number == 0 { // network PID
+			continue
+		}
+		p, ok := d.programs[number]
+		if !ok {
+			p = &program{number: number}
+			d.programs[number] = p
+		}
+		d.pmts[pid] = p
+	}
+}
+
+func (d *Demuxer) parsePMT(p *program, sec []byte) {
+	if len(sec) < 12 || sec[0] != 0x02 {
+		return
+	}
+	i := 12 + (int(sec[10]&0x0F)<<8 | int(sec[11]))
+	for i+5 <= len(sec) {
+		streamType := sec[i]
+		pid := binary.BigEndian.Uint16(sec[i+1:]) & 0x1FFF
+		i += 5 + (int(sec[i+3]&0x0F)<<8 | int(sec[i+4]))
+		if _, ok := d.streams[pid]; ok {
+			continue
+		}
+		s := &pesStream{program: p, streamType: streamType, cc: -1}
+		switch {
+		case (streamType == STREAM_TYPE_H264 || streamType == STREAM_TYPE_H265) && p.video == nil:
+			p.video = s
+		case streamType == STREAM_TYPE_AAC && p.audio == nil:
+			p.audio = s
+		default:
+			continue
+		}
+		d.streams[pid] = s
+	}
+	if !p.meta && (p.video != nil || p.audio != nil) {
+		p.meta = true
+		d.meta(p)
+	}
+}
+
+// 根据PMT生成onMetaData
+func (d *Demuxer) meta(p *program) {
+	meta := map[string]any{
+		"hasVideo": p.video != nil,
+		"hasAudio": p.audio != nil,
+	}
+	if p.video != nil {
+		meta["videocodecid"] = av.CODEC_AVC
+		if p.video.streamType == STREAM_TYPE_H265 {
+			meta["videocodecid"] = av.CODEC_HEVC
+		}
+	}
+	if p.audio != nil {
+		meta["audiocodecid"] = 10
+	}
+	data, err := amf0.Encode("onMetaData", meta)
+	if err == nil {
+		d.fn(p.number, av.MetaPack(0, data))
+	}
+}
+
+func (d *Demuxer) pes(s *pesStream, payload []byte, pusi bool) {
+	if pusi {
+		d.flush(s)
+		s.buf = append(s.buf[:0], payload...)
+		s.started = true
+		s.length = 0
+		if len(s.buf) >= 6 {
+			if n := int(binary.BigEndian.Uint16(s.buf[4:])); n > 0 {
+				s.length = 6 + n
+			}
+		}
+	} else if s.started {
+		s.buf = append(s.buf, payload...)
+	}
+	if s.started && s.length > 0 && len(s.buf) >= s.length {
+		d.flush(s)
+	}
+}
+
+// 解析组装好的PES
+func (d *Demuxer) flush(s *pesStream) {
+	if !s.started {
+		return
+	}
+	s.started = false
+	b := s.buf
+	if s.length > 0 && len(b) > s.length {
+		b = b[:s.length]
+	}
+	if len(b) < 9 || b[0] != 0 || b[1] != 0 || b[2] != 1 {
+		return
+	}
+	flags := b[7]
+	data := 9 + int(b[8])
+	if flags&0x80 == 0 || len(b) < 14 || data > len(b) {
+		// 没有PTS
+		return
+	}
+	pts := timestamp(b[9:])
+	dts := pts
+	if flags&0xC0 == 0xC0 && len(b) >= 19 {
+		dts = timestamp(b[14:])
+	}
+	if s.program.video == s {
+		d.video(s, pts, dts, b[data:])
+	} else {
+		d.audio(s, pts, b[data:])
+	}
+}
+
+func timestamp(b []byte) int64 {
+	return int64(b[0]>>1&0x07)<<30 | int64(binary.BigEndian.Uint16(b[1:])>>1)<<15 | int64(binary.BigEndian.Uint16(b[3:])>>1)
+}
+
+// 转换为从节目开始的毫秒数，早于第一个时间戳的返回0
+func (p *program) ms(ts int64) uint32 {
+	if !p.started {
+		p.started = true
+		p.base, p.last = ts, ts
+	}
+	delta := (ts - p.last) & timestampMask
+	if delta >= 1<<32 {
+		delta -= 1 << 33
+	}
+	ts = p.last + delta
+	p.last = ts
+	if ts < p.base {
+		return 0
+	}
+	return uint32((ts - p.base) / clockRate)
+}
+
+func (d *Demuxer) video(s *pesStream, pts, dts int64, data []byte) {
+	hevc := s.streamType == STREAM_TYPE_H265
+	var frame []byte
+	key := false
+	for _, nalu := range splitAnnexB(data) {
+		if hevc {
+			switch typ := nalu[0] >> 1 & 0x3F; {
+			case typ == 32:
+				s.vps = append(s.vps[:0], nalu...)
+				continue
+			case typ == 33:
+				s.sps = append(s.sps[:0], nalu...)
+				continue
+			case typ == 34:
+				s.pps = append(s.pps[:0], nalu...)
+				continue
+			case typ == 35: // AUD
+				continue
+			case typ >= 16 && typ <= 21: // IRAP
+				key = true
+			}
+		} else {
+			switch nalu[0] & 0x1F {
+			case 7:
+				s.sps = append(s.sps[:0], nalu...)
+				continue
+			case 8:
+				s.pps = append(s.pps[:0], nalu...)
+				continue
+			case 9: // AUD
+				continue
+			case 5:
+				key = true
+			}
+		}
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(nalu)))
+		frame = append(append(frame, size[:]...), nalu...)
+	}
+
+	codec := byte(av.CODEC_AVC)
+	if hevc {
+		codec = av.CODEC_HEVC
+	}
+	ms := s.program.ms(dts)
+	var config []byte
+	if hevc {
+		config = hvcC(s.vps, s.sps, s.pps)
+	} else {
+		config = avcC(s.sps, s.pps)
+	}
+	if config != nil && !bytes.Equal(config, s.config) {
+		s.config = config
+		d.fn(s.program.number, av.VideoPack(ms, append([]byte{0x10 | codec, 0, 0, 0, 0}, config...)))
+	}
+	if len(frame) == 0 || s.config == nil {
+		return
+	}
+
+	frameType := byte(0x20)
+	if key {
+		frameType = 0x10
+	}
+	cts := int32((pts - dts) / clockRate)
+	payload := append([]byte{frameType | codec, 1, byte(cts >> 16), byte(cts >> 8), byte(cts)}, frame...)
+	d.fn(s.program.number, av.VideoPack(ms, payload))
+}
+
+// 按起始码00 00 01分割Annex-B格式的数据
+func splitAnnexB(b []byte) [][]byte {
+	var nalus [][]byte
+	start := -1
+	add := func(nalu []byte) {
+		// 4字节的起始码和cabac_zero_word留下的0
+		for len(nalu) > 0 && nalu[len(nalu)-1] == 0 {
+			nalu = nalu[:len(nalu)-1]
+		}
+		if len(nalu) > 0 {
+			nalus = append(nalus, nalu)
+		}
+	}
+	for i := 0; i+2 < len(b); {
+		if b[i] == 0 && b[i+1] == 0 && b[i+2] == 1 {
+			if start >= 0 {
+				add(b[start:i])
+			}
+			i += 3
+			start = i
+			continue
+		}
+		i++
+	}
+	if start >= 0 && start < len(b) {
+		add(b[start:])
+	}
+	return nalus
+}
+
+// AVCDecoderConfigurationRecord
+func avcC(sps, pps []byte) []byte {
+	if len(sps) < 4 || len(pps) == 0 {
+		return nil
+	}
+	b := []byte{1, sps[1], sps[2], sps[3], 0xFF, 0xE1, byte(len(sps) >> 8), byte(len(sps))}
+	b = append(b, sps...)
+	b = append(b, 1, byte(len(pps)>>8), byte(len(pps)))
+	return append(b, pps...)
+}
+
+// HEVCDecoderConfigurationRecord，profile_tier_level、色度格式和位深取自SPS
+func hvcC(vps, sps, pps []byte) []byte {
+	if len(vps) == 0 || len(pps) == 0 {
+		return nil
+	}
+	info, err := codec.ParseH265SPS(sps)
+	rbsp := codec.UnescapeRBSP(sps)
+	// nal header(2) + vps_id/max_sub_layers/temporal_id_nesting(1) + general_profile_tier_level(12)
+	if err != nil || len(rbsp) < 15 {
+		return nil
+	}
+	subLayers := rbsp[2] >> 1 & 0x07
+	nesting := rbsp[2] & 0x01
+	b := []byte{1}
+	b = append(b, rbsp[3:15]...)
+	b = append(b, 0xF0, 0, 0xFC, 0xFC|info.ChromaFormat, 0xF8|(info.BitDepth-8), 0xF8|(info.BitDepth-8), 0, 0)
+	b = append(b, (subLayers+1)<<3|nesting<<2|3, 3)
+	for _, nalu := range [][]byte{vps, sps, pps} {
+		b = append(b, 0x80|nalu[0]>>1&0x3F, 0, 1, byte(len(nalu)>>8), byte(len(nalu)))
+		b = append(b, nalu...)
+	}
+	return b
+}
+
+// 一个PES中可能有多个ADTS帧
+func (d *Demuxer) audio(s *pesStream, pts int64, data []byte) {
+	for i := int64(0); len(data) > 0; i++ {
+		asc, frame, length, err := codec.ParseADTS(data)
+		if err != nil {
+			return
+		}
+		ms := s.program.ms(pts + i*int64(asc.FrameLength)*clockRate*1000/int64(asc.SampleRate))
+		if config := asc.Bytes(); !bytes.Equal(config, s.config) {
+			s.config = config
+			d.fn(s.program.number, av.AudioPack(ms, append([]byte{0xAF, 0}, config...)))
+		}
+		d.fn(s.program.number, av.AudioPack(ms, append([]byte{0xAF, 1}, frame...)))
+		data = data[length:]
+	}
+}
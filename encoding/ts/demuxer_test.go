@@ -0,0 +1,150 @@
+package ts
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/chenyj/rtmp/encoding/av"
+)
+
+// 把PES或PSI分割为TS包，最后一个包用adaptation field填充
+func packets(pid uint16, cc *int, payload []byte, psi bool) [][]byte {
+	if psi {
+		payload = append([]byte{0}, payload...) // pointer field
+	}
+	var out [][]byte
+	for first := true; len(payload) > 0; first = false {
+		b := []byte{0x47, byte(pid >> 8), byte(pid), 0x10 | byte(*cc&0x0F)}
+		if first {
+			b[1] |= 0x40
+		}
+		*cc++
+		n := len(payload)
+		if n > 184 {
+			n = 184
+		}
+		if pad := 184 - n; pad > 0 {
+			b[3] |= 0x20
+			b = append(b, byte(pad-1))
+			if pad > 1 {
+				b = append(b, 0)
+				b = append(b, bytes.Repeat([]byte{0xFF}, pad-2)...)
+			}
+		}
+		out = append(out, append(b, payload[:n]...))
+		payload = payload[n:]
+	}
+	return out
+}
+
+func pesTimestamp(prefix byte, ts int64) []byte {
+	return []byte{prefix<<4 | byte(ts>>29)&0x0E | 1, byte(ts >> 22), byte(ts>>14) | 1, byte(ts >> 7), byte(ts<<1) | 1}
+}
+
+func pes(streamID byte, pts, dts int64, data []byte) []byte {
+	b := []byte{0, 0, 1, streamID, 0, 0, 0x80, 0xC0, 10}
+	b = append(b, pesTimestamp(3, pts)...)
+	b = append(b, pesTimestamp(1, dts)...)
+	return append(b, data...)
+}
+
+func annexB(nalus ...[]byte) []byte {
+	var b []byte
+	for _, n := range nalus {
+		b = append(append(b, 0, 0, 0, 1), n...)
+	}
+	return b
+}
+
+// PAT、PMT(H.264 0x100)和3帧视频，关键帧跨3个TS包，第二帧跨2个TS包
+func testPackets() [][]byte {
+	var patCC, pmtCC, vCC int
+	pat := []byte{0x00, 0xB0, 13, 0, 1, 0xC1, 0, 0, 0, 1, 0xF0, 0x00, 0, 0, 0, 0}
+	pmt := []byte{0x02, 0xB0, 18, 0, 1, 0xC1, 0, 0, 0xE1, 0x00, 0xF0, 0,
+		0x1B, 0xE1, 0x00, 0xF0, 0,
+		0, 0, 0, 0}
+	sps := []byte{0x67, 0x64, 0, 0x1F, 0xAC}
+	pps := []byte{0x68, 0xEE, 0x3C, 0x80}
+	idr := append([]byte{0x65}, bytes.Repeat([]byte{0x88}, 400)...)
+
+	var out [][]byte
+	out = append(out, packets(0, &patCC, pat, true)...)
+	out = append(out, packets(0x1000, &pmtCC, pmt, true)...)
+	out = append(out, packets(0x100, &vCC, pes(0xE0, 3600, 0, annexB(sps, pps, idr)), false)...)
+	out = append(out, packets(0x100, &vCC, pes(0xE0, 7200, 3600, annexB(append([]byte{0x41}, bytes.Repeat([]byte{1}, 300)...))), false)...)
+	out = append(out, packets(0x100, &vCC, pes(0xE0, 10800, 7200, annexB([]byte{0x41, 2})), false)...)
+	return out
+}
+
+// 解析TS包，返回视频数据帧
+func demux(t *testing.T, pkts [][]byte) (meta *av.Packet, frames []*av.Packet) {
+	d := NewDemuxer(func(program uint16, p *av.Packet) {
+		if program != 1 {
+			t.Fatalf("program %d, expect 1", program)
+		}
+		switch {
+		case p.IsMeta():
+			meta = p
+		case p.IsVideo() && !p.IsConfig:
+			frames = append(frames, p)
+		}
+	})
+	// 不按188字节对齐写入，开头有需要重新同步的垃圾数据
+	data := append([]byte{0x00, 0x12}, bytes.Join(pkts, nil)...)
+	for len(data) > 0 {
+		n := 100
+		if n > len(data) {
+			n = len(data)
+		}
+		d.Write(data[:n])
+		data = data[n:]
+	}
+	d.Flush()
+	return
+}
+
+func TestDemuxer(t *testing.T) {
+	meta, frames := demux(t, testPackets())
+	if meta == nil {
+		t.Fatal("no onMetaData")
+	}
+	if len(frames) != 3 {
+		t.Fatalf("%d frames, expect 3", len(frames))
+	}
+	cts, frame := frames[0].VideoData()
+	if !frames[0].IsKeyFrame || cts != 40 || len(frame) != 4+401 {
+		t.Fatalf("key frame: cts %d size %d", cts, len(frame))
+	}
+	for i, p := range frames {
+		if p.Timestamp != uint32(i*40) {
+			t.Fatalf("frame %d: ts %d", i, p.Timestamp)
+		}
+	}
+}
+
+func TestDemuxerContinuity(t *testing.T) {
+	pkts := testPackets()
+	// pkts[2:5]是关键帧的TS包，pkts[5:7]是第二帧的TS包
+
+	// 重复的TS包被丢弃，PES仍然完整
+	dup := append(append(append([][]byte{}, pkts[:4]...), pkts[3]), pkts[4:]...)
+	if _, frames := demux(t, dup); len(frames) != 3 || len(frames[0].Payload) != 5+4+401 {
+		t.Fatalf("duplicate packet: %d frames", len(frames))
+	}
+
+	// 丢包时丢弃不完整的PES
+	lost := append(append([][]byte{}, pkts[:6]...), pkts[7:]...)
+	if _, frames := demux(t, lost); len(frames) != 2 || frames[1].Timestamp != 80 {
+		t.Fatalf("lost packet: %d frames", len(frames))
+	}
+
+	// discontinuity_indicator之后重新计数
+	disc := append([][]byte{}, pkts...)
+	last := append([]byte{}, disc[len(disc)-1]...)
+	last[3] = last[3]&0xF0 | 0x09
+	last[5] |= 0x80
+	disc[len(disc)-1] = last
+	if _, frames := demux(t, disc); len(frames) != 3 {
+		t.Fatalf("discontinuity: %d frames", len(frames))
+	}
+}
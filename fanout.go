@@ -0,0 +1,126 @@
+package rtmp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/chenyj/rtmp/encoding/av"
+)
+
+var errOverwritten = errors.New("rtmp: packet has been overwritten")
+
+// 一个槽位中的数据，写入后不再修改
+type slot struct {
+	sequence   uint64
+	generation uint32 // 写入时的配置帧版本
+	packet     *av.Packet
+	err        error // 流事件，读到时返回给读者
+}
+
+// fanout 单写多读的数据包缓存。
+//
+// 每个数据包按写入顺序编号，写入第n个包时覆盖n%size处的槽位。
+// 读者只持有自己的编号，通过原子操作读取槽位，互不影响；
+// 读者追上写者时在notify上等待，写者每次写入后关闭notify唤醒所有读者。
+type fanout struct {
+	slots  []unsafe.Pointer // *slot
+	size   uint64
+	head   uint64 // 下一个写入的编号
+	mu     sync.Mutex
+	notify chan struct{} // 有读者等待时才创建
+}
+
+func newFanout(size int) *fanout {
+	if size <= 0 {
+		size = 1
+	}
+	return &fanout{
+		slots: make([]unsafe.Pointer, size),
+		size:  uint64(size),
+	}
+}
+
+// write 写入一个数据包，返回它的编号。只允许一个写者。
+func (f *fanout) write(p *av.Packet, generation uint32) uint64 {
+	return f.put(&slot{generation: generation, packet: p})
+}
+
+// writeErr 写入一个事件，读者读到时返回err
+func (f *fanout) writeErr(err error) uint64 {
+	return f.put(&slot{err: err})
+}
+
+func (f *fanout) put(s *slot) uint64 {
+	seq := atomic.LoadUint64(&f.head)
+	s.sequence = seq
+	atomic.StorePointer(&f.slots[seq%f.size], unsafe.Pointer(s))
+	atomic.StoreUint64(&f.head, seq+1)
+
+	f.mu.Lock()
+	if f.notify != nil {
+		close(f.notify)
+		f.notify = nil
+	}
+	f.mu.Unlock()
+	return seq
+}
+
+// next 返回下一个写入的编号
+func (f *fanout) next() uint64 {
+	return atomic.LoadUint64(&f.head)
+}
+
+// oldest 返回缓存中最旧数据包的编号
+func (f *fanout) oldest() uint64 {
+	head := f.next()
+	if head < f.size {
+		return 0
+	}
+	return head - f.size
+}
+
+// get 非阻塞读取编号为seq的槽位。
+// 数据还未写入时返回nil，数据已被覆盖时返回errOverwritten。
+func (f *fanout) get(seq uint64) (*slot, error) {
+	if seq >= f.next() {
+		return nil, nil
+	}
+	s := (*slot)(atomic.LoadPointer(&f.slots[seq%f.size]))
+	if s == nil || s.sequence != seq {
+		return nil, errOverwritten
+	}
+	return s, nil
+}
+
+// read 读取编号为seq的槽位，数据未写入时阻塞直到写入或ctx结束
+func (f *fanout) read(ctx context.Context, seq uint64) (*slot, error) {
+	for {
+		s, err := f.get(seq)
+		if s != nil || err != nil {
+			return s, err
+		}
+		ch := f.wait()
+		// 获取notify后再检查一次，避免错过写入
+		if seq < f.next() {
+			continue
+		}
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (f *fanout) wait() <-chan struct{} {
+	f.mu.Lock()
+	if f.notify == nil {
+		f.notify = make(chan struct{})
+	}
+	ch := f.notify
+	f.mu.Unlock()
+	return ch
+}
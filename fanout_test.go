@@ -0,0 +1,90 @@
+package rtmp
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/chenyj/rtmp/encoding/av"
+)
+
+func TestFanoutStress(t *testing.T) {
+	const (
+		packets     = 2000
+		subscribers = 1000
+	)
+	s := NewStream(packets + 1)
+	writeConfig(s)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, subscribers)
+	for n := 0; n < subscribers; n++ {
+		it := s.Iterator()
+		wg.Add(1)
+		go func(it Iterator) {
+			defer wg.Done()
+			defer it.Release()
+			last := -1
+			err := it.Do(context.Background(), func(p *av.Packet) error {
+				if p.IsConfig || p.IsMeta() {
+					return nil
+				}
+				if last >= 0 && int(p.Timestamp) != last+1 {
+					t.Errorf("packet out of order: %d after %d", p.Timestamp, last)
+				}
+				last = int(p.Timestamp)
+				return nil
+			})
+			errs <- err
+		}(it)
+	}
+	for i := 0; i < packets; i++ {
+		s.Write(testPacket(i))
+	}
+	s.Write(nil)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err == nil {
+			t.Fatal("expect io.EOF at the end of stream")
+		}
+	}
+	if n := s.Subscribs(); n != 0 {
+		t.Fatalf("subscribers %d after release", n)
+	}
+}
+
+func benchmarkFanout(b *testing.B, subscribers int) {
+	f := newFanout(4096)
+	var wg sync.WaitGroup
+	for n := 0; n < subscribers; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var seq uint64
+			for {
+				s, err := f.read(context.Background(), seq)
+				if err == errOverwritten {
+					seq = f.next() - 1
+					continue
+				}
+				if err != nil || s.err != nil {
+					return
+				}
+				seq++
+			}
+		}()
+	}
+	p := testPacket(1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f.write(p, 0)
+	}
+	f.writeErr(io.EOF)
+	wg.Wait()
+}
+
+func BenchmarkFanout1(b *testing.B)    { benchmarkFanout(b, 1) }
+func BenchmarkFanout100(b *testing.B)  { benchmarkFanout(b, 100) }
+func BenchmarkFanout1000(b *testing.B) { benchmarkFanout(b, 1000) }
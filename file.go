@@ -0,0 +1,279 @@
+package rtmp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chenyj/rtmp/encoding/av"
+	"github.com/chenyj/rtmp/encoding/flv"
+	"github.com/chenyj/rtmp/encoding/mp4"
+)
+
+var ErrSeekFailed = errors.New("rtmp: no key frame to seek")
+
+// 文件播放结束，PlaySession据此发送NetStream.Play.Complete
+var errFileEnd = &StreamEvent{Type: STREAM_EOF, Reason: "end of file"}
+
+// 按实际速度发送时，提前发送的时长，让播放端有一些缓冲
+var realtimePreload = time.Second
+
+// 文件中的可定位点
+type keyFrame struct {
+	timestamp uint32
+	offset    int64
+}
+
+// 文件格式
+type fileSource interface {
+	ReadPacket() (*av.Packet, error) // 文件结束时返回io.EOF
+	Seek(ms uint32) (uint32, error)  // 定位到ms之前最近的关键帧
+	Headers() []*av.Packet           // meta和配置帧
+	Duration() uint32
+}
+
+// FileIterator 从FLV或MP4文件中读取数据包的Iterator，支持Seek。
+type FileIterator struct {
+	f        *os.File
+	src      fileSource
+	pending  []*av.Packet // Seek后待发送的配置帧
+	realtime bool         // 是否按时间戳的速度发送
+	end      uint32       // 超过这个时间戳后结束，0表示播放到文件结束
+}
+
+// NewFileIterator 打开文件，扩展名为.mp4/.m4v/.m4a/.mov时按MP4读取，否则按FLV读取
+func NewFileIterator(name string) (*FileIterator, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	var src fileSource
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".mp4", ".m4v", ".m4a", ".mov":
+		src, err = mp4.NewDemuxer(f)
+	default:
+		src, err = newFLVSource(f)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &FileIterator{f: f, src: src}, nil
+}
+
+// FLV文件，打开时扫描一遍，记录配置帧、关键帧位置和时长
+type flvSource struct {
+	r        *flv.TagReader
+	start    int64        // 第一个Tag的偏移
+	configs  []*av.Packet // meta, video config, audio config
+	index    []keyFrame   // 关键帧索引
+	duration uint32       // 时长，毫秒
+}
+
+func newFLVSource(r io.ReadSeeker) (*flvSource, error) {
+	src := &flvSource{r: flv.NewTagReader(r)}
+	if err := src.scan(); err != nil {
+		return nil, err
+	}
+	return src, nil
+}
+
+// 扫描文件，建立关键帧索引
+func (src *flvSource) scan() error {
+	h, err := src.r.ReadFlvHeader()
+	if err != nil {
+		return err
+	}
+	src.start = src.r.Offset()
+	var meta, audio0, video0 *av.Packet
+	for {
+		offset := src.r.Offset()
+		p, err := src.ReadPacket()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if p == nil {
+			continue
+		}
+		switch {
+		case p.IsMeta():
+			if meta == nil {
+				meta = p
+			}
+		case p.IsConfig && p.IsAudio():
+			if audio0 == nil {
+				audio0 = p
+			}
+		case p.IsConfig && p.IsVideo():
+			if video0 == nil {
+				video0 = p
+			}
+		case p.IsKeyFrame || (!h.HasVideo && p.IsAudio()):
+			// 纯音频文件的每个音频帧都可以定位
+			src.index = append(src.index, keyFrame{p.Timestamp, offset})
+		}
+		if p.Timestamp > src.duration {
+			src.duration = p.Timestamp
+		}
+	}
+	for _, p := range []*av.Packet{meta, video0, audio0} {
+		if p != nil {
+			src.configs = append(src.configs, p)
+		}
+	}
+	return src.r.SeekTag(src.start)
+}
+
+// 读取下一个Tag，不认识的Tag返回nil
+func (src *flvSource) ReadPacket() (*av.Packet, error) {
+	h, data, err := src.r.ReadTag()
+	if err != nil {
+		return nil, err
+	}
+	return tagPacket(h, data), nil
+}
+
+// FLV Tag转换为数据包，不认识的Tag返回nil
+func tagPacket(h flv.FlvTagHeader, data []byte) *av.Packet {
+	switch h.TagType {
+	case flv.FLV_TAG_AUDIO:
+		return av.AudioPack(h.Timestamp, data)
+	case flv.FLV_TAG_VIDEO:
+		return av.VideoPack(h.Timestamp, data)
+	case flv.FLV_TAG_DATA:
+		return av.MetaPack(h.Timestamp, data)
+	}
+	return nil
+}
+
+func (src *flvSource) Headers() []*av.Packet {
+	return src.configs
+}
+
+func (src *flvSource) Duration() uint32 {
+	return src.duration
+}
+
+func (src *flvSource) Seek(ms uint32) (uint32, error) {
+	if len(src.index) == 0 {
+		return 0, ErrSeekFailed
+	}
+	k := src.index[0]
+	for _, kf := range src.index[1:] {
+		if kf.timestamp > ms {
+			break
+		}
+		k = kf
+	}
+	if err := src.r.SeekTag(k.offset); err != nil {
+		return 0, err
+	}
+	return k.timestamp, nil
+}
+
+// Duration 返回文件时长，毫秒
+func (it *FileIterator) Duration() uint32 {
+	return it.src.Duration()
+}
+
+// SetRealtime 设置是否按时间戳的速度发送，默认尽快读取
+func (it *FileIterator) SetRealtime(realtime bool) {
+	it.realtime = realtime
+}
+
+// SetEnd 读到时间戳超过ms的数据包时结束，0表示读到文件结束
+func (it *FileIterator) SetEnd(ms uint32) {
+	it.end = ms
+}
+
+// Next 返回下一个数据包，文件结束时返回STREAM_EOF事件
+func (it *FileIterator) Next() (*av.Packet, error) {
+	if len(it.pending) > 0 {
+		p := it.pending[0]
+		it.pending = it.pending[1:]
+		return p, nil
+	}
+	for {
+		p, err := it.src.ReadPacket()
+		if err == io.EOF {
+			return nil, errFileEnd
+		}
+		if err != nil {
+			return nil, err
+		}
+		if p == nil {
+			continue
+		}
+		if it.end > 0 && p.Timestamp > it.end && !p.IsConfig && !p.IsMeta() {
+			return nil, errFileEnd
+		}
+		return p, nil
+	}
+}
+
+// Do 依次发送数据包，直到出错、文件结束或ctx结束。
+// 按实际速度发送时，每次调用都从第一个数据包开始重新计时，所以暂停后可以直接继续。
+func (it *FileIterator) Do(ctx context.Context, fn func(*av.Packet) error) error {
+	var start time.Time
+	var base uint32
+	for ctx.Err() == nil {
+		p, err := it.Next()
+		if err != nil {
+			return err
+		}
+		if it.realtime && !p.IsConfig && !p.IsMeta() {
+			if start.IsZero() || p.Timestamp < base {
+				start, base = time.Now(), p.Timestamp
+			}
+			wait := time.Duration(p.Timestamp-base)*time.Millisecond - realtimePreload - time.Since(start)
+			if wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+					// 下次从这个数据包继续
+					it.pending = append([]*av.Packet{p}, it.pending...)
+					return nil
+				}
+			}
+		}
+		if err = fn(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Seek 定位到ms之前最近的关键帧，并重新发送配置帧，返回关键帧的时间戳
+func (it *FileIterator) Seek(ms uint32) (uint32, error) {
+	ts, err := it.src.Seek(ms)
+	if err != nil {
+		return 0, err
+	}
+	it.pending = append(it.pending[:0], it.src.Headers()...)
+	return ts, nil
+}
+
+// Release 关闭文件
+func (it *FileIterator) Release() {
+	it.f.Close()
+}
+
+// 文件不会被覆盖，不需要丢帧
+func (it *FileIterator) SetPolicy(SlowPolicy, func(dropped uint64)) {}
+
+func (it *FileIterator) Dropped() uint64 {
+	return 0
+}
+
+func (it *FileIterator) Generation() uint32 {
+	return 0
+}
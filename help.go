@@ -1,6 +1,12 @@
 package rtmp
 
-import "os"
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+
+	"github.com/chenyj/rtmp/encoding/amf0"
+)
 
 func write(bs []byte, filename string) {
 	f, err := os.OpenFile(filename, os.O_RDWR|os.O_TRUNC|os.O_CREATE, os.ModePerm)
@@ -10,3 +16,15 @@ func write(bs []byte, filename string) {
 	f.Write(bs)
 	Log("write \"%s\" done: %d bytes", filename, len(bs))
 }
+
+// 将AMF0数据转换为带类型的JSON写入文件，便于查看和手写测试数据
+func dumpJSON(payload []byte, filename string) {
+	js, err := amf0.ToJSON(payload)
+	if err != nil {
+		Log("dump \"%s\" error: %v", filename, err)
+		return
+	}
+	var buf bytes.Buffer
+	json.Indent(&buf, js, "", "  ")
+	write(buf.Bytes(), filename)
+}
@@ -0,0 +1,111 @@
+package rtmp
+
+import (
+	"github.com/chenyj/rtmp/encoding/av"
+	"github.com/chenyj/rtmp/encoding/codec"
+)
+
+// MediaInfo 从配置帧中解析的音视频参数，不依赖发布者的onMetaData。
+// 没有收到配置帧或无法解析时为零值。
+type MediaInfo struct {
+	VideoCodec   string  // avc1, hvc1
+	Width        int     // 裁剪后的宽度
+	Height       int     // 裁剪后的高度
+	Profile      uint8   // profile_idc
+	Level        uint8   // level_idc
+	FrameRate    float64 // SPS的VUI中的帧率，没有时为0
+	ChromaFormat uint8   // 0: 单色，1: 4:2:0，2: 4:2:2，3: 4:4:4
+	BitDepth     uint8
+
+	AudioCodec      string // aac, mp3, speex等
+	SampleRate      int    // 解码后的采样率，HE-AAC为SBR的采样率
+	Channels        int
+	AudioObjectType uint8 // AAC的audioObjectType，HE-AAC为5，HE-AACv2为29
+}
+
+// FLV的音频格式
+var soundFormats = map[byte]string{
+	0:  "pcm",
+	1:  "adpcm",
+	2:  "mp3",
+	3:  "pcm",
+	4:  "nellymoser",
+	5:  "nellymoser",
+	6:  "nellymoser",
+	7:  "pcma",
+	8:  "pcmu",
+	11: "speex",
+}
+
+// 用视频配置帧更新视频参数
+func (m *MediaInfo) setVideo(p *av.Packet) {
+	_, record := p.VideoData()
+	if len(record) == 0 {
+		return
+	}
+	var sps codec.SPSInfo
+	var err error
+	c := p.VideoCodec()
+	switch c {
+	case "avc1":
+		sps, err = codec.ParseAVCDecoderConfig(record)
+	case "hvc1":
+		sps, err = codec.ParseHEVCDecoderConfig(record)
+	default:
+		return
+	}
+	if err != nil {
+		Warn("rtmp: parse %s config: %v", c, err)
+		return
+	}
+	m.VideoCodec = c
+	m.Width, m.Height = sps.Width, sps.Height
+	m.Profile, m.Level = sps.Profile, sps.Level
+	m.FrameRate = sps.FrameRate
+	m.ChromaFormat, m.BitDepth = sps.ChromaFormat, sps.BitDepth
+}
+
+// 用AAC配置帧或其它格式的音频帧更新音频参数
+func (m *MediaInfo) setAudio(p *av.Packet) {
+	if len(p.Payload) == 0 {
+		return
+	}
+	format := p.Payload[0] >> 4
+	if format == 10 {
+		if !p.IsConfig || len(p.Payload) < 3 {
+			return
+		}
+		asc, err := codec.ParseAudioSpecificConfig(p.Payload[2:])
+		if err != nil {
+			Warn("rtmp: parse aac config: %v", err)
+			return
+		}
+		m.AudioCodec = "aac"
+		m.SampleRate, m.Channels = asc.OutputSampleRate(), asc.Channels
+		switch {
+		case asc.PS:
+			m.AudioObjectType = codec.AAC_PS
+		case asc.SBR:
+			m.AudioObjectType = codec.AAC_SBR
+		default:
+			m.AudioObjectType = asc.ObjectType
+		}
+		return
+	}
+
+	name, ok := soundFormats[format]
+	if !ok {
+		return
+	}
+	m.AudioCodec, m.AudioObjectType = name, 0
+	m.Channels = 1 + int(p.Payload[0]&0x01)
+	// 部分格式的采样率是固定的
+	switch format {
+	case 4, 11:
+		m.SampleRate = 16000
+	case 5, 7, 8:
+		m.SampleRate = 8000
+	default:
+		m.SampleRate = [4]int{5512, 11025, 22050, 44100}[p.Payload[0]>>2&0x03]
+	}
+}
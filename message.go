@@ -41,10 +41,25 @@ const (
 	PING_RESPONSE                 //7
 )
 
+// shared object message's event type
+const (
+	SO_USE            = iota + 1 //1
+	SO_RELEASE                   //2
+	SO_REQUEST_CHANGE            //3
+	SO_CHANGE                    //4
+	SO_SUCCESS                   //5
+	SO_SEND_MESSAGE              //6
+	SO_STATUS                    //7
+	SO_CLEAR                     //8
+	SO_REMOVE                    //9
+	SO_REQUEST_REMOVE            //10
+	SO_USE_SUCCESS               //11
+)
+
 // rtmp command
 const (
 	RSP_RESULT            = "_result"
-	RSP_ERROR             = "error"
+	RSP_ERROR             = "_error"
 	RSP_ON_STATUS         = "onStatus"
 	CMD_CONNECT           = "connect"
 	CMD_CALL              = "call"
@@ -356,6 +371,214 @@ func (m *CommandMessage) Unmarshal(bs []byte) error {
 	return amf0.Unmarshal(bs, m)
 }
 
+// data message，如onMetaData、onPlayStatus
+type DataMessage struct {
+	Name      string
+	timestamp uint32
+	arr       []any
+}
+
+func (m DataMessage) Tid() uint8 {
+	return DATA_AMF0
+}
+
+func (m DataMessage) Timestamp() uint32 {
+	return m.timestamp
+}
+
+func (m DataMessage) Marshal() ([]byte, error) {
+	return amf0.Encode(append([]any{m.Name}, m.arr...)...)
+}
+
+// Shared Object Message
+//
+// +-------------+---------+-----------------+----------+-------+-----+
+// | Name Length |  Name   | Current Version |  Flags   | Event | ... |
+// |  (2 bytes)  |         |    (4 bytes)    | (8 bytes)|       |     |
+// +-------------+---------+-----------------+----------+-------+-----+
+//
+// 每个事件是1字节的类型、4字节的长度和事件数据。
+// AMF3的消息开头多一个字节0，之后和AMF0相同，值可以通过AMF0的切换标记使用AMF3
+type SharedObjectMessage struct {
+	Name       string
+	Version    uint32
+	Persistent bool
+	Events     []SharedObjectEvent
+	AMF3       bool // 使用AMF3的消息类型
+}
+
+// 共享对象的事件，各类型使用的字段：
+//
+//	SO_REQUEST_CHANGE、SO_CHANGE  Name、Value
+//	SO_SUCCESS、SO_REMOVE、SO_REQUEST_REMOVE  Name
+//	SO_SEND_MESSAGE  Args，第一个是方法名
+//	SO_STATUS  Name为code，Value为level
+type SharedObjectEvent struct {
+	Type  uint8
+	Name  string
+	Value any
+	Args  []any
+}
+
+func (m SharedObjectMessage) Tid() uint8 {
+	if m.AMF3 {
+		return SHARED_OBJECT_AMF3
+	}
+	return SHARED_OBJECT_AMF0
+}
+
+func (m SharedObjectMessage) Timestamp() uint32 {
+	return 0
+}
+
+func (m SharedObjectMessage) Marshal() ([]byte, error) {
+	var bs []byte
+	if m.AMF3 {
+		bs = append(bs, 0)
+	}
+	bs, err := appendString(bs, m.Name)
+	if err != nil {
+		return nil, err
+	}
+	var flags uint32
+	if m.Persistent {
+		flags = 2
+	}
+	bs = appendUint32(bs, m.Version)
+	bs = appendUint32(bs, flags)
+	bs = appendUint32(bs, 0)
+	for _, ev := range m.Events {
+		data, err := ev.marshal()
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > math.MaxUint32 {
+			return nil, ErrMsgLength
+		}
+		bs = append(bs, ev.Type)
+		bs = appendUint32(bs, uint32(len(data)))
+		bs = append(bs, data...)
+	}
+	return bs, nil
+}
+
+// Unmarshal 解析消息负载，m.AMF3需要先设置
+func (m *SharedObjectMessage) Unmarshal(bs []byte) (err error) {
+	if m.AMF3 {
+		if bs, err = amf3Payload(bs); err != nil {
+			return
+		}
+	}
+	if m.Name, bs, err = readString(bs); err != nil {
+		return
+	}
+	if len(bs) < 12 {
+		return ErrDataMissing
+	}
+	m.Version = binary.BigEndian.Uint32(bs)
+	m.Persistent = binary.BigEndian.Uint32(bs[4:]) == 2
+	bs = bs[12:]
+	m.Events = m.Events[:0]
+	for len(bs) > 0 {
+		if len(bs) < 5 {
+			return ErrDataMissing
+		}
+		ev := SharedObjectEvent{Type: bs[0]}
+		size := binary.BigEndian.Uint32(bs[1:])
+		bs = bs[5:]
+		if uint32(len(bs)) < size {
+			return ErrDataMissing
+		}
+		if err = ev.unmarshal(bs[:size]); err != nil {
+			return
+		}
+		m.Events = append(m.Events, ev)
+		bs = bs[size:]
+	}
+	return nil
+}
+
+func (ev SharedObjectEvent) marshal() (bs []byte, err error) {
+	switch ev.Type {
+	case SO_REQUEST_CHANGE, SO_CHANGE:
+		if bs, err = appendString(bs, ev.Name); err != nil {
+			return
+		}
+		var v []byte
+		if v, err = amf0.Encode(ev.Value); err != nil {
+			return
+		}
+		bs = append(bs, v...)
+	case SO_SUCCESS, SO_REMOVE, SO_REQUEST_REMOVE:
+		bs, err = appendString(bs, ev.Name)
+	case SO_SEND_MESSAGE:
+		bs, err = amf0.Encode(ev.Args...)
+	case SO_STATUS:
+		lvl, _ := ev.Value.(string)
+		if bs, err = appendString(bs, ev.Name); err != nil {
+			return
+		}
+		bs, err = appendString(bs, lvl)
+	}
+	return
+}
+
+func (ev *SharedObjectEvent) unmarshal(bs []byte) (err error) {
+	switch ev.Type {
+	case SO_REQUEST_CHANGE, SO_CHANGE:
+		if ev.Name, bs, err = readString(bs); err != nil {
+			return
+		}
+		var arr amf0.Amfarr
+		if arr, err = amf0.Decode(bs); err != nil {
+			return
+		}
+		if len(arr) > 0 {
+			ev.Value = arr[0]
+		}
+	case SO_SUCCESS, SO_REMOVE, SO_REQUEST_REMOVE:
+		ev.Name, _, err = readString(bs)
+	case SO_SEND_MESSAGE:
+		var arr amf0.Amfarr
+		if arr, err = amf0.Decode(bs); err != nil {
+			return
+		}
+		ev.Args = arr
+	case SO_STATUS:
+		var lvl string
+		if ev.Name, bs, err = readString(bs); err != nil {
+			return
+		}
+		lvl, _, err = readString(bs)
+		ev.Value = lvl
+	}
+	return
+}
+
+// 2字节长度的UTF-8字符串，没有AMF的类型标记
+func appendString(bs []byte, s string) ([]byte, error) {
+	if len(s) > math.MaxUint16 {
+		return nil, ErrMsgLength
+	}
+	bs = append(bs, byte(len(s)>>8), byte(len(s)))
+	return append(bs, s...), nil
+}
+
+func appendUint32(bs []byte, v uint32) []byte {
+	return append(bs, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func readString(bs []byte) (string, []byte, error) {
+	if len(bs) < 2 {
+		return "", nil, ErrDataMissing
+	}
+	size := int(binary.BigEndian.Uint16(bs))
+	if len(bs) < 2+size {
+		return "", nil, ErrDataMissing
+	}
+	return string(bs[2 : 2+size]), bs[2+size:], nil
+}
+
 var (
 	RespProp = respProp{"FMS/3,0,1,123", 15}
 )
@@ -371,9 +594,18 @@ type respInfo struct {
 	Desc  string `amf:"description"`
 }
 
+// connect的回复，多了objectEncoding
+type connectInfo struct {
+	Level          level   `amf:"level"`
+	Code           string  `amf:"code"`
+	Desc           string  `amf:"description"`
+	ObjectEncoding float64 `amf:"objectEncoding"`
+}
+
 type ConnectCommand struct {
-	App      string
-	Flashver string
-	SwfUrl   string
-	TcUrl    string
+	App            string
+	Flashver       string
+	SwfUrl         string
+	TcUrl          string
+	ObjectEncoding float64
 }
@@ -0,0 +1,151 @@
+package rtmp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/chenyj/rtmp/encoding/av"
+	"github.com/chenyj/rtmp/encoding/ts"
+)
+
+// TSIngest 接收UDP或TCP上的MPEG-TS，把每个节目发布为一个流，
+// RTMP订阅者可以直接播放。
+//
+//	ingest := &rtmp.TSIngest{Stream: func(source string, program uint16) rtmp.Streamer {
+//		return streams["tv"]
+//	}}
+//	go ingest.ListenAndServe("udp", ":1234")
+type TSIngest struct {
+	// Stream 返回来源中节目对应的流，返回nil时忽略该节目。
+	// source是UDP发送方或TCP连接的地址，第一次收到节目的数据时调用，之后调用Publish。
+	Stream func(source string, program uint16) Streamer
+	// UDP来源超过Timeout没有数据时停止发布，默认10秒
+	Timeout time.Duration
+}
+
+func (t *TSIngest) timeout() time.Duration {
+	if t.Timeout <= 0 {
+		return 10 * time.Second
+	}
+	return t.Timeout
+}
+
+// ListenAndServe 监听UDP或TCP地址
+func (t *TSIngest) ListenAndServe(network, addr string) error {
+	switch network {
+	case "udp", "udp4", "udp6":
+		conn, err := net.ListenPacket(network, addr)
+		if err != nil {
+			return err
+		}
+		return t.ServeUDP(conn)
+	case "tcp", "tcp4", "tcp6":
+		l, err := net.Listen(network, addr)
+		if err != nil {
+			return err
+		}
+		return t.Serve(l)
+	}
+	return errors.New("rtmp: unsupported network " + network)
+}
+
+// 一个TS来源和它发布的流
+type tsSource struct {
+	name    string
+	demuxer *ts.Demuxer
+	streams map[uint16]Streamer // nil表示忽略的节目
+	last    time.Time
+}
+
+func (t *TSIngest) newSource(name string) *tsSource {
+	src := &tsSource{name: name, streams: map[uint16]Streamer{}}
+	src.demuxer = ts.NewDemuxer(func(program uint16, p *av.Packet) {
+		s, ok := src.streams[program]
+		if !ok {
+			if t.Stream != nil {
+				s = t.Stream(name, program)
+			}
+			if s != nil {
+				s.Publish()
+			}
+			src.streams[program] = s
+		}
+		if s != nil {
+			s.Write(p)
+		}
+	})
+	return src
+}
+
+func (src *tsSource) close() {
+	src.demuxer.Flush()
+	for _, s := range src.streams {
+		if s != nil {
+			s.Unpublish()
+		}
+	}
+}
+
+// ServeUDP 接收UDP数据，每个发送方是一个来源，conn关闭时返回
+func (t *TSIngest) ServeUDP(conn net.PacketConn) error {
+	defer conn.Close()
+	sources := map[string]*tsSource{}
+	defer func() {
+		for _, src := range sources {
+			src.close()
+		}
+	}()
+
+	buf := make([]byte, 64<<10)
+	for {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, addr, err := conn.ReadFrom(buf)
+		now := time.Now()
+		if err != nil {
+			if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+				return err
+			}
+		} else {
+			name := addr.String()
+			src, ok := sources[name]
+			if !ok {
+				Log("mpegts: new udp source %s", name)
+				src = t.newSource(name)
+				sources[name] = src
+			}
+			src.last = now
+			src.demuxer.Write(buf[:n])
+		}
+
+		for name, src := range sources {
+			if now.Sub(src.last) > t.timeout() {
+				Log("mpegts: udp source %s timeout", name)
+				src.close()
+				delete(sources, name)
+			}
+		}
+	}
+}
+
+// Serve 接受TCP连接，每个连接是一个来源，连接断开时停止发布
+func (t *TSIngest) Serve(l net.Listener) error {
+	defer l.Close()
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go t.serveConn(c)
+	}
+}
+
+func (t *TSIngest) serveConn(c net.Conn) {
+	defer c.Close()
+	src := t.newSource(c.RemoteAddr().String())
+	defer src.close()
+	if _, err := io.Copy(src.demuxer, c); err != nil {
+		Warn("mpegts: %s: %v", src.name, err)
+	}
+}
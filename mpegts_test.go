@@ -0,0 +1,193 @@
+package rtmp
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/chenyj/rtmp/encoding/av"
+)
+
+// 把PES或PSI分割为TS包，最后一个包用adaptation field填充
+func tsPackets(pid uint16, cc *int, payload []byte, psi bool) []byte {
+	if psi {
+		payload = append([]byte{0}, payload...) // pointer field
+	}
+	var out []byte
+	for first := true; len(payload) > 0; first = false {
+		b := []byte{0x47, byte(pid >> 8), byte(pid), 0x10 | byte(*cc&0x0F)}
+		if first {
+			b[1] |= 0x40
+		}
+		*cc++
+		n := len(payload)
+		if n > 184 {
+			n = 184
+		}
+		if pad := 184 - n; pad > 0 {
+			b[3] |= 0x20
+			b = append(b, byte(pad-1))
+			if pad > 1 {
+				b = append(b, 0)
+				b = append(b, bytes.Repeat([]byte{0xFF}, pad-2)...)
+			}
+		}
+		out = append(append(out, b...), payload[:n]...)
+		payload = payload[n:]
+	}
+	return out
+}
+
+func pesTimestamp(prefix byte, ts int64) []byte {
+	return []byte{prefix<<4 | byte(ts>>29)&0x0E | 1, byte(ts >> 22), byte(ts>>14) | 1, byte(ts >> 7), byte(ts<<1) | 1}
+}
+
+func pes(streamID byte, pts, dts int64, data []byte, length bool) []byte {
+	b := []byte{0, 0, 1, streamID, 0, 0, 0x80, 0xC0, 10}
+	b = append(b, pesTimestamp(3, pts)...)
+	b = append(b, pesTimestamp(1, dts)...)
+	b = append(b, data...)
+	if length {
+		b[4], b[5] = byte((len(b)-6)>>8), byte(len(b)-6)
+	}
+	return b
+}
+
+func adts(data []byte) []byte {
+	n := 7 + len(data)
+	// AAC LC，44100Hz，双声道
+	h := []byte{0xFF, 0xF1, 0x50, 0x80 | byte(n>>11), byte(n >> 3), byte(n&0x07)<<5 | 0x1F, 0xFC}
+	return append(h, data...)
+}
+
+// PAT、PMT(H.264 0x100，AAC 0x101)，3帧视频和2帧音频
+func testTS() []byte {
+	var patCC, pmtCC, vCC, aCC int
+	pat := []byte{0x00, 0xB0, 13, 0, 1, 0xC1, 0, 0, 0, 1, 0xF0, 0x00, 0, 0, 0, 0}
+	pmt := []byte{0x02, 0xB0, 23, 0, 1, 0xC1, 0, 0, 0xE1, 0x00, 0xF0, 0,
+		0x1B, 0xE1, 0x00, 0xF0, 0,
+		0x0F, 0xE1, 0x01, 0xF0, 0,
+		0, 0, 0, 0}
+	sps := []byte{0x67, 0x64, 0, 0x1F, 0xAC}
+	pps := []byte{0x68, 0xEE, 0x3C, 0x80}
+	idr := append([]byte{0x65}, bytes.Repeat([]byte{0x88}, 400)...)
+	annexB := func(nalus ...[]byte) []byte {
+		var b []byte
+		for _, n := range nalus {
+			b = append(append(b, 0, 0, 0, 1), n...)
+		}
+		return b
+	}
+
+	const base = 900000
+	var b []byte
+	b = append(b, tsPackets(0, &patCC, pat, true)...)
+	b = append(b, tsPackets(0x1000, &pmtCC, pmt, true)...)
+	b = append(b, tsPackets(0x100, &vCC, pes(0xE0, base+3600, base, annexB([]byte{0x09, 0xF0}, sps, pps, idr), false), false)...)
+	b = append(b, tsPackets(0x100, &vCC, pes(0xE0, base+7200, base+3600, annexB([]byte{0x41, 1}), false), false)...)
+	b = append(b, tsPackets(0x101, &aCC, pes(0xC0, base, base, append(adts([]byte{0xA0}), adts([]byte{0xA1})...), true), false)...)
+	b = append(b, tsPackets(0x100, &vCC, pes(0xE0, base+10800, base+7200, annexB([]byte{0x41, 2}), false), false)...)
+	return b
+}
+
+func TestTSIngestUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewStream(100)
+	got := make(chan uint16, 1)
+	ingest := &TSIngest{Stream: func(source string, program uint16) Streamer {
+		got <- program
+		return s
+	}}
+	go ingest.ServeUDP(conn)
+	defer conn.Close()
+
+	c, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	data := testTS()
+	for len(data) > 0 {
+		n := 7 * 188
+		if n > len(data) {
+			n = len(data)
+		}
+		c.Write(data[:n])
+		data = data[n:]
+		time.Sleep(time.Millisecond)
+	}
+
+	select {
+	case program := <-got:
+		if program != 1 {
+			t.Fatalf("program %d, expect 1", program)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no program published")
+	}
+	for deadline := time.Now().Add(time.Second); ; {
+		if _, latest := s.TimeRange(); latest >= 40 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for packets")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !s.IsPublishing() {
+		t.Fatal("stream is not publishing")
+	}
+
+	var packets []*av.Packet
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	it := s.Iterator()
+	defer it.Release()
+	it.Do(ctx, func(p *av.Packet) error {
+		packets = append(packets, p)
+		if p.IsVideo() && p.Timestamp == 40 {
+			cancel()
+		}
+		return nil
+	})
+
+	var video, audio []*av.Packet
+	var avcC, asc []byte
+	for _, p := range packets {
+		switch {
+		case p.IsVideo() && p.IsConfig:
+			_, avcC = p.VideoData()
+		case p.IsAudio() && p.IsConfig:
+			asc = p.Payload[2:]
+		case p.IsVideo():
+			video = append(video, p)
+		case p.IsAudio():
+			audio = append(audio, p)
+		}
+	}
+	if !packets[0].IsMeta() {
+		t.Fatal("first packet is not onMetaData")
+	}
+	wantAVCC := []byte{1, 0x64, 0, 0x1F, 0xFF, 0xE1, 0, 5, 0x67, 0x64, 0, 0x1F, 0xAC, 1, 0, 4, 0x68, 0xEE, 0x3C, 0x80}
+	if !bytes.Equal(avcC, wantAVCC) || !bytes.Equal(asc, []byte{0x12, 0x10}) {
+		t.Fatalf("avcC % X, asc % X", avcC, asc)
+	}
+	if len(video) != 2 || len(audio) != 2 {
+		t.Fatalf("%d video, %d audio", len(video), len(audio))
+	}
+	cts, frame := video[0].VideoData()
+	if !video[0].IsKeyFrame || video[0].Timestamp != 0 || cts != 40 || len(frame) != 4+401 || frame[3] != 401-256 {
+		t.Fatalf("key frame: ts %d cts %d % X", video[0].Timestamp, cts, frame[:8])
+	}
+	if video[1].IsKeyFrame || video[1].Timestamp != 40 {
+		t.Fatalf("second frame: key %v ts %d", video[1].IsKeyFrame, video[1].Timestamp)
+	}
+	if audio[0].Timestamp != 0 || audio[1].Timestamp != 23 || audio[1].Payload[2] != 0xA1 {
+		t.Fatalf("audio: %d %d % X", audio[0].Timestamp, audio[1].Timestamp, audio[1].Payload)
+	}
+}
@@ -0,0 +1,190 @@
+package rtmp
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/chenyj/rtmp/encoding/av"
+	"github.com/chenyj/rtmp/encoding/flv"
+)
+
+// publish的类型
+const (
+	PUBLISH_LIVE   = "live"   // 只直播，不录制
+	PUBLISH_RECORD = "record" // 录制到新文件，已有的文件被覆盖
+	PUBLISH_APPEND = "append" // 追加到已有的文件，没有时创建
+)
+
+// RecordFile 录制的文件，追加时需要读取已有的内容
+type RecordFile interface {
+	io.ReadWriteSeeker
+	io.Closer
+	Truncate(size int64) error
+}
+
+// RecordStorage 录制文件的存储。
+// Server.Storage为nil时，publish的record和append按live处理
+type RecordStorage interface {
+	Create(app, name string) (RecordFile, error)     // 创建新文件，已存在时清空
+	OpenAppend(app, name string) (RecordFile, error) // 打开已有的文件，不存在时创建
+}
+
+// DirStorage 录制到Root/app/name.flv，可以用VOD{Root: Root + "/" + app}播放
+type DirStorage struct {
+	Root string
+}
+
+func (d DirStorage) Create(app, name string) (RecordFile, error) {
+	return d.open(app, name, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+}
+
+func (d DirStorage) OpenAppend(app, name string) (RecordFile, error) {
+	return d.open(app, name, os.O_RDWR|os.O_CREATE)
+}
+
+func (d DirStorage) open(app, name string, flag int) (RecordFile, error) {
+	path, err := rootFilename(d.Root, app+"/"+name)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, flag, 0644)
+}
+
+// Record 一次录制，把发布的数据包写入FLV文件。
+// 追加时新的数据包从已有内容最后的时间戳之后开始
+type Record struct {
+	f     RecordFile
+	w     *flv.TagWriter
+	base  uint32 // 第一个数据包的时间戳，追加时是已有内容最后的时间戳加1ms
+	first int64  // 第一个数据包的时间戳，-1表示还没有写入
+}
+
+// StartRecord 按mode(PUBLISH_RECORD或PUBLISH_APPEND)打开录制文件
+func StartRecord(s RecordStorage, app, name, mode string) (*Record, error) {
+	var f RecordFile
+	var err error
+	switch mode {
+	default:
+		return nil, errors.New("rtmp: invalid record mode: " + mode)
+	case PUBLISH_RECORD:
+		f, err = s.Create(app, name)
+	case PUBLISH_APPEND:
+		f, err = s.OpenAppend(app, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r := &Record{f: f, first: -1}
+	if err = r.resume(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// 读取已有的Tag，从最后一个完整的Tag之后继续写入，空文件时写入FLV头部
+func (r *Record) resume() error {
+	if _, err := r.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	tr := flv.NewTagReader(r.f)
+	if _, err := tr.ReadFlvHeader(); err != nil {
+		if err != io.EOF {
+			return err
+		}
+		if _, err = r.f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		r.w = flv.NewTagWriter(r.f)
+		return r.w.WriteFlvHeader(true, true)
+	}
+	offset, prevTag := tr.Offset(), uint32(0)
+	for {
+		h, data, err := tr.ReadTag()
+		if err != nil {
+			// 之前的录制异常结束时，最后一个Tag可能不完整
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				return err
+			}
+			break
+		}
+		offset, prevTag, r.base = tr.Offset(), uint32(11+len(data)), h.Timestamp+1
+	}
+	if err := r.f.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err := r.f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	r.w = flv.ResumeTagWriter(r.f, offset, prevTag)
+	return nil
+}
+
+// Write 写入一个数据包，时间戳相对第一个数据包加上base
+func (r *Record) Write(p *av.Packet) error {
+	if r.first < 0 {
+		r.first = int64(p.Timestamp)
+	}
+	timestamp := r.base
+	if d := int64(p.Timestamp) - r.first; d > 0 {
+		timestamp += uint32(d)
+	}
+	return r.w.WriteTag(p.Type, timestamp, p.Payload)
+}
+
+// Stop 写入最后一个Tag的大小并关闭文件
+func (r *Record) Stop() error {
+	err := r.w.Close()
+	if cerr := r.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// 开始录制，失败时发送NetStream.Record.Failed，仍按live发布
+func (c *conn) startRecord(name, mode string) error {
+	storage := c.server.Storage
+	if storage == nil {
+		return nil
+	}
+	c.stopRecord()
+	r, err := StartRecord(storage, c.app, name, mode)
+	if err != nil {
+		Log("record %s: %v", name, err)
+		return c.WriteMessage(statusMessage(LVL_ERROR, NS_RECORD_FAILED, err.Error()))
+	}
+	c.record = r
+	return c.WriteMessage(statusMessage(LVL_STATUS, NS_RECORD_START, "Recording "+name))
+}
+
+// 录制发布的数据包，写入失败时停止录制
+func (c *conn) recordPacket(p *av.Packet) {
+	if c.record == nil {
+		return
+	}
+	if err := c.record.Write(p); err != nil {
+		Log("record error: %v", err)
+		c.record.Stop()
+		c.record = nil
+		c.WriteMessage(statusMessage(LVL_ERROR, NS_RECORD_FAILED, err.Error()))
+	}
+}
+
+// 停止录制，发送NetStream.Record.Stop
+func (c *conn) stopRecord() {
+	if c.record == nil {
+		return
+	}
+	if err := c.record.Stop(); err != nil {
+		Log("stop record error: %v", err)
+	}
+	c.record = nil
+	if err := c.WriteMessage(statusMessage(LVL_STATUS, NS_RECORD_STOP, "Stopped recording")); err != nil {
+		Log("write record stop error: %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package rtmp
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/chenyj/rtmp/encoding/flv"
+)
+
+// 读取录制文件中所有Tag的时间戳
+func recordTimestamps(t *testing.T, name string) []uint32 {
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r := flv.NewTagReader(f)
+	if _, err = r.ReadFlvHeader(); err != nil {
+		t.Fatal(err)
+	}
+	var ts []uint32
+	for {
+		h, _, err := r.ReadTag()
+		if err == io.EOF {
+			return ts
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts = append(ts, h.Timestamp)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	storage := DirStorage{t.TempDir()}
+	name := filepath.Join(storage.Root, "live", "show.flv")
+	record := func(mode string, timestamps ...int) {
+		r, err := StartRecord(storage, "live", "show", mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, ts := range timestamps {
+			if err = r.Write(testPacket(ts)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = r.Stop(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 时间戳从0开始，追加时在已有内容最后的时间戳之后继续
+	record(PUBLISH_APPEND, 100, 140)
+	record(PUBLISH_APPEND, 0, 40)
+	want := []uint32{0, 40, 41, 81}
+	if ts := recordTimestamps(t, name); !reflect.DeepEqual(ts, want) {
+		t.Fatalf("timestamps %v, expect %v", ts, want)
+	}
+
+	// 不完整的最后一个Tag被丢弃
+	fi, _ := os.Stat(name)
+	os.Truncate(name, fi.Size()-10)
+	record(PUBLISH_APPEND, 500, 540)
+	if ts := recordTimestamps(t, name); !reflect.DeepEqual(ts, []uint32{0, 40, 41, 42, 82}) {
+		t.Fatalf("timestamps %v after truncate", ts)
+	}
+	ts := recordTimestamps(t, name)
+	for i := 1; i < len(ts); i++ {
+		if ts[i] <= ts[i-1] {
+			t.Fatalf("timestamps %v not increasing", ts)
+		}
+	}
+
+	record(PUBLISH_RECORD, 0)
+	if ts := recordTimestamps(t, name); !reflect.DeepEqual(ts, []uint32{0}) {
+		t.Fatalf("record timestamps %v", ts)
+	}
+	if _, err := StartRecord(storage, "live", "show", PUBLISH_LIVE); err == nil {
+		t.Fatal("expect invalid mode error")
+	}
+}
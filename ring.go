@@ -6,6 +6,8 @@ import (
 	"github.com/chenyj/rtmp/encoding/av"
 )
 
+// Ring 原来的数据包环形队列，Stream已改用fanout。
+// Ring和New是导出的API，为兼容保留，也是fanout基准测试的对照
 type Ring struct {
 	next, prev *Ring
 	sync.WaitGroup
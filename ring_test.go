@@ -0,0 +1,53 @@
+//go:build !race
+
+// Ring的WaitGroup在覆盖时会与读者竞争，只在未开启race检测时运行
+
+package rtmp
+
+import (
+	"sync"
+	"testing"
+)
+
+func benchmarkRing(b *testing.B, subscribers int) {
+	ring := New(4096)
+	ring.Add(1)
+	var sequence uint64
+	var wg sync.WaitGroup
+	for n := 0; n < subscribers; n++ {
+		wg.Add(1)
+		go func(r *Ring) {
+			defer wg.Done()
+			var seq uint64
+			for {
+				r.Wait()
+				if r.sequence > seq {
+					seq = r.sequence
+				}
+				if r.Packet == nil {
+					return
+				}
+				r = r.Next()
+				seq++
+			}
+		}(ring)
+	}
+	p := testPacket(1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ring.Packet = p
+		ring.sequence = sequence
+		ring = ring.NextW()
+		sequence++
+		ring.Prev().Done()
+	}
+	ring.Packet = nil
+	ring.sequence = sequence
+	ring = ring.Next()
+	ring.Prev().Done()
+	wg.Wait()
+}
+
+func BenchmarkRing1(b *testing.B)    { benchmarkRing(b, 1) }
+func BenchmarkRing100(b *testing.B)  { benchmarkRing(b, 100) }
+func BenchmarkRing1000(b *testing.B) { benchmarkRing(b, 1000) }
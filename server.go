@@ -92,6 +92,47 @@ type conn struct {
 	streamPath     string
 	enDumpCmd      bool
 	werr           error
+	smu            sync.Mutex
+	session        *PlaySession             // 正在播放的会话
+	objectEncoding float64                  // connect时协商的objectEncoding
+	sharedObjects  map[string]*SharedObject // 正在使用的共享对象
+	connectTid     uint32                   // connect命令的事务ID
+	publishOK      bool                     // handler已回复NS_PUBLISH_START
+	record         *Record                  // publish的record和append模式的录制
+	ctx            context.Context          // 连接断开时取消
+}
+
+func (c *conn) setPlaySession(ps *PlaySession) {
+	c.smu.Lock()
+	c.session = ps
+	c.smu.Unlock()
+}
+
+// ObjectEncoding connect时客户端的objectEncoding
+func (c *conn) ObjectEncoding() float64 {
+	return c.objectEncoding
+}
+
+func (c *conn) connectTransactionID() uint32 {
+	return c.connectTid
+}
+
+func (c *conn) publishAccepted() {
+	c.publishOK = true
+}
+
+func (c *conn) playSession() *PlaySession {
+	c.smu.Lock()
+	defer c.smu.Unlock()
+	return c.session
+}
+
+func (c *conn) clearPlaySession(ps *PlaySession) {
+	c.smu.Lock()
+	if c.session == ps {
+		c.session = nil
+	}
+	c.smu.Unlock()
 }
 
 // +--------------+----------------+--------------------+--------------+
@@ -111,6 +152,7 @@ func (c *conn) serve() {
 		return
 	}
 	ctx, cancel := context.WithCancel(context.Background())
+	c.ctx = ctx
 	// handle message loop
 	for msg := range c.readMessage(ctx) {
 		if err = c.handleMessage(ctx, msg); err != nil {
@@ -119,6 +161,42 @@ func (c *conn) serve() {
 	}
 	cancel()
 	Log("handle message error: %s", err)
+	c.releaseSharedObjects()
+	// 发布者没有deleteStream就断开时，通知handler停止发布
+	if c.streamPath != "" {
+		if err = c.deleteStream(0); err != nil {
+			Log("delete stream error: %v", err)
+		}
+	}
+}
+
+// 删除发布的流，handler可以据此停止发布，让新的发布者接管
+func (c *conn) deleteStream(transId uint32) error {
+	req := Request{
+		TransactionID: transId,
+		Command:       CMD_DELETE_STREAM,
+		Host:          c.rwc.RemoteAddr().String(),
+		App:           c.app,
+		StreamPath:    c.streamPath,
+	}
+	c.streamPath = ""
+	c.stopRecord()
+	return c.onCommand(&req)
+}
+
+// 交给handler处理命令，handler返回StatusError时先回复客户端，之后连接会被关闭
+func (c *conn) onCommand(r *Request) error {
+	if r.ctx == nil {
+		r.ctx = c.ctx
+	}
+	err := serverHandler{c.server}.OnCommand(c, r)
+	var se *StatusError
+	if errors.As(err, &se) {
+		if werr := ResponseStatusError(c, r.TransactionID, se); werr != nil {
+			Log("response %s error: %v", se.Code, werr)
+		}
+	}
+	return err
 }
 
 func (c *conn) readMessage(ctx context.Context) <-chan *message {
@@ -296,32 +374,42 @@ func (c *conn) handleMessage(ctx context.Context, msg *message) (err error) {
 
 	case 8: // Audio Message
 		p := av.AudioPack(msg.timestamp, msg.payload)
+		c.recordPacket(p)
 		err = serverHandler{c.server}.OnData(c.app, c.streamPath, p)
 
 	case 9: // Video Message
 		p := av.VideoPack(msg.timestamp, msg.payload)
+		c.recordPacket(p)
 		err = serverHandler{c.server}.OnData(c.app, c.streamPath, p)
 
-	case 15: // Data Message (AMF3)
-		Log("Data Message AMF3")
-
-	case 16: // Shared Object Message (AMF3)
-		Log("Shared Object Message")
-
-	case 17: // Command Message (AMF3)
-		Log("Command Message (AMF3)")
-
-	case 18: // Data Message (AMF0)
-		p := av.MetaPack(msg.timestamp, msg.payload)
+	case 15, 18: // Data Message (AMF3, AMF0)
+		payload := msg.payload
+		if msg.tid == DATA_AMF3 {
+			if payload, err = amf3Payload(payload); err != nil {
+				return
+			}
+		}
+		p := av.MetaPack(msg.timestamp, payload)
+		c.recordPacket(p)
 		err = serverHandler{c.server}.OnData(c.app, c.streamPath, p)
 
-	case 19: // Shared Object Message (AMF0)
-		Log("Shared Object Message")
+	case 16, 19: // Shared Object Message (AMF3, AMF0)
+		m := SharedObjectMessage{AMF3: msg.tid == SHARED_OBJECT_AMF3}
+		if err = m.Unmarshal(msg.payload); err != nil {
+			return
+		}
+		c.handleSharedObject(&m)
 
-	case 20: // Command Message (AFM0)
+	case 17, 20: // Command Message (AMF3, AFM0)
+		payload := msg.payload
+		if msg.tid == COMMAND_AMF3 {
+			if payload, err = amf3Payload(payload); err != nil {
+				return
+			}
+		}
 		// decode amf payload
 		var d amf0.Decoder
-		if d, err = amf0.NewDecoder(msg.payload); err != nil {
+		if d, err = amf0.NewDecoder(payload); err != nil {
 			return
 		}
 		var cmdName string // command name
@@ -331,13 +419,30 @@ func (c *conn) handleMessage(ctx context.Context, msg *message) (err error) {
 		}
 		// write command payload into file
 		if c.enDumpCmd {
-			write(msg.payload, cmdName+".bin")
+			write(payload, cmdName+".bin")
+			dumpJSON(payload, cmdName+".json")
 		}
 		Log("OnCommand: %s", cmdName)
 
 		switch cmdName {
 		default:
-			Log("未识别的命令：%s", cmdName)
+			// 自定义命令：commandName,TransacationId,object,args...
+			// 客户端的NetConnection.call(name)以name作为命令名发送，不会发送"call"，
+			// 所以没有单独处理CMD_CALL，名为call的命令也按自定义命令交给handler
+			var ar amf0.Amfarr
+			if ar, err = amf0.Decode(payload); err != nil {
+				return
+			}
+			req := Request{
+				TransactionID: transId,
+				Command:       cmdName,
+				Host:          c.rwc.RemoteAddr().String(),
+				App:           c.app,
+			}
+			if len(ar) > 3 {
+				req.Args = ar[3:]
+			}
+			err = c.onCommand(&req)
 
 		case CMD_CONNECT:
 			var cc ConnectCommand
@@ -351,15 +456,17 @@ func (c *conn) handleMessage(ctx context.Context, msg *message) (err error) {
 				return
 			}
 			c.app = cc.App
+			c.objectEncoding = cc.ObjectEncoding
+			c.connectTid = transId
 			req := Request{
-				TransactionID: transId,
-				Command:       cmdName,
-				Host:          c.rwc.RemoteAddr().String(),
-				App:           cc.App,
+				TransactionID:  transId,
+				Command:        cmdName,
+				Host:           c.rwc.RemoteAddr().String(),
+				App:            cc.App,
+				ObjectEncoding: cc.ObjectEncoding,
 			}
-			err = serverHandler{c.server}.OnCommand(c, &req)
+			err = c.onCommand(&req)
 
-		case CMD_CALL:
 		case CMD_CLOSE:
 		case CMD_CREATE_STREAM:
 			err = c.WriteMessage(CommandMessage{RSP_RESULT, transId, []any{nil, defaultMsid}})
@@ -373,15 +480,22 @@ func (c *conn) handleMessage(ctx context.Context, msg *message) (err error) {
 			if err != nil {
 				return err
 			}
+			// commandName,TransacationId,null,streamName[,start,duration,reset]
+			// 参数类型不对时保留默认值
+			start, duration, reset := float64(-2), float64(-1), false
+			d.Decode(&start, &duration, &reset)
 			req := Request{
 				TransactionID: transId,
 				Command:       cmdName,
 				Host:          c.rwc.RemoteAddr().String(),
 				App:           c.app,
-				StreamPath:    u.Path,
+				StreamPath:    streamPath(u),
 				Form:          u.Query(),
+				Start:         start,
+				Duration:      duration,
+				Reset:         reset,
 			}
-			err = serverHandler{c.server}.OnCommand(c, &req)
+			err = c.onCommand(&req)
 
 		case CMD_PLAY2:
 			Log("play2 command")
@@ -392,18 +506,24 @@ func (c *conn) handleMessage(ctx context.Context, msg *message) (err error) {
 				return errors.New("decode amf error")
 			}
 			Log("deleteStream command: %d", streamId)
-			// for k, v := range c.rChunkStream {
-			// 	if v.msid == streamId {
-			// 		delete(c.rChunkStream, k)
-			// 	}
-			// }
+			err = c.deleteStream(transId)
 
 		case CMD_CLOSE_STREAM:
 			Log("closeStream command")
-		case CMD_RECEIVE_AUDIO:
-			Log("receiveAudio command")
-		case CMD_RECEIVE_VIDEO:
-			Log("receiveVideo command")
+		case CMD_RECEIVE_AUDIO, CMD_RECEIVE_VIDEO:
+			// commandName,TransacationId,null,bool
+			flag, ok := d.Skip().GetBool()
+			if !ok {
+				return errors.New("decode amf error")
+			}
+			Log("%s command: %v", cmdName, flag)
+			if ps := c.playSession(); ps != nil {
+				if cmdName == CMD_RECEIVE_AUDIO {
+					ps.ReceiveAudio(flag)
+				} else {
+					ps.ReceiveVideo(flag)
+				}
+			}
 
 		case CMD_PUBLISH:
 			var streamName, streamType string
@@ -429,12 +549,36 @@ func (c *conn) handleMessage(ctx context.Context, msg *message) (err error) {
 				StreamPath:    u.Path,
 				Form:          u.Query(),
 			}
-			err = serverHandler{c.server}.OnCommand(c, &req)
+			c.publishOK = false
+			if err = c.onCommand(&req); err != nil {
+				return
+			}
+			// 只有handler接受了publish才开始录制
+			if c.publishOK && (streamType == PUBLISH_RECORD || streamType == PUBLISH_APPEND) {
+				err = c.startRecord(streamPath(u), streamType)
+			}
 
 		case CMD_SEEK:
-			Log("seek command")
+			// commandName,TransacationId,null,milliSeconds
+			ms, ok := d.Skip().GetFloat64()
+			if !ok {
+				return errors.New("decode amf error")
+			}
+			Log("seek command: %v", ms)
+			if ps := c.playSession(); ps != nil {
+				ps.Seek(uint32(ms))
+			}
+
 		case CMD_PAUSE:
-			Log("pause command")
+			// commandName,TransacationId,null,pause,milliSeconds
+			pause, ok := d.Skip().GetBool()
+			if !ok {
+				return errors.New("decode amf error")
+			}
+			Log("pause command: %v", pause)
+			if ps := c.playSession(); ps != nil {
+				ps.Pause(pause)
+			}
 
 		case CMD_FCPUBLISH:
 			// commandName,TransacationId,object,streamName
@@ -458,7 +602,7 @@ func (c *conn) handleMessage(ctx context.Context, msg *message) (err error) {
 				StreamPath:    u.Path,
 				Form:          u.Query(),
 			}
-			err = serverHandler{c.server}.OnCommand(c, &req)
+			err = c.onCommand(&req)
 
 		case CMD_RELEASE_STREAM:
 			// commandName,TransacationId,object,streamName
@@ -466,8 +610,25 @@ func (c *conn) handleMessage(ctx context.Context, msg *message) (err error) {
 			Log("releaseStream: %s", streamName)
 
 		case CMD_GET_STREAM_LENGTH:
-			streamName, _ := d.Skip().GetString()
+			// commandName,TransacationId,null,streamName
+			streamName, ok := d.Skip().GetString()
+			if !ok {
+				return errors.New("decode amf error")
+			}
 			Log("getStreamLength: %s", streamName)
+			u, err := url.Parse(streamName)
+			if err != nil {
+				return err
+			}
+			req := Request{
+				TransactionID: transId,
+				Command:       cmdName,
+				Host:          c.rwc.RemoteAddr().String(),
+				App:           c.app,
+				StreamPath:    streamPath(u),
+				Form:          u.Query(),
+			}
+			err = c.onCommand(&req)
 		}
 
 	case 22: // Aggregate Message
@@ -476,34 +637,63 @@ func (c *conn) handleMessage(ctx context.Context, msg *message) (err error) {
 	return
 }
 
+// AMF3的数据和命令消息以一个字节的格式选择开头，0表示之后按AMF0编码，
+// 其中的值可以通过AMF0的切换标记使用AMF3
+func amf3Payload(bs []byte) ([]byte, error) {
+	if len(bs) == 0 || bs[0] != 0 {
+		return nil, errors.New("rtmp: invalid amf3 message")
+	}
+	return bs[1:], nil
+}
+
+// 流名称可以带flv:、mp4:前缀，url.Parse把它们当作scheme
+func streamPath(u *url.URL) string {
+	if u.Opaque != "" {
+		return u.Opaque
+	}
+	return u.Path
+}
+
+// ResponseConnect 回复connect，失败时以_error回复NetConnection.Connect.Rejected。
+// 事务ID使用connect命令的，w不是服务器的连接时为1
 func ResponseConnect(w MessageWriter, status bool, desc string) error {
-	var info respInfo
-	if status {
-		info.Level = LVL_STATUS
-		info.Code = "NetConnection.Connect.Success"
-		info.Desc = "Connection succeeded"
-	} else {
-		info.Level = LVL_ERROR
-		info.Code = "NetConnection.Connect.Refused"
-		info.Desc = desc
+	transId := uint32(1)
+	if tw, ok := w.(interface{ connectTransactionID() uint32 }); ok {
+		transId = tw.connectTransactionID()
+	}
+	if !status {
+		return ResponseError(w, transId, NC_CONNECT_REJECTED, desc, nil)
 	}
-	return w.WriteMessage(CommandMessage{RSP_RESULT, 1, []any{RespProp, info}})
+	info := respInfo{LVL_STATUS, NC_CONNECT_SUCCESS, "Connection succeeded"}
+	// 回复客户端使用的objectEncoding，AMF3客户端需要它确认服务器支持AMF3
+	if ow, ok := w.(interface{ ObjectEncoding() float64 }); ok {
+		return w.WriteMessage(CommandMessage{RSP_RESULT, transId, []any{RespProp, connectInfo{info.Level, info.Code, info.Desc, ow.ObjectEncoding()}}})
+	}
+	return w.WriteMessage(CommandMessage{RSP_RESULT, transId, []any{RespProp, info}})
 }
 
 func ResponsePublish(w MessageWriter, status bool, desc string) (err error) {
 	var info respInfo
 	if status {
 		info.Level = LVL_STATUS
-		info.Code = "NetStream.Publish.Start"
+		info.Code = NS_PUBLISH_START
 		info.Desc = "Start publishing"
+		if pw, ok := w.(interface{ publishAccepted() }); ok {
+			pw.publishAccepted()
+		}
 	} else {
 		info.Level = LVL_ERROR
-		info.Code = "NetStream.Publish.Error"
+		info.Code = NS_PUBLISH_BAD_NAME
 		info.Desc = desc
 	}
 	return w.WriteMessage(CommandMessage{RSP_ON_STATUS, 0, []any{nil, info}})
 }
 
+// onStatus命令消息
+func statusMessage(lvl level, code, desc string) CommandMessage {
+	return CommandMessage{RSP_ON_STATUS, 0, []any{nil, respInfo{lvl, code, desc}}}
+}
+
 // The server sends an onStatus command messages NetStream.Play.Start & NetStream.Play.Reset
 // if the play command sent by the client is successful. NetStream.Play.Reset is sent by the
 // server only if the play command sent by the client has set the reset flag. If the stream
@@ -512,16 +702,42 @@ func ResponsePlay(w MessageWriter, status bool, desc string) error {
 	var info respInfo
 	if status {
 		info.Level = LVL_STATUS
-		info.Code = "NetStream.Play.Start"
+		info.Code = NS_PLAY_START
 		info.Desc = "Start playing"
 	} else {
 		info.Level = LVL_ERROR
-		info.Code = "NetStream.Play.StreamNotFound"
+		info.Code = NS_PLAY_STREAM_NOT_FOUND
 		info.Desc = desc
 	}
 	return w.WriteMessage(CommandMessage{RSP_ON_STATUS, 0, []any{nil, info}})
 }
 
+// ResponseStreamLength 回复getStreamLength，seconds是流的时长
+func ResponseStreamLength(w MessageWriter, transId uint32, seconds float64) error {
+	return w.WriteMessage(CommandMessage{RSP_RESULT, transId, []any{nil, seconds}})
+}
+
+// ResponseCall 回复自定义命令，err不为nil时发送_error。
+// 事务ID为0表示客户端不需要回复
+func ResponseCall(w MessageWriter, transId uint32, result any, err error) error {
+	if transId == 0 {
+		return nil
+	}
+	if err != nil {
+		var se *StatusError
+		if errors.As(err, &se) {
+			return ResponseError(w, transId, se.Code, se.Desc, se.Application)
+		}
+		return ResponseError(w, transId, NC_CALL_FAILED, err.Error(), nil)
+	}
+	return w.WriteMessage(CommandMessage{RSP_RESULT, transId, []any{nil, result}})
+}
+
+// onPlayStatus数据消息
+func playStatusMessage(code, desc string) DataMessage {
+	return DataMessage{"onPlayStatus", 0, []any{respInfo{LVL_STATUS, code, desc}}}
+}
+
 // implement io.Reader
 func (c *conn) Read(p []byte) (n int, err error) {
 	n, err = io.ReadFull(c.bufr, p)
@@ -578,6 +794,17 @@ type Server struct {
 	doneChan   chan struct{}
 	onShutdown []func()
 	Handler    Handler
+	// 远程共享对象，为nil时使用DefaultSharedObjects
+	SharedObjects *SharedObjectStore
+	// 录制的存储，为nil时publish的record和append按live处理
+	Storage RecordStorage
+}
+
+func (s *Server) sharedObjects() *SharedObjectStore {
+	if s.SharedObjects == nil {
+		return DefaultSharedObjects
+	}
+	return s.SharedObjects
 }
 
 func (s *Server) ListenAndServe() error {
@@ -619,13 +846,41 @@ func ListenAndServe(addr string, handler Handler) error {
 }
 
 type Request struct {
-	TransactionID uint32
-	Command       string
-	Host          string
-	App           string
-	StreamPath    string
-	StreamType    string
-	Form          url.Values
+	TransactionID  uint32
+	Command        string
+	Host           string
+	App            string
+	StreamPath     string
+	StreamType     string
+	Form           url.Values
+	Start          float64     // play的start参数，秒，-2表示没有指定
+	Duration       float64     // play的duration参数，秒，-1表示播放到结束
+	Reset          bool        // play的reset参数
+	ObjectEncoding float64     // connect的objectEncoding，0为AMF0，3为AMF3
+	Args           amf0.Amfarr // 自定义命令的参数
+	ctx            context.Context
+}
+
+// Context 返回连接的context，连接断开时取消。
+// 在handler中启动的播放等goroutine应该以它结束
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// DecodeArgs 将自定义命令的参数依次解码到v
+func (r *Request) DecodeArgs(v ...any) error {
+	bs, err := amf0.Encode(r.Args...)
+	if err != nil {
+		return err
+	}
+	d, err := amf0.NewDecoder(bs)
+	if err != nil {
+		return err
+	}
+	return d.Decode(v...)
 }
 
 type MessageWriter interface {
@@ -639,6 +894,10 @@ type Handler interface {
 
 type handlerFunc func(MessageWriter, *Request) error
 
+// CallHandler 处理客户端调用的自定义命令，参数在r.Args中。
+// 返回值作为_result发送给客户端，返回错误时发送_error
+type CallHandler func(r *Request) (any, error)
+
 type serverHandler struct {
 	srv *Server
 }
@@ -664,19 +923,35 @@ var DefaultServeMux = &defaultServeMux
 
 type ServeMux struct {
 	mux    map[string]handlerFunc
+	calls  map[string]CallHandler
 	onData func(string, string, *av.Packet) error
+	// ReplyNotFound 为true时没有注册的命令回复_error "Method not found"，
+	// 默认只记录日志，FCSubscribe、checkBandwidth等客户端常发的命令不需要回复
+	ReplyNotFound bool
 }
 
 func (sm *ServeMux) OnCommand(w MessageWriter, r *Request) error {
 	fn, ok := sm.mux[r.Command]
 	if !ok {
+		if call, ok := sm.calls[r.Command]; ok {
+			result, err := call(r)
+			return ResponseCall(w, r.TransactionID, result, err)
+		}
 		switch r.Command {
 		default:
+			if sm.ReplyNotFound {
+				return ResponseCall(w, r.TransactionID, nil, errors.New("Method not found ("+r.Command+")"))
+			}
+			Log("未识别的命令：%s", r.Command)
+			return nil
+		case CMD_PLAY:
 			return errors.New("command handler not found")
 		case CMD_CONNECT:
 			return ResponseConnect(w, true, "")
 		case CMD_PUBLISH:
 			return ResponsePublish(w, true, "")
+		case CMD_FCUNPUBLISH, CMD_DELETE_STREAM, CMD_GET_STREAM_LENGTH:
+			return nil
 		}
 	}
 	return fn(w, r)
@@ -696,6 +971,14 @@ func HandleCommand(command string, handler func(MessageWriter, *Request) error)
 	defaultServeMux.mux[command] = handler
 }
 
+// HandleCall 注册自定义命令的处理函数，如getServerTime
+func HandleCall(command string, handler CallHandler) {
+	if defaultServeMux.calls == nil {
+		defaultServeMux.calls = make(map[string]CallHandler)
+	}
+	defaultServeMux.calls[command] = handler
+}
+
 func HandleData(handler func(string, string, *av.Packet) error) {
 	defaultServeMux.onData = handler
 }
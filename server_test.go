@@ -0,0 +1,111 @@
+package rtmp
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/chenyj/rtmp/encoding/amf0"
+)
+
+func TestCall(t *testing.T) {
+	HandleCall("setTitle", func(r *Request) (any, error) {
+		var title string
+		if err := r.DecodeArgs(&title); err != nil {
+			return nil, err
+		}
+		if title == "" {
+			return nil, errors.New("empty title")
+		}
+		return "ok:" + title, nil
+	})
+	defer delete(defaultServeMux.calls, "setTitle")
+
+	w := &recorder{}
+	for i, args := range []amf0.Amfarr{{"news"}, {""}} {
+		r := Request{TransactionID: uint32(i + 2), Command: "setTitle", Args: args}
+		if err := DefaultServeMux.OnCommand(w, &r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 没有注册的命令默认不回复
+	DefaultServeMux.OnCommand(w, &Request{TransactionID: 4, Command: "FCSubscribe"})
+	if len(w.msgs) != 2 {
+		t.Fatalf("%d messages, expect 2", len(w.msgs))
+	}
+	// 开启后回复_error，事务ID为0时不回复
+	DefaultServeMux.ReplyNotFound = true
+	defer func() { DefaultServeMux.ReplyNotFound = false }()
+	DefaultServeMux.OnCommand(w, &Request{TransactionID: 4, Command: "getServerTime"})
+	DefaultServeMux.OnCommand(w, &Request{Command: "getServerTime"})
+
+	if len(w.msgs) != 3 {
+		t.Fatalf("%d messages, expect 3", len(w.msgs))
+	}
+	m := w.msgs[0].(CommandMessage)
+	if m.Name != RSP_RESULT || m.TransactionId != 2 || m.arr[1] != "ok:news" {
+		t.Fatalf("%+v", m)
+	}
+	for i, desc := range []string{"empty title", "Method not found (getServerTime)"} {
+		m = w.msgs[i+1].(CommandMessage)
+		info := m.arr[1].(errorInfo)
+		if m.Name != "_error" || m.TransactionId != uint32(i+3) || info.Desc != desc {
+			t.Fatalf("%+v", m)
+		}
+	}
+}
+
+type connectRecorder struct {
+	recorder
+	tid uint32
+}
+
+func (r *connectRecorder) connectTransactionID() uint32 {
+	return r.tid
+}
+
+func TestStatusError(t *testing.T) {
+	w := &connectRecorder{tid: 5}
+	ResponseConnect(w, true, "")
+	ResponseConnect(w, false, "banned")
+	err := fmt.Errorf("check token: %w", &StatusError{NC_CONNECT_REJECTED, "bad token", "rtmp://backup/live"})
+	if !errors.Is(err, ErrConnectRejected) || errors.Is(err, ErrInvalidApp) {
+		t.Fatal("errors.Is should compare code")
+	}
+	var se *StatusError
+	errors.As(err, &se)
+	ResponseStatusError(w, 6, se)
+	ResponseStatusError(w, 7, ErrBadName)
+
+	want := []struct {
+		name string
+		tid  uint32
+		code string
+	}{
+		{RSP_RESULT, 5, NC_CONNECT_SUCCESS},
+		{RSP_ERROR, 5, NC_CONNECT_REJECTED},
+		{RSP_ERROR, 6, NC_CONNECT_REJECTED},
+		{RSP_ON_STATUS, 0, NS_PUBLISH_BAD_NAME},
+	}
+	if len(w.msgs) != len(want) {
+		t.Fatalf("%d messages, expect %d", len(w.msgs), len(want))
+	}
+	for i, m := range w.msgs {
+		m := m.(CommandMessage)
+		var code string
+		switch info := m.arr[1].(type) {
+		case respInfo:
+			code = info.Code
+		case connectInfo:
+			code = info.Code
+		case errorInfo:
+			code = info.Code
+			if i == 2 && info.Application != "rtmp://backup/live" {
+				t.Fatalf("application %v", info.Application)
+			}
+		}
+		if m.Name != want[i].name || m.TransactionId != want[i].tid || code != want[i].code {
+			t.Fatalf("message %d: %s %d %s", i, m.Name, m.TransactionId, code)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package rtmp
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/chenyj/rtmp/encoding/av"
+)
+
+// PlaySession 一个播放会话，把流中的数据包发送给播放端，
+// 并把流的状态变化转换为User Control Message和onStatus通知。
+//
+// 播放端可以通过receiveAudio/receiveVideo命令选择接收的轨道，
+// 自定义的播放循环可以通过Filter复用这些状态。
+type PlaySession struct {
+	w  MessageWriter
+	it Iterator
+
+	mu          sync.Mutex
+	audio       bool       // 是否发送音频
+	video       bool       // 是否发送视频
+	audioResume bool       // 音频重新打开，需要重发audio config
+	videoResume bool       // 视频重新打开，等待关键帧并重发video config
+	audio0      *av.Packet // 最近的audio config
+	video0      *av.Packet // 最近的video config
+
+	paused   bool               // 是否请求暂停
+	notified bool               // 是否已发送Pause.Notify
+	seeking  bool               // 是否有待处理的seek
+	seekTo   uint32             // seek的目标时间，毫秒
+	cancel   context.CancelFunc // 取消正在进行的发送
+	wake     chan struct{}      // 暂停时唤醒Serve
+}
+
+// NewPlaySession 创建播放会话。w是服务端的连接时，
+// 连接上的receiveAudio/receiveVideo命令会作用于该会话。
+func NewPlaySession(w MessageWriter, it Iterator) *PlaySession {
+	ps := &PlaySession{w: w, it: it, audio: true, video: true, wake: make(chan struct{}, 1)}
+	if c, ok := w.(*conn); ok {
+		c.setPlaySession(ps)
+	}
+	return ps
+}
+
+// SessionOf 返回连接上正在播放的会话，没有时返回nil
+func SessionOf(w MessageWriter) *PlaySession {
+	if c, ok := w.(*conn); ok {
+		return c.playSession()
+	}
+	return nil
+}
+
+func (ps *PlaySession) Iterator() Iterator {
+	return ps.it
+}
+
+// ReceiveAudio 打开或关闭音频，重新打开时先发送audio config
+func (ps *PlaySession) ReceiveAudio(flag bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if flag && !ps.audio {
+		ps.audioResume = true
+	}
+	ps.audio = flag
+}
+
+// ReceiveVideo 打开或关闭视频，重新打开时从下一个关键帧开始发送，并在关键帧之前发送video config
+func (ps *PlaySession) ReceiveVideo(flag bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if flag && !ps.video {
+		ps.videoResume = true
+	}
+	ps.video = flag
+}
+
+func (ps *PlaySession) ReceivingAudio() bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.audio
+}
+
+func (ps *PlaySession) ReceivingVideo() bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.video
+}
+
+// Filter 按照轨道选择过滤数据包，对需要发送的每个数据包调用fn
+func (ps *PlaySession) Filter(p *av.Packet, fn func(*av.Packet) error) error {
+	ps.mu.Lock()
+	var config *av.Packet
+	switch {
+	case p.IsAudio():
+		if p.IsConfig {
+			ps.audio0 = p
+		}
+		if !ps.audio {
+			p = nil
+		} else if ps.audioResume {
+			ps.audioResume = false
+			if !p.IsConfig {
+				config = ps.audio0
+			}
+		}
+	case p.IsVideo():
+		if p.IsConfig {
+			ps.video0 = p
+		}
+		if !ps.video {
+			p = nil
+		} else if ps.videoResume {
+			if p.IsKeyFrame && !p.IsConfig {
+				ps.videoResume = false
+				config = ps.video0
+			} else {
+				p = nil
+			}
+		}
+	}
+	ps.mu.Unlock()
+
+	if p == nil {
+		return nil
+	}
+	if config != nil {
+		// 使用当前数据包的时间戳，避免时间戳回退
+		c := *config
+		c.Timestamp = p.Timestamp
+		if err := fn(&c); err != nil {
+			return err
+		}
+	}
+	return fn(p)
+}
+
+// Pause 暂停或恢复发送。暂停时保留读取位置，恢复后从该位置继续
+func (ps *PlaySession) Pause(pause bool) {
+	ps.mu.Lock()
+	ps.paused = pause
+	ps.interrupt()
+	ps.mu.Unlock()
+}
+
+func (ps *PlaySession) Paused() bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.paused
+}
+
+// Seek 定位到ms之前最近的关键帧，由Serve异步完成并通知播放端
+func (ps *PlaySession) Seek(ms uint32) {
+	ps.mu.Lock()
+	ps.seekTo, ps.seeking = ms, true
+	ps.interrupt()
+	ps.mu.Unlock()
+}
+
+// 打断正在进行的发送，让Serve处理控制请求。调用者持有mu
+func (ps *PlaySession) interrupt() {
+	if ps.cancel != nil {
+		ps.cancel()
+	}
+	select {
+	case ps.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Serve 持续发送数据，直到流关闭、发送失败或ctx结束。
+// 发布者停止发布或被新的发布者接管时不会返回，返回前释放Iterator。
+func (ps *PlaySession) Serve(ctx context.Context) error {
+	defer ps.it.Release()
+	if c, ok := ps.w.(*conn); ok {
+		defer c.clearPlaySession(ps)
+	}
+	for {
+		dctx, cancel := context.WithCancel(ctx)
+		ps.mu.Lock()
+		ps.cancel = cancel
+		if ps.seeking || ps.paused || ps.paused != ps.notified {
+			cancel()
+		}
+		ps.mu.Unlock()
+
+		err := ps.it.Do(dctx, ps.send)
+		cancel()
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == nil {
+			// 被控制请求打断
+			if err = ps.control(ctx); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var ev *StreamEvent
+		if !errors.As(err, &ev) {
+			return err
+		}
+		if err = ps.notify(ev); err != nil {
+			return err
+		}
+		if ev.Type == STREAM_EOF {
+			return ev
+		}
+	}
+}
+
+// 处理seek和pause请求，暂停时等待恢复
+func (ps *PlaySession) control(ctx context.Context) error {
+	for {
+		ps.mu.Lock()
+		seeking, seekTo := ps.seeking, ps.seekTo
+		paused, notified := ps.paused, ps.notified
+		ps.seeking = false
+		ps.notified = paused
+		ps.mu.Unlock()
+
+		var msgs []Messager
+		if seeking {
+			if _, err := ps.it.Seek(seekTo); err != nil {
+				msgs = append(msgs, statusMessage(LVL_ERROR, NS_SEEK_FAILED, err.Error()))
+			} else {
+				msgs = append(msgs,
+					statusMessage(LVL_STATUS, NS_SEEK_NOTIFY, "Seeking "+strconv.FormatUint(uint64(seekTo), 10)),
+					UserControlMessage{STREAM_BEGIN, defaultMsid, 0},
+					statusMessage(LVL_STATUS, NS_PLAY_RESET, "Playing and resetting"))
+			}
+		}
+		if paused != notified {
+			if paused {
+				msgs = append(msgs, statusMessage(LVL_STATUS, NS_PAUSE_NOTIFY, "Paused stream"))
+			} else {
+				msgs = append(msgs, statusMessage(LVL_STATUS, NS_UNPAUSE_NOTIFY, "Unpaused stream"))
+			}
+		}
+		for _, m := range msgs {
+			if err := ps.w.WriteMessage(m); err != nil {
+				return err
+			}
+		}
+		if !paused {
+			return nil
+		}
+		select {
+		case <-ps.wake:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (ps *PlaySession) send(p *av.Packet) error {
+	if p == nil {
+		return nil
+	}
+	return ps.Filter(p, ps.write)
+}
+
+func (ps *PlaySession) write(p *av.Packet) error {
+	return ps.w.WriteMessage(NewMessage(p))
+}
+
+// 通知播放端流的状态变化
+func (ps *PlaySession) notify(ev *StreamEvent) error {
+	var msgs []Messager
+	switch ev.Type {
+	case STREAM_BEGIN:
+		msgs = []Messager{
+			UserControlMessage{STREAM_BEGIN, defaultMsid, 0},
+			statusMessage(LVL_STATUS, NS_PLAY_PUBLISH_NOTIFY, descOr(ev.Reason, "Stream is now published")),
+		}
+	case STREAM_DRY:
+		msgs = []Messager{
+			statusMessage(LVL_STATUS, NS_PLAY_UNPUBLISH_NOTIFY, descOr(ev.Reason, "Stream is now unpublished")),
+			UserControlMessage{STREAM_DRY, defaultMsid, 0},
+		}
+	default:
+		if ev == errFileEnd {
+			msgs = append(msgs, playStatusMessage(NS_PLAY_COMPLETE, "Playback complete"))
+		}
+		msgs = append(msgs,
+			UserControlMessage{STREAM_EOF, defaultMsid, 0},
+			statusMessage(LVL_STATUS, NS_PLAY_STOP, descOr(ev.Reason, "Stopped playing")),
+		)
+	}
+	for _, m := range msgs {
+		if err := ps.w.WriteMessage(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func descOr(desc, def string) string {
+	if desc == "" {
+		return def
+	}
+	return desc
+}
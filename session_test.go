@@ -0,0 +1,44 @@
+package rtmp
+
+import (
+	"testing"
+
+	"github.com/chenyj/rtmp/encoding/av"
+)
+
+func TestPlaySessionFilter(t *testing.T) {
+	ps := NewPlaySession(nil, nil)
+	var sent []*av.Packet
+	send := func(p *av.Packet) error {
+		sent = append(sent, p)
+		return nil
+	}
+
+	config := av.VideoPack(0, []byte{0x17, 0, 0, 0, 0})
+	audio := av.AudioPack(0, []byte{0xAF, 1, 0})
+	ps.Filter(config, send)
+	ps.Filter(testPacket(0), send)
+
+	ps.ReceiveVideo(false)
+	ps.Filter(testPacket(1), send)
+	ps.Filter(audio, send)
+	ps.ReceiveVideo(true)
+	ps.Filter(testPacket(2), send)  // 等待关键帧
+	ps.Filter(testPacket(25), send) // 关键帧之前重发video config
+
+	want := []uint32{0, 0, 0, 25, 25}
+	if len(sent) != len(want) {
+		t.Fatalf("sent %d packets, expect %d", len(sent), len(want))
+	}
+	for i, p := range sent {
+		if p.Timestamp != want[i] {
+			t.Fatalf("packet %d timestamp %d, expect %d", i, p.Timestamp, want[i])
+		}
+	}
+	if sent[2] != audio || !sent[3].IsConfig || sent[3].Timestamp != 25 {
+		t.Fatal("expect audio then resent config before key frame")
+	}
+	if !ps.ReceivingVideo() || !ps.ReceivingAudio() {
+		t.Fatal("receiving state not restored")
+	}
+}
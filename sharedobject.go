@@ -0,0 +1,351 @@
+package rtmp
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/chenyj/rtmp/encoding/amf0"
+)
+
+// DefaultSharedObjects Server.SharedObjects为nil时使用，不持久化
+var DefaultSharedObjects = NewSharedObjectStore("")
+
+// SharedObjectStore 按app保存远程共享对象。
+// Dir不为空时，持久的共享对象在每次修改后保存到Dir/app/name.json，第一次使用时从中加载
+type SharedObjectStore struct {
+	Dir string
+	// Persistent 是否允许客户端使用持久的共享对象，为nil时客户端请求的都按非持久处理
+	Persistent func(app, name string) bool
+	mu         sync.Mutex
+	apps       map[string]map[string]*SharedObject
+}
+
+func NewSharedObjectStore(dir string) *SharedObjectStore {
+	return &SharedObjectStore{Dir: dir, apps: make(map[string]map[string]*SharedObject)}
+}
+
+// Get 返回app中的共享对象，不存在时创建
+func (s *SharedObjectStore) Get(app, name string, persistent bool) *SharedObject {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.get(app, name, persistent)
+}
+
+// Lookup 返回app中已存在的共享对象
+func (s *SharedObjectStore) Lookup(app, name string) (*SharedObject, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	so, ok := s.apps[app][name]
+	return so, ok
+}
+
+func (s *SharedObjectStore) get(app, name string, persistent bool) *SharedObject {
+	objs, ok := s.apps[app]
+	if !ok {
+		objs = make(map[string]*SharedObject)
+		s.apps[app] = objs
+	}
+	so, ok := objs[name]
+	if !ok {
+		so = &SharedObject{
+			store:       s,
+			app:         app,
+			name:        name,
+			persistent:  persistent,
+			data:        make(amf0.Amfkv),
+			subscribers: make(map[MessageWriter]bool),
+		}
+		if persistent {
+			so.load()
+		}
+		objs[name] = so
+	}
+	return so
+}
+
+// 客户端订阅共享对象，回复当前的所有属性。
+// 持久化由Persistent决定，不由客户端决定
+func (s *SharedObjectStore) use(app, name string, persistent bool, w MessageWriter, amf3 bool) *SharedObject {
+	persistent = persistent && s.Persistent != nil && s.Persistent(app, name)
+	s.mu.Lock()
+	so := s.get(app, name, persistent)
+	msgs := so.use(w, amf3)
+	s.mu.Unlock()
+	msgs.send()
+	return so
+}
+
+// 取消订阅，没有订阅者的非持久共享对象被删除
+func (s *SharedObjectStore) release(so *SharedObject, w MessageWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	so.mu.Lock()
+	delete(so.subscribers, w)
+	empty := len(so.subscribers) == 0
+	so.mu.Unlock()
+	if empty && !so.persistent && s.apps[so.app][so.name] == so {
+		delete(s.apps[so.app], so.name)
+		if len(s.apps[so.app]) == 0 {
+			delete(s.apps, so.app)
+		}
+	}
+}
+
+// 持久化文件的路径，Dir为空或名称不能作为文件名时返回空字符串
+func (s *SharedObjectStore) path(app, name string) string {
+	if s.Dir == "" || !validFilename(app) || !validFilename(name) {
+		return ""
+	}
+	return filepath.Join(s.Dir, url.PathEscape(app), url.PathEscape(name)+".json")
+}
+
+// PathEscape会转义路径分隔符，但不会转义.和..
+func validFilename(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsRune(name, 0)
+}
+
+// SharedObject 远程共享对象，每次修改版本号加一，修改广播给所有订阅者
+type SharedObject struct {
+	store       *SharedObjectStore
+	app         string
+	name        string
+	persistent  bool
+	mu          sync.Mutex
+	version     uint32
+	data        amf0.Amfkv
+	subscribers map[MessageWriter]bool // 值为true时使用AMF3消息
+}
+
+func (so *SharedObject) Name() string {
+	return so.name
+}
+
+func (so *SharedObject) Version() uint32 {
+	so.mu.Lock()
+	defer so.mu.Unlock()
+	return so.version
+}
+
+// Get 返回属性的值
+func (so *SharedObject) Get(key string) (any, bool) {
+	so.mu.Lock()
+	defer so.mu.Unlock()
+	v, ok := so.data[key]
+	return v, ok
+}
+
+// Data 返回所有属性的副本
+func (so *SharedObject) Data() amf0.Amfkv {
+	so.mu.Lock()
+	defer so.mu.Unlock()
+	kv := make(amf0.Amfkv, len(so.data))
+	for k, v := range so.data {
+		kv[k] = v
+	}
+	return kv
+}
+
+// Set 服务端修改属性，通知所有订阅者
+func (so *SharedObject) Set(key string, value any) {
+	so.mu.Lock()
+	msgs := so.change(nil, key, value)
+	so.mu.Unlock()
+	msgs.send()
+}
+
+// Delete 服务端删除属性，通知所有订阅者
+func (so *SharedObject) Delete(key string) {
+	so.mu.Lock()
+	msgs := so.remove(key)
+	so.mu.Unlock()
+	msgs.send()
+}
+
+// Send 调用所有订阅者的方法
+func (so *SharedObject) Send(method string, args ...any) {
+	so.sendMessage(append([]any{method}, args...))
+}
+
+func (so *SharedObject) sendMessage(args []any) {
+	so.mu.Lock()
+	msgs := so.broadcast(nil, SharedObjectEvent{Type: SO_SEND_MESSAGE, Args: args})
+	so.mu.Unlock()
+	msgs.send()
+}
+
+func (so *SharedObject) use(w MessageWriter, amf3 bool) soMessages {
+	so.mu.Lock()
+	defer so.mu.Unlock()
+	so.subscribers[w] = amf3
+	events := []SharedObjectEvent{{Type: SO_USE_SUCCESS}, {Type: SO_CLEAR}}
+	for k, v := range so.data {
+		events = append(events, SharedObjectEvent{Type: SO_CHANGE, Name: k, Value: v})
+	}
+	return so.message(nil, w, amf3, events...)
+}
+
+// 客户端请求修改属性，回复SO_SUCCESS，其他订阅者收到SO_CHANGE
+func (so *SharedObject) requestChange(w MessageWriter, key string, value any) {
+	so.mu.Lock()
+	msgs := so.change(w, key, value)
+	so.mu.Unlock()
+	msgs.send()
+}
+
+func (so *SharedObject) requestRemove(key string) {
+	so.mu.Lock()
+	msgs := so.remove(key)
+	so.mu.Unlock()
+	msgs.send()
+}
+
+// 修改属性，返回要发送的消息，调用者持有mu，释放mu后发送
+func (so *SharedObject) change(from MessageWriter, key string, value any) (msgs soMessages) {
+	so.data[key] = value
+	so.version++
+	so.save()
+	if from != nil {
+		if amf3, ok := so.subscribers[from]; ok {
+			msgs = so.message(msgs, from, amf3, SharedObjectEvent{Type: SO_SUCCESS, Name: key})
+		}
+	}
+	return append(msgs, so.broadcast(from, SharedObjectEvent{Type: SO_CHANGE, Name: key, Value: value})...)
+}
+
+func (so *SharedObject) remove(key string) soMessages {
+	if _, ok := so.data[key]; !ok {
+		return nil
+	}
+	delete(so.data, key)
+	so.version++
+	so.save()
+	return so.broadcast(nil, SharedObjectEvent{Type: SO_REMOVE, Name: key})
+}
+
+// 发送给除except之外的所有订阅者的消息
+func (so *SharedObject) broadcast(except MessageWriter, events ...SharedObjectEvent) (msgs soMessages) {
+	for w, amf3 := range so.subscribers {
+		if w != except {
+			msgs = so.message(msgs, w, amf3, events...)
+		}
+	}
+	return
+}
+
+func (so *SharedObject) message(msgs soMessages, w MessageWriter, amf3 bool, events ...SharedObjectEvent) soMessages {
+	m := SharedObjectMessage{so.name, so.version, so.persistent, events, amf3}
+	return append(msgs, soMessage{w, m})
+}
+
+// 发送给一个订阅者的消息。在锁外发送，慢的订阅者不会阻塞其他订阅者和共享对象
+type soMessage struct {
+	w MessageWriter
+	m SharedObjectMessage
+}
+
+type soMessages []soMessage
+
+func (msgs soMessages) send() {
+	for _, msg := range msgs {
+		if err := msg.w.WriteMessage(msg.m); err != nil {
+			Log("write shared object %s error: %v", msg.m.Name, err)
+		}
+	}
+}
+
+// 保存为JSON，[version, data]
+func (so *SharedObject) save() {
+	path := so.store.path(so.app, so.name)
+	if !so.persistent || path == "" {
+		return
+	}
+	js, err := amf0.EncodeJSON(so.version, so.data)
+	if err == nil {
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+			err = os.WriteFile(path, js, 0644)
+		}
+	}
+	if err != nil {
+		Log("save shared object %s error: %v", so.name, err)
+	}
+}
+
+func (so *SharedObject) load() {
+	path := so.store.path(so.app, so.name)
+	if path == "" {
+		return
+	}
+	js, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			Log("load shared object %s error: %v", so.name, err)
+		}
+		return
+	}
+	var data []byte
+	var arr amf0.Amfarr
+	if data, err = amf0.FromJSON(js); err == nil {
+		arr, err = amf0.Decode(data)
+	}
+	if err != nil || len(arr) != 2 {
+		Log("load shared object %s error: %v", so.name, err)
+		return
+	}
+	version, _ := arr.GetUint32(0)
+	kv, _ := arr.GetKV(1)
+	so.version = version
+	for k, v := range kv {
+		so.data[k] = v
+	}
+}
+
+// 处理客户端的共享对象消息
+func (c *conn) handleSharedObject(m *SharedObjectMessage) {
+	store := c.server.sharedObjects()
+	for _, ev := range m.Events {
+		switch ev.Type {
+		default:
+			Log("shared object %s: unexpected event %d", m.Name, ev.Type)
+
+		case SO_USE:
+			so := store.use(c.app, m.Name, m.Persistent, c, m.AMF3)
+			if c.sharedObjects == nil {
+				c.sharedObjects = make(map[string]*SharedObject)
+			}
+			c.sharedObjects[m.Name] = so
+
+		case SO_RELEASE:
+			if so, ok := c.sharedObjects[m.Name]; ok {
+				delete(c.sharedObjects, m.Name)
+				store.release(so, c)
+			}
+
+		case SO_REQUEST_CHANGE:
+			if so, ok := c.sharedObjects[m.Name]; ok {
+				so.requestChange(c, ev.Name, ev.Value)
+			}
+
+		case SO_REQUEST_REMOVE:
+			if so, ok := c.sharedObjects[m.Name]; ok {
+				so.requestRemove(ev.Name)
+			}
+
+		case SO_SEND_MESSAGE:
+			if so, ok := c.sharedObjects[m.Name]; ok {
+				so.sendMessage(ev.Args)
+			}
+		}
+	}
+}
+
+// 连接断开时取消订阅所有共享对象
+func (c *conn) releaseSharedObjects() {
+	store := c.server.sharedObjects()
+	for name, so := range c.sharedObjects {
+		delete(c.sharedObjects, name)
+		store.release(so, c)
+	}
+}
@@ -0,0 +1,99 @@
+package rtmp
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestSharedObjectMessage(t *testing.T) {
+	for _, amf3 := range []bool{false, true} {
+		m := SharedObjectMessage{"overlay", 3, true, []SharedObjectEvent{
+			{Type: SO_USE},
+			{Type: SO_REQUEST_CHANGE, Name: "score", Value: 1.0},
+			{Type: SO_SEND_MESSAGE, Args: []any{"flash", "red"}},
+			{Type: SO_STATUS, Name: "SharedObject.BadPersistence", Value: "error"},
+			{Type: SO_REMOVE, Name: "score"},
+		}, amf3}
+		bs, err := m.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := SharedObjectMessage{AMF3: amf3}
+		if err = out.Unmarshal(bs); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, m) {
+			t.Fatalf("%+v, expect %+v", out, m)
+		}
+		if out.Unmarshal(bs[:len(bs)-1]) == nil {
+			t.Fatal("expect error on truncated message")
+		}
+	}
+}
+
+// 返回每个消息的事件类型
+func soEvents(r *recorder) [][]uint8 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var types [][]uint8
+	for _, m := range r.msgs {
+		var ts []uint8
+		for _, ev := range m.(SharedObjectMessage).Events {
+			ts = append(ts, ev.Type)
+		}
+		types = append(types, ts)
+	}
+	r.msgs = nil
+	return types
+}
+
+func TestSharedObjectStore(t *testing.T) {
+	dir := t.TempDir()
+	store := NewSharedObjectStore(dir)
+	store.Persistent = func(app, name string) bool { return name != "tmp" }
+	a, b := &recorder{}, &recorder{}
+	so := store.use("live", "overlay", true, a, false)
+	so.requestChange(a, "title", "hello")
+	store.use("live", "overlay", true, b, true)
+	so.requestRemove("title")
+
+	if got := soEvents(a); !reflect.DeepEqual(got, [][]uint8{{SO_USE_SUCCESS, SO_CLEAR}, {SO_SUCCESS}, {SO_REMOVE}}) {
+		t.Fatalf("a: %v", got)
+	}
+	if got := soEvents(b); !reflect.DeepEqual(got, [][]uint8{{SO_USE_SUCCESS, SO_CLEAR, SO_CHANGE}, {SO_REMOVE}}) {
+		t.Fatalf("b: %v", got)
+	}
+	so.Set("score", 2)
+	if so.Version() != 3 {
+		t.Fatalf("version %d, expect 3", so.Version())
+	}
+
+	// 持久的共享对象没有订阅者时保留，新的store从磁盘加载
+	store.release(so, a)
+	store.release(so, b)
+	if _, ok := store.Lookup("live", "overlay"); !ok {
+		t.Fatal("persistent shared object removed")
+	}
+	so = NewSharedObjectStore(dir).Get("live", "overlay", true)
+	if v, _ := so.Get("score"); v != 2.0 || so.Version() != 3 {
+		t.Fatalf("load %v version %d", v, so.Version())
+	}
+
+	// 服务端不允许持久化时按非持久处理
+	tmp := store.use("live", "tmp", true, a, false)
+	store.release(tmp, a)
+	if _, ok := store.Lookup("live", "tmp"); ok {
+		t.Fatal("expect temporary shared object removed")
+	}
+
+	// 名称为..时不保存到文件
+	so = store.use("..", "..", true, a, false)
+	so.Set("x", 1)
+	if store.path("..", "..") != "" {
+		t.Fatal("expect no path for ..")
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Fatalf("%d entries in dir, expect 1", len(files))
+	}
+}
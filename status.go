@@ -0,0 +1,105 @@
+package rtmp
+
+import "strings"
+
+// NetConnection status code
+const (
+	NC_CALL_FAILED          = "NetConnection.Call.Failed"
+	NC_CALL_BAD_VERSION     = "NetConnection.Call.BadVersion"
+	NC_CALL_PROHIBITED      = "NetConnection.Call.Prohibited"
+	NC_CONNECT_SUCCESS      = "NetConnection.Connect.Success"
+	NC_CONNECT_CLOSED       = "NetConnection.Connect.Closed"
+	NC_CONNECT_FAILED       = "NetConnection.Connect.Failed"
+	NC_CONNECT_REJECTED     = "NetConnection.Connect.Rejected"
+	NC_CONNECT_APP_SHUTDOWN = "NetConnection.Connect.AppShutdown"
+	NC_CONNECT_INVALID_APP  = "NetConnection.Connect.InvalidApp"
+	NC_CONNECT_IDLE_TIMEOUT = "NetConnection.Connect.IdleTimeOut"
+)
+
+// NetStream status code
+const (
+	NS_FAILED                = "NetStream.Failed"
+	NS_PLAY_START            = "NetStream.Play.Start"
+	NS_PLAY_STOP             = "NetStream.Play.Stop"
+	NS_PLAY_RESET            = "NetStream.Play.Reset"
+	NS_PLAY_FAILED           = "NetStream.Play.Failed"
+	NS_PLAY_STREAM_NOT_FOUND = "NetStream.Play.StreamNotFound"
+	NS_PLAY_FILE_STRUCTURE   = "NetStream.Play.FileStructureInvalid"
+	NS_PLAY_COMPLETE         = "NetStream.Play.Complete"
+	NS_PLAY_SWITCH           = "NetStream.Play.Switch"
+	NS_PLAY_PUBLISH_NOTIFY   = "NetStream.Play.PublishNotify"
+	NS_PLAY_UNPUBLISH_NOTIFY = "NetStream.Play.UnpublishNotify"
+	NS_PUBLISH_START         = "NetStream.Publish.Start"
+	NS_PUBLISH_BAD_NAME      = "NetStream.Publish.BadName"
+	NS_PUBLISH_IDLE          = "NetStream.Publish.Idle"
+	NS_UNPUBLISH_SUCCESS     = "NetStream.Unpublish.Success"
+	NS_RECORD_START          = "NetStream.Record.Start"
+	NS_RECORD_STOP           = "NetStream.Record.Stop"
+	NS_RECORD_FAILED         = "NetStream.Record.Failed"
+	NS_RECORD_NO_ACCESS      = "NetStream.Record.NoAccess"
+	NS_SEEK_NOTIFY           = "NetStream.Seek.Notify"
+	NS_SEEK_FAILED           = "NetStream.Seek.Failed"
+	NS_SEEK_INVALID_TIME     = "NetStream.Seek.InvalidTime"
+	NS_PAUSE_NOTIFY          = "NetStream.Pause.Notify"
+	NS_UNPAUSE_NOTIFY        = "NetStream.Unpause.Notify"
+)
+
+// 处理函数可以返回的错误，服务器先回复对应的状态再关闭连接
+var (
+	ErrConnectRejected = &StatusError{Code: NC_CONNECT_REJECTED, Desc: "Connection rejected"}
+	ErrAppShutdown     = &StatusError{Code: NC_CONNECT_APP_SHUTDOWN, Desc: "Application shutdown"}
+	ErrInvalidApp      = &StatusError{Code: NC_CONNECT_INVALID_APP, Desc: "Invalid application"}
+	ErrBadName         = &StatusError{Code: NS_PUBLISH_BAD_NAME, Desc: "Stream already publishing"}
+	ErrStreamNotFound  = &StatusError{Code: NS_PLAY_STREAM_NOT_FOUND, Desc: "Stream not found"}
+	ErrPlayFailed      = &StatusError{Code: NS_PLAY_FAILED, Desc: "Play failed"}
+	ErrRecordNoAccess  = &StatusError{Code: NS_RECORD_NO_ACCESS, Desc: "No access to record"}
+)
+
+// StatusError 带状态码的错误。NetConnection的状态以_error回复命令，
+// NetStream的状态以level为error的onStatus发送。
+// 可以用errors.Is判断是否是预定义的错误，只比较Code
+type StatusError struct {
+	Code        string
+	Desc        string
+	Application any // 回复中的application字段，如重定向的地址
+}
+
+// NewStatusError 创建状态错误，可以和预定义的错误使用不同的描述
+func NewStatusError(code, desc string) *StatusError {
+	return &StatusError{Code: code, Desc: desc}
+}
+
+func (e *StatusError) Error() string {
+	if e.Desc == "" {
+		return "rtmp: " + e.Code
+	}
+	return "rtmp: " + e.Code + ": " + e.Desc
+}
+
+func (e *StatusError) Is(target error) bool {
+	t, ok := target.(*StatusError)
+	return ok && t.Code == e.Code
+}
+
+// 错误的回复，和respInfo相比多了application
+type errorInfo struct {
+	Level       level  `amf:"level"`
+	Code        string `amf:"code"`
+	Desc        string `amf:"description"`
+	Application any    `amf:"application,omitempty"`
+}
+
+// ResponseError 以_error回复事务ID为transId的命令，application可以为nil
+func ResponseError(w MessageWriter, transId uint32, code, desc string, application any) error {
+	info := errorInfo{LVL_ERROR, code, desc, application}
+	return w.WriteMessage(CommandMessage{RSP_ERROR, transId, []any{nil, info}})
+}
+
+// ResponseStatusError 按错误的状态码回复：NetConnection的以_error回复命令，其他的发送onStatus
+func ResponseStatusError(w MessageWriter, transId uint32, err *StatusError) error {
+	if strings.HasPrefix(err.Code, "NetConnection.") {
+		return ResponseError(w, transId, err.Code, err.Desc, err.Application)
+	}
+	info := errorInfo{LVL_ERROR, err.Code, err.Desc, err.Application}
+	return w.WriteMessage(CommandMessage{RSP_ON_STATUS, 0, []any{nil, info}})
+}
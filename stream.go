@@ -1,17 +1,24 @@
 package rtmp
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"io"
+	"os"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/chenyj/rtmp/encoding/amf0"
 	"github.com/chenyj/rtmp/encoding/av"
 )
 
 var cacheFrameSize = 3000
 
+// 订阅者等待配置帧的最长时间，超时后只发送已收到的配置帧
+var configTimeout = 3 * time.Second
+
 var streamPool = sync.Pool{
 	New: func() any {
 		return NewStream(cacheFrameSize)
@@ -33,97 +40,406 @@ type Streamer interface {
 	IsPublishing() bool
 	Publish()
 	Unpublish()
+	Close(reason string)
 	Subscribs() int32
+	Tracks() (hasAudio, hasVideo bool)
+	SetTimeShift(TimeShift) error
+	TimeRange() (oldest, latest uint32)
+	MediaInfo() MediaInfo
 }
 
 type Iterator interface {
 	Next() (*av.Packet, error)
 	Do(context.Context, func(*av.Packet) error) error
 	Release()
+	SetPolicy(SlowPolicy, func(dropped uint64))
+	Dropped() uint64
+	Generation() uint32
+	Seek(ms uint32) (uint32, error)
+}
+
+// SlowPolicy 订阅者落后于发布者时的处理策略
+type SlowPolicy uint8
+
+const (
+	SLOW_SKIP_TO_LATEST     SlowPolicy = iota // 跳到最新的关键帧
+	SLOW_SKIP_TO_OLDEST                       // 跳到缓存中最旧的关键帧
+	SLOW_DROP_NON_REFERENCE                   // 先丢弃非参考帧，仍被覆盖时跳到最旧的关键帧
+	SLOW_DISCONNECT                           // 返回ErrSlowSubscriber
+)
+
+var ErrSlowSubscriber = errors.New("rtmp: subscriber is too slow")
+
+// StreamEvent 流的状态变化，写入数据包队列，订阅者读到时由Iterator以error返回。
+//
+// STREAM_BEGIN: 新的发布者接管了流，之后会收到新的配置帧
+// STREAM_DRY:   发布者停止发布，流暂时没有数据
+// STREAM_EOF:   流已关闭，errors.Is(err, io.EOF)为true
+type StreamEvent struct {
+	Type   uint16 // user control message's event type
+	Reason string
+}
+
+func (e *StreamEvent) Error() string {
+	var name string
+	switch e.Type {
+	case STREAM_BEGIN:
+		name = "stream begin"
+	case STREAM_DRY:
+		name = "stream dry"
+	default:
+		name = "stream eof"
+	}
+	if e.Reason == "" {
+		return "rtmp: " + name
+	}
+	return "rtmp: " + name + ": " + e.Reason
+}
+
+func (e *StreamEvent) Is(target error) bool {
+	return target == io.EOF && e.Type == STREAM_EOF
 }
 
 func NewStream(size int) Streamer {
 	var s avStream
-	s.ring = New(size)
+	s.buf = newFanout(size)
 	s.size = size
-	s.Add(3)
-	s.ring.Add(1)
+	s.ready = make(chan struct{})
 	return &s
 }
 
+// 音视频轨道
+const (
+	trackAudio = 1 << iota
+	trackVideo
+)
+
 // A stream is a infinity sequence.
 type avStream struct {
-	sync.WaitGroup            // 读配置帧的锁
-	meta           *av.Packet // meta data
-	audio0         *av.Packet // audio config
-	video0         *av.Packet // video config
-	ring           *Ring      // 数据包队列
-	entry          *Ring      // last key frame
-	size           int        // 队列大小
-	sequence       uint64     // 数据包编号
-	onlyAudio      bool       // 是否只存储音频
-	isPublishing   atomicBool // 是否在发布
-	subscriber     int32      // 订阅者数量
+	mu           sync.Mutex    // 保护配置帧和队列写入
+	ready        chan struct{} // 配置帧就绪后关闭
+	isReady      bool
+	tracks       uint8       // onMetaData中声明的轨道，0表示未知
+	meta         *av.Packet  // meta data
+	audio0       *av.Packet  // audio config
+	video0       *av.Packet  // video config
+	naluSize     int         // video0中AVC NALU长度的字节数
+	pending      *av.Packet  // 新的video config，在下一个关键帧之前写入队列
+	generation   uint32      // 配置帧版本，audio/video config变化时加1
+	buf          *fanout     // 数据包队列
+	entry        uint64      // 最新关键帧的编号+1，0表示还没有关键帧
+	size         int         // 队列大小
+	isPublishing atomicBool  // 是否在发布
+	published    bool        // 是否曾经发布过
+	handoff      bool        // 新发布者接管，相同的配置帧也要写入队列
+	subscriber   int32       // 订阅者数量
+	latest       uint32      // 最新数据包的时间戳
+	dvr          *dvr        // 时移窗口，nil表示未开启
+	shift        []dvrPacket // 释放mu后写入时移窗口的数据包
+	info         MediaInfo   // 从配置帧解析的参数
 }
 
 // Write put a Packet to the stream sequence.
+// Write(nil) closes the stream.
+//
+// 收到onMetaData中声明的所有轨道的配置帧，或者收到第一个数据帧后，
+// 配置帧就绪。之后audio config变化时立即写入队列，video config变化时
+// 在下一个关键帧之前写入队列，订阅者按顺序收到新的配置帧。
 func (s *avStream) Write(p *av.Packet) {
 	if p == nil {
-		s.ring.Packet = nil
-		s.ring.sequence = s.sequence
-		s.ring = s.ring.Next()
-		s.sequence++
-		s.ring.Prev().Done()
+		s.Close("")
 		return
 	}
 
+	// 时移窗口的文件写入不持有mu，以免阻塞订阅者
+	s.mu.Lock()
+	s.put(p)
+	d, shift := s.dvr, s.shift
+	s.shift = nil
+	s.mu.Unlock()
+	for _, pk := range shift {
+		d.write(pk)
+	}
+}
+
+// 写入数据包，调用者持有mu
+func (s *avStream) put(p *av.Packet) {
 	switch {
-	case s.meta == nil && p.IsMeta():
+	case p.IsMeta():
 		s.meta = p
-		s.Done()
-		return
-	case s.audio0 == nil && p.IsAudio() && p.IsConfig:
+		if !s.isReady {
+			s.tracks = metaTracks(p.Payload)
+			s.checkReady()
+			return
+		}
+		// 重发的meta写入队列
+	case p.IsAudio() && p.IsConfig:
+		if s.audio0 != nil && !s.handoff && bytes.Equal(s.audio0.Payload, p.Payload) {
+			return
+		}
 		s.audio0 = p
-		s.Done()
-		return
-	case s.video0 == nil && p.IsVideo() && p.IsConfig:
-		s.video0 = p
-		s.Done()
+		s.info.setAudio(p)
+		s.generation++
+		if !s.isReady {
+			s.checkReady()
+			return
+		}
+	case p.IsVideo() && p.IsConfig:
+		if s.video0 != nil && !s.handoff && bytes.Equal(s.video0.Payload, p.Payload) {
+			s.pending = nil
+			return
+		}
+		if !s.isReady {
+			s.setVideo(p)
+			s.checkReady()
+			return
+		}
+		s.pending = p
 		return
+	default:
+		if p.IsAudio() && s.info.AudioCodec == "" {
+			s.info.setAudio(p)
+		}
+		if p.NonReference(s.naluSize) {
+			p.Disposable = true
+		}
+		s.handoff = false
+		// 收到数据帧时，之前没有收到的配置帧不再等待
+		s.setReady()
 	}
 
-	// 普通数据帧或重发的meta
+	// 普通数据帧、重发的meta或新的配置帧
 	// 写入数据帧
-	s.ring.Packet = p
-	s.ring.sequence = s.sequence
-	if p.IsKeyFrame {
-		s.entry = s.ring
+	if p.IsVideo() && p.IsKeyFrame && s.pending != nil {
+		s.setVideo(s.pending)
+		s.pending = nil
+		s.write(s.video0)
+	}
+	seq := s.write(p)
+	if p.IsKeyFrame && !p.IsConfig {
+		// 新订阅者从关键帧开始读取，配置帧由configFrame发送
+		atomic.StoreUint64(&s.entry, seq+1)
+	}
+	atomic.StoreUint32(&s.latest, p.Timestamp)
+}
+
+// 使用新的video config，MediaInfo和配置帧同时更新，调用者持有mu
+func (s *avStream) setVideo(p *av.Packet) {
+	s.video0, s.naluSize = p, p.NALULengthSize()
+	s.info.setVideo(p)
+	s.generation++
+}
+
+// 写入队列，开启时移时记录要写入时移窗口的数据包
+func (s *avStream) write(p *av.Packet) uint64 {
+	seq := s.buf.write(p, s.generation)
+	if s.dvr != nil {
+		hasAudio := s.audio0 != nil || s.tracks&trackAudio != 0
+		hasVideo := s.video0 != nil || s.tracks&trackVideo != 0
+		// 纯音频流的每个音频帧都可以定位
+		seekable := !p.IsConfig && (p.IsKeyFrame || (!hasVideo && p.IsAudio()))
+		var configs []*av.Packet
+		if seekable {
+			configs = s.configs()
+		}
+		s.shift = append(s.shift, dvrPacket{seq, s.generation, p, seekable, configs, hasAudio, hasVideo})
 	}
-	s.ring = s.ring.NextW()
-	s.sequence++
-	s.ring.Prev().Done()
+	return seq
+}
+
+// 收到meta和meta中声明的所有轨道的配置帧后就绪
+func (s *avStream) checkReady() {
+	if s.meta == nil || s.tracks == 0 {
+		return
+	}
+	if s.tracks&trackAudio != 0 && s.audio0 == nil {
+		return
+	}
+	if s.tracks&trackVideo != 0 && s.video0 == nil {
+		return
+	}
+	s.setReady()
+}
+
+func (s *avStream) setReady() {
+	if !s.isReady {
+		s.isReady = true
+		close(s.ready)
+	}
+}
+
+// 从onMetaData中获取hasAudio/hasVideo或audiocodecid/videocodecid，
+// 没有声明任何轨道时返回0
+func metaTracks(payload []byte) (tracks uint8) {
+	ar, err := amf0.Decode(payload)
+	if err != nil {
+		return 0
+	}
+	for _, v := range ar {
+		kv, ok := v.(amf0.Amfkv)
+		if !ok {
+			continue
+		}
+		if has, ok := kv.GetBool("hasAudio"); ok {
+			if has {
+				tracks |= trackAudio
+			}
+		} else if _, ok := kv.GetFloat64("audiocodecid"); ok {
+			tracks |= trackAudio
+		}
+		if has, ok := kv.GetBool("hasVideo"); ok {
+			if has {
+				tracks |= trackVideo
+			}
+		} else if _, ok := kv.GetFloat64("videocodecid"); ok {
+			tracks |= trackVideo
+		}
+		return tracks
+	}
+	return 0
 }
 
 func (s *avStream) GetConfigFrame() []*av.Packet {
-	s.Wait()
-	packets := []*av.Packet{s.meta}
-	if !s.onlyAudio {
-		packets = append(packets, s.video0)
+	packets, _, _ := s.configFrame(context.Background())
+	return packets
+}
+
+// 当前的配置帧及其版本，最多等待configTimeout。
+// 配置帧就绪之前ctx结束时返回ctx.Err()
+func (s *avStream) configFrame(ctx context.Context) ([]*av.Packet, uint32, error) {
+	timer := time.NewTimer(configTimeout)
+	defer timer.Stop()
+	select {
+	case <-s.ready:
+	case <-timer.C:
+	case <-ctx.Done():
+		select {
+		case <-s.ready:
+		default:
+			return nil, 0, ctx.Err()
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.configs(), s.generation, nil
+}
+
+// 当前的配置帧，调用者持有mu
+func (s *avStream) configs() []*av.Packet {
+	packets := make([]*av.Packet, 0, 3)
+	for _, p := range []*av.Packet{s.meta, s.video0, s.audio0} {
+		if p != nil {
+			packets = append(packets, p)
+		}
 	}
-	packets = append(packets, s.audio0)
 	return packets
 }
 
+// SetTimeShift 开启或关闭时移窗口，关闭或重新设置时删除已有的时移数据。
+// 开启后订阅者可以通过Iterator.Seek定位到窗口中的任意关键帧。
+func (s *avStream) SetTimeShift(opt TimeShift) error {
+	if opt.Dir != "" {
+		if err := os.MkdirAll(opt.Dir, 0755); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	d := s.dvr
+	s.dvr = nil
+	if opt.Window > 0 {
+		s.dvr = newDVR(opt)
+	}
+	s.mu.Unlock()
+	if d != nil {
+		d.close()
+	}
+	return nil
+}
+
+// TimeRange 返回可以定位的时间范围，毫秒。
+// 开启时移时从时移窗口的开始，否则从缓存中最旧的关键帧开始。
+func (s *avStream) TimeRange() (oldest, latest uint32) {
+	latest = atomic.LoadUint32(&s.latest)
+	oldest = latest
+	if d := s.timeShift(); d != nil {
+		if ts, ok := d.oldest(); ok {
+			return ts, latest
+		}
+	}
+	if sl, _ := s.buf.get(s.oldestKeyFrame()); sl != nil && sl.packet != nil {
+		oldest = sl.packet.Timestamp
+	}
+	return
+}
+
+func (s *avStream) timeShift() *dvr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dvr
+}
+
+// Tracks 返回流中是否有音频和视频
+func (s *avStream) Tracks() (hasAudio, hasVideo bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hasAudio = s.audio0 != nil || s.tracks&trackAudio != 0
+	hasVideo = s.video0 != nil || s.tracks&trackVideo != 0
+	return
+}
+
+// MediaInfo 返回从配置帧中解析的音视频参数
+func (s *avStream) MediaInfo() MediaInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.info
+}
+
 func (s *avStream) IsPublishing() bool {
 	return s.isPublishing.isSet()
 }
 
+// Publish 开始发布。流曾经发布过时由新的发布者接管，
+// 订阅者收到STREAM_BEGIN事件后继续读取新发布者的配置帧和数据。
 func (s *avStream) Publish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.published {
+		s.handoff = true
+		s.buf.writeErr(&StreamEvent{Type: STREAM_BEGIN, Reason: "publisher changed"})
+	}
+	s.published = true
 	s.isPublishing.setTrue()
 }
 
+// Unpublish 停止发布，订阅者收到STREAM_DRY事件，流可以被新的发布者接管
 func (s *avStream) Unpublish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.isPublishing.isSet() {
+		return
+	}
 	s.isPublishing.setFalse()
+	s.buf.writeErr(&StreamEvent{Type: STREAM_DRY, Reason: "unpublished"})
+}
+
+// Close 关闭流，订阅者收到带reason的STREAM_EOF事件。
+// 时移窗口中的数据被删除，保留时移的配置
+func (s *avStream) Close(reason string) {
+	s.mu.Lock()
+	s.isPublishing.setFalse()
+	s.published = false
+	s.handoff = false
+	s.pending = nil
+	s.buf.writeErr(&StreamEvent{Type: STREAM_EOF, Reason: reason})
+	d := s.dvr
+	if d != nil {
+		s.dvr = newDVR(d.opt)
+	}
+	s.mu.Unlock()
+	if d != nil {
+		d.close()
+	}
 }
 
 func (s *avStream) Subscribs() int32 {
@@ -135,121 +451,227 @@ func (s *avStream) Iterator() Iterator {
 	return &iterator{s: s}
 }
 
+// 最新关键帧的编号，没有关键帧或已被覆盖时返回下一个写入的编号
+func (s *avStream) latestKeyFrame() uint64 {
+	e := atomic.LoadUint64(&s.entry)
+	if e == 0 || e-1 < s.buf.oldest() {
+		return s.buf.next()
+	}
+	return e - 1
+}
+
+// 缓存中最旧关键帧的编号
+func (s *avStream) oldestKeyFrame() uint64 {
+	for seq, head := s.buf.oldest(), s.buf.next(); seq < head; seq++ {
+		sl, _ := s.buf.get(seq)
+		if sl != nil && sl.packet != nil && sl.packet.IsKeyFrame {
+			return seq
+		}
+	}
+	return s.latestKeyFrame()
+}
+
 // 流迭代器
 type iterator struct {
-	s        *avStream
-	r        *Ring
-	sequence uint64
-	status   uint8
-	// need mutex to ensure concurrent safe
+	s          *avStream
+	sequence   uint64       // 下一个读取的编号
+	started    bool         // 是否已定位到流的入口
+	configured bool         // 是否已取得配置帧
+	pending    []*av.Packet // 待发送的配置帧
+	generation uint32       // 已发送的配置帧版本
+	shift      *dvrCursor   // 在时移窗口中读取，追上缓存后为nil
+	policy     SlowPolicy
+	onDrop     func(dropped uint64) // 丢帧回调
+	dropped    uint64               // 累计丢弃的数据包数
 }
 
 func (i *iterator) Next() (p *av.Packet, err error) {
 	if i.s == nil {
 		return nil, errors.New("invalid iterator on nil stream")
 	}
+	return i.read(context.Background())
+}
 
-	switch i.status {
-	case 0:
-		i.s.Wait()
-		i.status |= 1
-		return i.s.meta, nil
-	case 1:
-		i.s.Wait()
-		i.status |= 2
-		return i.s.video0, nil
-	case 3:
-		i.s.Wait()
-		i.status |= 4
-		return i.s.audio0, nil
+// Do calls fn on each packet until fn or the stream returns an error or
+// ctx is done. Config frames are only sent on the first call, so Do can be
+// called again after a STREAM_BEGIN or STREAM_DRY event.
+func (i *iterator) Do(ctx context.Context, fn func(*av.Packet) error) (err error) {
+	if i.s == nil {
+		return errors.New("invalid iterator on nil stream")
 	}
-
-	if i.r == nil {
-		i.moveTo(i.s.entry)
+	for ctx.Err() == nil {
+		p, err := i.read(ctx)
+		if err != nil {
+			if err == ctx.Err() {
+				return nil
+			}
+			return err
+		}
+		if err = fn(p); err != nil {
+			return err
+		}
 	}
-	i.r.Wait()
+	return nil
+}
 
-	innerSequence := i.r.sequence
-	switch {
-	case i.sequence == innerSequence:
-		// 正常读取
-		if i.r.Packet == nil {
-			err = io.EOF
-		} else {
-			p = i.r.Packet
-			i.r = i.r.Next()
-			i.sequence++
+// Seek 定位到ms之前最近的关键帧，并重新发送配置帧，返回关键帧的时间戳。
+// ms早于缓存中最旧的关键帧时，开启了时移则从时移窗口中读取，否则定位到最旧的关键帧。
+func (i *iterator) Seek(ms uint32) (uint32, error) {
+	_, hasVideo := i.s.Tracks()
+	var target uint64
+	var timestamp uint32
+	found := false
+	for seq, head := i.s.buf.oldest(), i.s.buf.next(); seq < head; seq++ {
+		sl, _ := i.s.buf.get(seq)
+		if sl == nil || sl.packet == nil || sl.packet.IsConfig {
+			continue
 		}
-	case i.sequence < innerSequence:
-		// 数据被覆盖，启动丢帧
-		r := i.s.ring
-		for r.Packet != nil && !r.IsKeyFrame {
-			//TODO: check r.Value.(packet).Packet != nil
-			r = r.Next()
+		p := sl.packet
+		// 纯音频流的每个音频帧都可以定位
+		if !p.IsKeyFrame && (hasVideo || !p.IsAudio()) {
+			continue
 		}
-		if r.Packet == nil {
-			err = io.EOF
-		} else {
-			p = r.Packet
-			i.moveTo(r.Next())
+		if found && p.Timestamp > ms {
+			break
+		}
+		target, timestamp, found = seq, p.Timestamp, true
+		if p.Timestamp > ms {
+			break
 		}
-	case i.sequence > innerSequence:
-		// 超过了uint64的上限
-		err = errors.New("bad sequence")
 	}
-	return
-}
-
-func (i *iterator) Do(ctx context.Context, fn func(*av.Packet) error) (err error) {
-	if i.s == nil {
-		return errors.New("invalid iterator on nil stream")
+	if !found || timestamp > ms {
+		if d := i.s.timeShift(); d != nil {
+			if c, e, ok := d.seek(ms); ok && (!found || e.timestamp < timestamp) {
+				i.seekShift(c, e)
+				return e.timestamp, nil
+			}
+		}
 	}
-	i.s.Wait()
-	if err = fn(i.s.meta); err != nil {
-		return
+	if !found {
+		return 0, ErrSeekFailed
 	}
-	if !i.s.onlyAudio {
-		if err = fn(i.s.video0); err != nil {
-			return
+	i.sequence = target
+	i.started = true
+	i.configured = true
+	i.shift = nil
+	i.pending, i.generation, _ = i.s.configFrame(context.Background())
+	return timestamp, nil
+}
+
+// 从时移窗口中的e处开始读取
+func (i *iterator) seekShift(c *dvrCursor, e dvrEntry) {
+	i.shift = c
+	i.sequence = e.sequence
+	i.started = true
+	i.configured = true
+	i.pending = append(i.pending[:0], c.seg.configs...)
+	i.generation = c.seg.generation
+}
+
+// 从时移窗口中读取，追上缓存或定位到窗口开始时返回nil
+func (i *iterator) readShift() *slot {
+	sl, err := i.shift.next()
+	if err == errOverwritten {
+		// 时移数据已过期，从窗口的开始继续
+		if c, e, ok := i.shift.d.seek(0); ok {
+			if e.sequence > i.sequence {
+				i.drop(e.sequence - i.sequence)
+			}
+			i.seekShift(c, e)
+			return nil
 		}
+	} else if err != nil {
+		Log("time shift: %v", err)
 	}
-	if err = fn(i.s.audio0); err != nil {
-		return
+	// 离缓存中最旧的数据还有一段距离时才切换，避免切换后马上被覆盖
+	if sl != nil && sl.sequence < i.s.buf.oldest()+i.s.buf.size/4 {
+		i.sequence = sl.sequence + 1
+		i.generation = sl.generation
+		return sl
 	}
+	i.shift = nil
+	if sl != nil {
+		i.sequence = sl.sequence
+	}
+	return nil
+}
 
-	if i.r == nil {
+// Generation 返回最近发送给订阅者的配置帧版本，配置帧变化时增加
+func (i *iterator) Generation() uint32 {
+	return i.generation
+}
+
+// 读取下一个数据包，数据被覆盖时按策略丢帧
+func (i *iterator) read(ctx context.Context) (*av.Packet, error) {
+	if !i.configured {
+		packets, generation, err := i.s.configFrame(ctx)
+		if err != nil {
+			return nil, err
+		}
+		i.pending, i.generation = packets, generation
+		i.configured = true
+	}
+	if !i.started {
 		// find entry to the stream
-		i.moveTo(i.s.entry)
+		i.sequence = i.s.latestKeyFrame()
+		i.started = true
 	}
 	for {
-		select {
-		default:
-			i.r.Wait()
-			innerSequence := i.r.sequence
-			switch {
-			case i.sequence == innerSequence:
-				// 正常读取
-				if i.r.Packet == nil {
-					return io.EOF
-				}
-				if err = fn(i.r.Packet); err != nil {
-					return
+		for len(i.pending) > 0 {
+			p := i.pending[0]
+			i.pending = i.pending[1:]
+			if p != nil {
+				return p, nil
+			}
+		}
+		if i.shift != nil {
+			if sl := i.readShift(); sl != nil {
+				return sl.packet, nil
+			}
+			continue
+		}
+
+		sl, err := i.s.buf.read(ctx, i.sequence)
+		switch {
+		case err == errOverwritten:
+			if err = i.catchUp(); err != nil {
+				return nil, err
+			}
+			continue
+		case err != nil:
+			return nil, err
+		case sl.err != nil:
+			// 流关闭后停在原处，其它事件只返回一次
+			if e, ok := sl.err.(*StreamEvent); ok && e.Type != STREAM_EOF {
+				i.sequence++
+			}
+			return nil, sl.err
+		}
+
+		p := sl.packet
+		if sl.generation > i.generation {
+			if !p.IsConfig {
+				// 新的配置帧被跳过了，先发送当前的配置帧
+				packets, generation, err := i.s.configFrame(ctx)
+				if err != nil {
+					return nil, err
 				}
-				i.next()
-			case i.sequence < innerSequence:
-				// 数据被覆盖，启动丢帧
-				r := i.s.ring
-				for r.Packet != nil && !r.IsKeyFrame {
-					r = r.Next()
+				i.generation = generation
+				for _, c := range packets {
+					if c.IsConfig {
+						i.pending = append(i.pending, c)
+					}
 				}
-				i.moveTo(r)
-			case i.sequence > innerSequence:
-				// 超过了uint64的上限
-				return errors.New("bad sequence")
+				continue
 			}
-		case <-ctx.Done():
-			return
+			i.generation = sl.generation
+		}
+		i.sequence++
+		if i.skippable(p) {
+			i.drop(1)
+			continue
 		}
+		return p, nil
 	}
 }
 
@@ -257,12 +679,46 @@ func (i *iterator) Release() {
 	atomic.AddInt32(&i.s.subscriber, -1)
 }
 
-func (i *iterator) moveTo(r *Ring) {
-	i.r = r
-	i.sequence = r.sequence
+// SetPolicy 设置落后时的处理策略，onDrop在每次丢帧后以本次丢弃的包数回调
+func (i *iterator) SetPolicy(policy SlowPolicy, onDrop func(dropped uint64)) {
+	i.policy = policy
+	i.onDrop = onDrop
 }
 
-func (i *iterator) next() {
-	i.r = i.r.Next()
-	i.sequence++
+// Dropped 返回累计丢弃的数据包数
+func (i *iterator) Dropped() uint64 {
+	return i.dropped
+}
+
+// 数据被覆盖后，按策略重新定位读取位置
+func (i *iterator) catchUp() error {
+	var seq uint64
+	switch i.policy {
+	case SLOW_DISCONNECT:
+		return ErrSlowSubscriber
+	case SLOW_SKIP_TO_LATEST:
+		seq = i.s.latestKeyFrame()
+	default:
+		seq = i.s.oldestKeyFrame()
+	}
+	if seq > i.sequence {
+		i.drop(seq - i.sequence)
+	}
+	i.sequence = seq
+	return nil
+}
+
+// 落后超过一半缓存时丢弃非参考帧
+func (i *iterator) skippable(p *av.Packet) bool {
+	if i.policy != SLOW_DROP_NON_REFERENCE || !p.Disposable {
+		return false
+	}
+	return i.s.buf.next()-i.sequence > i.s.buf.size/2
+}
+
+func (i *iterator) drop(n uint64) {
+	i.dropped += n
+	if i.onDrop != nil {
+		i.onDrop(n)
+	}
 }
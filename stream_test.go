@@ -0,0 +1,289 @@
+package rtmp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/chenyj/rtmp/encoding/amf0"
+	"github.com/chenyj/rtmp/encoding/av"
+)
+
+func testPacket(i int) *av.Packet {
+	// 每25帧一个关键帧
+	frameType := byte(0x27)
+	if i%25 == 0 {
+		frameType = 0x17
+	}
+	return av.VideoPack(uint32(i), []byte{frameType, 1, 0, 0, 0})
+}
+
+func writeConfig(s Streamer) {
+	s.Write(av.MetaPack(0, []byte{0x02, 0x00, 0x00}))
+	s.Write(av.VideoPack(0, []byte{0x17, 0, 0, 0, 0}))
+	s.Write(av.AudioPack(0, []byte{0xAF, 0, 0x12, 0x10}))
+}
+
+func TestIteratorSlowPolicy(t *testing.T) {
+	s := NewStream(50)
+	writeConfig(s)
+	s.Write(testPacket(0))
+
+	policies := []SlowPolicy{SLOW_SKIP_TO_LATEST, SLOW_SKIP_TO_OLDEST, SLOW_DISCONNECT}
+	its := make([]Iterator, len(policies))
+	for n := range its {
+		its[n] = s.Iterator()
+		for i := 0; i < 3; i++ {
+			its[n].Next() // config frames
+		}
+		if p, err := its[n].Next(); err != nil || p.Timestamp != 0 {
+			t.Fatalf("read first packet: %v %v", p, err)
+		}
+	}
+	for i := 1; i < 120; i++ {
+		s.Write(testPacket(i))
+	}
+
+	var reported uint64
+	its[0].SetPolicy(policies[0], func(n uint64) { reported += n })
+	p, err := its[0].Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Timestamp != 100 || !p.IsKeyFrame {
+		t.Fatalf("expect latest key frame 100, got %d", p.Timestamp)
+	}
+	if reported != 99 || its[0].Dropped() != 99 {
+		t.Fatalf("dropped %d reported %d, expect 99", its[0].Dropped(), reported)
+	}
+
+	its[1].SetPolicy(policies[1], nil)
+	if p, _ := its[1].Next(); p.Timestamp != 75 {
+		t.Fatalf("expect oldest key frame 75, got %d", p.Timestamp)
+	}
+
+	its[2].SetPolicy(policies[2], nil)
+	if _, err := its[2].Next(); err != ErrSlowSubscriber {
+		t.Fatalf("expect ErrSlowSubscriber, got %v", err)
+	}
+}
+
+func TestIteratorDropNonReference(t *testing.T) {
+	s := NewStream(50)
+	s.Write(av.MetaPack(0, []byte{0x02, 0x00, 0x00}))
+	// lengthSizeMinusOne为1，NALU长度是2字节
+	s.Write(av.VideoPack(0, []byte{0x17, 0, 0, 0, 0, 1, 0x64, 0, 0x1F, 0xFD, 0xE0, 0}))
+	s.Write(av.AudioPack(0, []byte{0xAF, 0, 0x12, 0x10}))
+	// 关键帧，奇数是非参考帧，偶数是参考帧
+	frame := func(i int) *av.Packet {
+		switch {
+		case i%25 == 0:
+			return av.VideoPack(uint32(i), []byte{0x17, 1, 0, 0, 0, 0, 2, 0x65, 0x88})
+		case i%2 == 1:
+			return av.VideoPack(uint32(i), []byte{0x27, 1, 0, 0, 0, 0, 2, 0x01, 0x9A})
+		}
+		return av.VideoPack(uint32(i), []byte{0x27, 1, 0, 0, 0, 0, 2, 0x41, 0x9A})
+	}
+	s.Write(frame(0))
+
+	it := s.Iterator()
+	it.SetPolicy(SLOW_DROP_NON_REFERENCE, nil)
+	for i := 0; i < 4; i++ {
+		it.Next()
+	}
+	for i := 1; i < 46; i++ {
+		s.Write(frame(i))
+	}
+	next := 1
+	for next < 46 {
+		p, err := it.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for ; next < int(p.Timestamp); next++ {
+			if next%2 == 0 || next%25 == 0 {
+				t.Fatalf("reference frame %d dropped", next)
+			}
+		}
+		next++
+	}
+	if it.Dropped() == 0 {
+		t.Fatal("no non-reference frame dropped")
+	}
+}
+
+func TestStreamConfigChange(t *testing.T) {
+	s := NewStream(100)
+	writeConfig(s)
+	s.Write(testPacket(0))
+
+	it := s.Iterator()
+	for i := 0; i < 4; i++ {
+		it.Next()
+	}
+	gen := it.Generation()
+
+	// 新的SPS/PPS在下一个关键帧之前写入
+	config := av.VideoPack(10, []byte{0x17, 0, 0, 0, 0, 2})
+	s.Write(config)
+	s.Write(testPacket(1))
+	if s.GetConfigFrame()[1] == config {
+		t.Fatal("config frame changed before key frame")
+	}
+	s.Write(testPacket(25))
+	if s.GetConfigFrame()[1] != config {
+		t.Fatal("config frame not changed after key frame")
+	}
+
+	for _, ts := range []uint32{1, 10, 25} {
+		if p, err := it.Next(); err != nil || p.Timestamp != ts {
+			t.Fatalf("expect packet %d, got %v %v", ts, p, err)
+		}
+	}
+	if it.Generation() != gen+1 {
+		t.Fatalf("generation %d, expect %d", it.Generation(), gen+1)
+	}
+
+	// 新的订阅者先读取当前的配置帧，不会重复收到新的配置帧
+	late := s.Iterator()
+	for i, want := range []uint32{0, 10, 0, 25} {
+		if p, err := late.Next(); err != nil || p.Timestamp != want {
+			t.Fatalf("late joiner packet %d: expect %d, got %v %v", i, want, p, err)
+		}
+	}
+}
+
+func TestStreamHandoff(t *testing.T) {
+	s := NewStream(100)
+	s.Publish()
+	writeConfig(s)
+	s.Write(testPacket(0))
+
+	it := s.Iterator()
+	for i := 0; i < 4; i++ {
+		if _, err := it.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.Unpublish()
+	var ev *StreamEvent
+	if _, err := it.Next(); !errors.As(err, &ev) || ev.Type != STREAM_DRY {
+		t.Fatalf("expect stream dry, got %v", err)
+	}
+
+	// 新的发布者接管，配置帧写入队列
+	s.Publish()
+	config := av.VideoPack(0, []byte{0x17, 0, 0, 0, 0, 1})
+	s.Write(config)
+	s.Write(testPacket(25))
+	if _, err := it.Next(); !errors.As(err, &ev) || ev.Type != STREAM_BEGIN {
+		t.Fatalf("expect stream begin, got %v", err)
+	}
+	if p, err := it.Next(); err != nil || p != config {
+		t.Fatalf("expect new config frame, got %v %v", p, err)
+	}
+	if p, err := it.Next(); err != nil || p.Timestamp != 25 {
+		t.Fatalf("expect packet 25, got %v %v", p, err)
+	}
+	if s.GetConfigFrame()[1] != config {
+		t.Fatal("config frame is not replaced")
+	}
+
+	s.Close("shutdown")
+	for i := 0; i < 2; i++ {
+		_, err := it.Next()
+		if !errors.Is(err, io.EOF) || !errors.As(err, &ev) || ev.Reason != "shutdown" {
+			t.Fatalf("expect eof with reason, got %v", err)
+		}
+	}
+}
+
+func TestStreamAudioOnly(t *testing.T) {
+	meta, err := amf0.Encode("onMetaData", map[string]any{"hasAudio": true, "hasVideo": false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewStream(100)
+	s.Write(av.MetaPack(0, meta))
+	audio := av.AudioPack(0, []byte{0xAF, 0, 0x12, 0x10})
+	s.Write(audio)
+
+	// 不需要等待视频配置帧和数据帧
+	done := make(chan []*av.Packet)
+	go func() { done <- s.GetConfigFrame() }()
+	select {
+	case packets := <-done:
+		if len(packets) != 2 || packets[1] != audio {
+			t.Fatalf("unexpect config frames: %v", packets)
+		}
+	case <-time.After(configTimeout / 2):
+		t.Fatal("wait config frame of audio only stream")
+	}
+	if hasAudio, hasVideo := s.Tracks(); !hasAudio || hasVideo {
+		t.Fatalf("tracks audio(%v) video(%v)", hasAudio, hasVideo)
+	}
+	if info := s.MediaInfo(); info.AudioCodec != "aac" || info.SampleRate != 44100 || info.Channels != 2 || info.AudioObjectType != 2 {
+		t.Fatalf("media info: %+v", info)
+	}
+}
+
+func TestStreamConfigTimeout(t *testing.T) {
+	old := configTimeout
+	configTimeout = 50 * time.Millisecond
+	defer func() { configTimeout = old }()
+
+	// 没有meta的视频流，也没有数据帧
+	s := NewStream(100)
+	video := av.VideoPack(0, []byte{0x17, 0, 0, 0, 0})
+	s.Write(video)
+	it := s.Iterator()
+	if p, err := it.Next(); err != nil || p != video {
+		t.Fatalf("expect video config after timeout, got %v %v", p, err)
+	}
+
+	// 等待配置帧时ctx结束，之后仍然先发送配置帧
+	s = NewStream(100)
+	it = s.Iterator()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := it.Do(ctx, func(*av.Packet) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	writeConfig(s)
+	var first *av.Packet
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	it.Do(ctx, func(p *av.Packet) error {
+		first = p
+		return io.EOF
+	})
+	if first == nil || !first.IsMeta() {
+		t.Fatalf("expect meta after canceled Do, got %v", first)
+	}
+}
+
+func TestIteratorSeek(t *testing.T) {
+	s := NewStream(100)
+	writeConfig(s)
+	for i := 0; i < 90; i++ {
+		s.Write(testPacket(i))
+	}
+	it := s.Iterator()
+	for _, c := range []struct{ ms, key uint32 }{{60, 50}, {0, 0}, {89, 75}} {
+		ts, err := it.Seek(c.ms)
+		if err != nil || ts != c.key {
+			t.Fatalf("seek %d: got %d %v, expect %d", c.ms, ts, err, c.key)
+		}
+		for n := 0; n < 3; n++ {
+			if p, _ := it.Next(); !p.IsConfig && !p.IsMeta() {
+				t.Fatalf("seek %d: expect config frames first", c.ms)
+			}
+		}
+		if p, err := it.Next(); err != nil || p.Timestamp != c.key || !p.IsKeyFrame {
+			t.Fatalf("seek %d: got %v %v", c.ms, p, err)
+		}
+	}
+}
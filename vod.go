@@ -0,0 +1,141 @@
+package rtmp
+
+import (
+	"errors"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/chenyj/rtmp/encoding/av"
+)
+
+var errInvalidPath = errors.New("rtmp: invalid stream path")
+
+// VOD 点播Handler，把App上的play请求映射为Root目录下的FLV文件，
+// 按实际速度发送，支持seek和pause，其它请求交给Next处理。
+//
+//	vod := &rtmp.VOD{Root: "/data/videos"}
+//	rtmp.ListenAndServe("", vod)
+//
+// rtmp://host/vod/movie 播放/data/videos/movie.flv
+type VOD struct {
+	App  string  // 点播的app，默认为vod
+	Root string  // 文件所在的目录
+	Next Handler // 其它请求的Handler，默认为DefaultServeMux
+}
+
+func (v *VOD) app() string {
+	if v.App == "" {
+		return "vod"
+	}
+	return v.App
+}
+
+func (v *VOD) next() Handler {
+	if v.Next == nil {
+		return DefaultServeMux
+	}
+	return v.Next
+}
+
+func (v *VOD) OnCommand(w MessageWriter, r *Request) error {
+	if r.App != v.app() {
+		return v.next().OnCommand(w, r)
+	}
+	switch r.Command {
+	case CMD_PLAY:
+		return v.play(w, r)
+	case CMD_GET_STREAM_LENGTH:
+		var length float64
+		if it, err := v.Open(r.StreamPath); err == nil {
+			length = float64(it.Duration()) / 1000
+			it.Release()
+		}
+		return ResponseStreamLength(w, r.TransactionID, length)
+	case CMD_PUBLISH:
+		return ResponsePublish(w, false, "vod app is read only")
+	}
+	return v.next().OnCommand(w, r)
+}
+
+func (v *VOD) OnData(app, path string, p *av.Packet) error {
+	if app == v.app() {
+		return nil
+	}
+	return v.next().OnData(app, path, p)
+}
+
+// Open 打开streamPath对应的文件，不允许访问Root以外的文件
+func (v *VOD) Open(streamPath string) (*FileIterator, error) {
+	name, err := v.filename(streamPath)
+	if err != nil {
+		return nil, err
+	}
+	return NewFileIterator(name)
+}
+
+// 流名称转换为Root下的文件名，没有扩展名时加上.flv
+func (v *VOD) filename(streamPath string) (string, error) {
+	return rootFilename(v.Root, streamPath)
+}
+
+func rootFilename(root, streamPath string) (string, error) {
+	if streamPath == "" || strings.ContainsAny(streamPath, "\\\x00") {
+		return "", errInvalidPath
+	}
+	// 以/为根清理后，..不会超出根目录
+	name := path.Clean("/" + streamPath)
+	if name == "/" {
+		return "", errInvalidPath
+	}
+	if path.Ext(name) == "" {
+		name += ".flv"
+	}
+	return filepath.Join(root, filepath.FromSlash(name)), nil
+}
+
+// play命令的start参数大于0时从该位置开始，duration参数大于0时只播放这么长时间
+func (v *VOD) play(w MessageWriter, r *Request) error {
+	it, err := v.Open(r.StreamPath)
+	if err != nil {
+		Log("vod: open %s: %v", r.StreamPath, err)
+		return ResponsePlay(w, false, r.StreamPath+" not found")
+	}
+	it.SetRealtime(true)
+	var pos uint32
+	if r.Start > 0 {
+		// 从定位到的关键帧开始计算duration
+		if pos, err = it.Seek(uint32(r.Start * 1000)); err != nil {
+			pos = 0
+		}
+	}
+	if r.Duration > 0 {
+		it.SetEnd(pos + uint32(r.Duration*1000))
+	}
+
+	msgs := []Messager{
+		UserControlMessage{STREAM_IS_RECORDED, defaultMsid, 0},
+		UserControlMessage{STREAM_BEGIN, defaultMsid, 0},
+	}
+	if r.Reset {
+		msgs = append(msgs, statusMessage(LVL_STATUS, NS_PLAY_RESET, "Playing and resetting "+r.StreamPath))
+	}
+	for _, m := range msgs {
+		if err = w.WriteMessage(m); err != nil {
+			it.Release()
+			return err
+		}
+	}
+	if err = ResponsePlay(w, true, ""); err != nil {
+		it.Release()
+		return err
+	}
+
+	ctx := r.Context()
+	go func(ps *PlaySession, name string) {
+		// 客户端断开时结束，暂停中也会退出并关闭文件
+		err := ps.Serve(ctx)
+		Log("vod: %s: %v", name, err)
+	}(NewPlaySession(w, it), r.StreamPath)
+	return nil
+}
@@ -0,0 +1,164 @@
+package rtmp
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chenyj/rtmp/encoding/flv"
+)
+
+type recorder struct {
+	mu   sync.Mutex
+	msgs []Messager
+}
+
+func (r *recorder) WriteMessage(m Messager) error {
+	r.mu.Lock()
+	r.msgs = append(r.msgs, m)
+	r.mu.Unlock()
+	return nil
+}
+
+// 返回所有onStatus和onPlayStatus的code
+func (r *recorder) codes() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var codes []string
+	for _, m := range r.msgs {
+		var arr []any
+		switch m := m.(type) {
+		case CommandMessage:
+			arr = m.arr
+		case DataMessage:
+			arr = m.arr
+		}
+		for _, v := range arr {
+			if info, ok := v.(respInfo); ok {
+				codes = append(codes, info.Code)
+			}
+		}
+	}
+	return codes
+}
+
+// 写入10帧的FLV文件，每帧40毫秒，第0和第5帧是关键帧
+func writeTestFLV(t *testing.T, name string) {
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := flv.NewTagWriter(f)
+	w.WriteFlvHeader(true, true)
+	w.WriteTag(flv.FLV_TAG_DATA, 0, []byte{0x02, 0x00, 0x00})
+	w.WriteTag(flv.FLV_TAG_VIDEO, 0, []byte{0x17, 0, 0, 0, 0})
+	w.WriteTag(flv.FLV_TAG_AUDIO, 0, []byte{0xAF, 0, 0x12, 0x10})
+	for i := 0; i < 10; i++ {
+		frameType := byte(0x27)
+		if i%5 == 0 {
+			frameType = 0x17
+		}
+		w.WriteTag(flv.FLV_TAG_VIDEO, uint32(i*40), []byte{frameType, 1, 0, 0, 0})
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVODFilename(t *testing.T) {
+	v := &VOD{Root: "/data"}
+	cases := map[string]string{
+		"movie":           "/data/movie.flv",
+		"a/b.flv":         "/data/a/b.flv",
+		"../../etc/movie": "/data/etc/movie.flv",
+		"/abs/../x.flv":   "/data/x.flv",
+	}
+	for in, want := range cases {
+		if got, err := v.filename(in); err != nil || got != filepath.FromSlash(want) {
+			t.Errorf("filename(%q) = %q %v, expect %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "..", "a\\..\\..\\b"} {
+		if _, err := v.filename(in); err == nil {
+			t.Errorf("filename(%q) expect error", in)
+		}
+	}
+}
+
+func TestVODPlay(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFLV(t, filepath.Join(dir, "movie.flv"))
+	v := &VOD{Root: dir}
+
+	it, err := v.Open("movie")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if it.Duration() != 360 {
+		t.Fatalf("duration %d, expect 360", it.Duration())
+	}
+	if ts, err := it.Seek(250); err != nil || ts != 200 {
+		t.Fatalf("seek: %d %v", ts, err)
+	}
+	it.Release()
+
+	var w recorder
+	r := Request{App: "vod", Command: CMD_GET_STREAM_LENGTH, TransactionID: 5, StreamPath: "movie"}
+	if err = v.OnCommand(&w, &r); err != nil {
+		t.Fatal(err)
+	}
+	if m := w.msgs[0].(CommandMessage); m.TransactionId != 5 || m.arr[1] != 0.36 {
+		t.Fatalf("getStreamLength response: %+v", m)
+	}
+
+	w = recorder{}
+	r = Request{App: "vod", Command: CMD_PLAY, StreamPath: "movie", Start: -2, Duration: -1}
+	if err = v.OnCommand(&w, &r); err != nil {
+		t.Fatal(err)
+	}
+	waitPlayStop(t, &w)
+	// 2个UserControl，Play.Start，3个配置帧，10帧数据，Play.Complete，StreamEOF，Play.Stop
+	if len(w.msgs) != 19 {
+		t.Fatalf("sent %d messages, expect 19", len(w.msgs))
+	}
+
+	// duration从定位到的关键帧200开始计算，只播放200、240、280
+	w = recorder{}
+	r = Request{App: "vod", Command: CMD_PLAY, StreamPath: "movie", Start: 0.25, Duration: 0.08}
+	if err = v.OnCommand(&w, &r); err != nil {
+		t.Fatal(err)
+	}
+	waitPlayStop(t, &w)
+	if len(w.msgs) != 12 {
+		t.Fatalf("sent %d messages, expect 12", len(w.msgs))
+	}
+
+	w = recorder{}
+	r.StreamPath = "missing"
+	v.OnCommand(&w, &r)
+	if codes := w.codes(); len(codes) != 1 || codes[0] != "NetStream.Play.StreamNotFound" {
+		t.Fatalf("status %v, expect StreamNotFound", codes)
+	}
+}
+
+// 等待播放结束，检查状态的顺序
+func waitPlayStop(t *testing.T, w *recorder) {
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		codes := w.codes()
+		if n := len(codes); n > 0 && codes[n-1] == "NetStream.Play.Stop" {
+			want := []string{"NetStream.Play.Start", "NetStream.Play.Complete", "NetStream.Play.Stop"}
+			if len(codes) != len(want) || codes[1] != want[1] {
+				t.Fatalf("status %v, expect %v", codes, want)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("playback not complete: %v", codes)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}